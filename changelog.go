@@ -0,0 +1,9 @@
+// Package ssv embeds files from the repository root.
+package ssv
+
+import _ "embed"
+
+// Changelog is CHANGELOG.md, used for the "What's new" notice after an update.
+//
+//go:embed CHANGELOG.md
+var Changelog string
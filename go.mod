@@ -8,7 +8,10 @@ require (
 	github.com/urfave/cli/v3 v3.4.1
 	golang.org/x/crypto v0.42.0
 	golang.org/x/mod v0.27.0
+	golang.org/x/sys v0.36.0
 	golang.org/x/time v0.13.0
 )
 
-require golang.org/x/sys v0.36.0 // indirect
+require github.com/BurntSushi/toml v1.6.0
+
+require github.com/coder/websocket v1.8.14
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"ssv/go/services/tasks/buildcache"
+	"ssv/go/services/tasks/project"
+
+	"github.com/urfave/cli/v3"
+)
+
+var Build = &cli.Command{
+	Name:  "build",
+	Usage: "verilate and compile the model of an ssv.toml project",
+	Description: "Reads the " + project.ManifestName + " in the current dir or its closest parent (or --manifest), runs verilator with " +
+		"the sources, filelists, defines, parameters and trace settings it lists, and puts the model in its out_dir. " +
+		"The manifest's pinned verilator version is used unless --verilator-version is given. " +
+		"Unchanged models are restored from the build cache, see 'cache'.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "manifest",
+			Aliases: []string{"m"},
+			Usage:   "path of the manifest",
+		},
+		&cli.IntFlag{
+			Name:    "jobs",
+			Aliases: []string{"j"},
+			Usage:   "parallel compile jobs, overrides the manifest",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the verilator command instead of running it",
+		},
+		&cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "always build, don't use the build cache",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		path := cmd.String("manifest")
+		if path == "" {
+			var err error
+			if path, err = project.Find("."); err != nil {
+				return err
+			}
+		}
+		m, err := project.Load(path)
+		if err != nil {
+			return err
+		}
+		opts := project.BuildOptions{
+			Version: cmd.String("verilator-version"),
+			Jobs:    int(cmd.Int("jobs")),
+			DryRun:  cmd.Bool("dry-run"),
+		}
+		enabled, err := buildcache.Enabled(ctx)
+		if err != nil {
+			return err
+		}
+		if enabled && !cmd.Bool("no-cache") {
+			if opts.Cache, err = buildcache.Open(ctx); err != nil {
+				return err
+			}
+		}
+		product, err := project.Build(ctx, m, opts, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if !cmd.Bool("dry-run") {
+			fmt.Printf("Built %s: %s\n", m.Name, product)
+		}
+		return nil
+	},
+}
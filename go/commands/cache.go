@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"ssv/go/services/tasks/buildcache"
+
+	"github.com/urfave/cli/v3"
+)
+
+var Cache = &cli.Command{
+	Name:  "cache",
+	Usage: "build cache management",
+	Description: "The build cache keeps the obj_dir and executable of 'build' keyed by a hash of the sources, " +
+		"flags, verilator and compiler versions. Its size is limited by the buildCacheMaxSize config value, " +
+		"least recently used builds are evicted first. Set buildCache to false to disable it.",
+	Commands: []*cli.Command{
+		{
+			Name:  "stats",
+			Usage: "show the build cache size and hit rate",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the stats as JSON",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				cache, err := buildcache.Open(ctx)
+				if err != nil {
+					return err
+				}
+				st, err := cache.Stats()
+				if err != nil {
+					return err
+				}
+				if cmd.Bool("json") {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(st)
+				}
+				fmt.Printf("Build cache: %s\n", st.Dir)
+				fmt.Printf("    Builds: %d\n", st.Entries)
+				fmt.Printf("    Size:   %s of %s\n", buildcache.FormatSize(st.Size), buildcache.FormatSize(st.MaxSize))
+				if total := st.Hits + st.Misses; total > 0 {
+					fmt.Printf("    Hits:   %d of %d (%.0f%%)\n", st.Hits, total, 100*float64(st.Hits)/float64(total))
+				} else {
+					fmt.Printf("    Hits:   none yet\n")
+				}
+				if !st.Oldest.IsZero() {
+					fmt.Printf("    Least recently used: %s\n", st.Oldest.Local().Format("2006-01-02 15:04"))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "evict builds from the build cache",
+			Description: "Evicts the least recently used builds until the cache fits its size limit (or --max-size), " +
+				"and builds unused for longer than --older-than.",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "remove every build",
+				},
+				&cli.StringFlag{
+					Name:  "max-size",
+					Usage: "shrink the cache to this size, e.g. 2GiB, instead of its configured limit",
+				},
+				&cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "also remove builds unused for this long, e.g. 720h",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				cache, err := buildcache.Open(ctx)
+				if err != nil {
+					return err
+				}
+				maxSize := int64(-1)
+				switch {
+				case cmd.Bool("all"):
+					maxSize = 0
+				case cmd.String("max-size") != "":
+					if maxSize, err = buildcache.ParseSize(cmd.String("max-size")); err != nil {
+						return err
+					}
+				}
+				removed, freed, err := cache.Prune(maxSize, cmd.Duration("older-than"))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Removed %d build(s), freed %s.\n", removed, buildcache.FormatSize(freed))
+				return nil
+			},
+		},
+	},
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"ssv/go/system/doctor"
+
+	"github.com/urfave/cli/v3"
+)
+
+var Doctor = &cli.Command{
+	Name:        "doctor",
+	Usage:       "check the installation for problems",
+	Description: "Checks verilator, the build toolchain, disk space, the data dirs, database, config, email and the service unit. Exits non-zero if a check fails, warnings don't.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the results as JSON, for CI",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		unit, err := appUnit(ctx)
+		if err != nil {
+			return err
+		}
+		results := doctor.Run(ctx, doctor.Options{
+			VerilatorVersion: cmd.String("verilator-version"),
+			Unit:             unit,
+			Command:          appCommand(ctx),
+		})
+		worst := doctor.Worst(results)
+
+		if cmd.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(struct {
+				Status doctor.Status   `json:"status"`
+				Checks []doctor.Result `json:"checks"`
+			}{worst, results}); err != nil {
+				return err
+			}
+		} else {
+			counts := map[doctor.Status]int{}
+			for _, r := range results {
+				counts[r.Status]++
+				mark := map[doctor.Status]string{doctor.Pass: "✓", doctor.Warn: "⚠", doctor.Fail: "✗"}[r.Status]
+				fmt.Printf("%s %s: %s\n", mark, r.Name, r.Message)
+				if r.Hint != "" && r.Status != doctor.Pass {
+					fmt.Printf("    → %s\n", r.Hint)
+				}
+			}
+			fmt.Printf("\n%d passed, %d warning(s), %d failed\n", counts[doctor.Pass], counts[doctor.Warn], counts[doctor.Fail])
+		}
+
+		if worst == doctor.Fail {
+			return fmt.Errorf("some checks failed")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,404 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"ssv/go/services/tasks/jobs"
+	"ssv/go/services/tasks/project"
+	"ssv/go/services/tasks/results"
+	"ssv/go/system/control"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+var Jobs = &cli.Command{
+	Name:  "jobs",
+	Usage: "run lint, build and simulation jobs in the daemon",
+	Description: "Jobs run in the background in the daemon, on a snapshot of the project taken when they are submitted, " +
+		"a few at a time depending on the CPU count (jobWorkers). Each job gets a work dir under the data dir holding " +
+		"the snapshot, its log and outputs. Jobs left running when the daemon stops are requeued. Their commands run " +
+		"sandboxed (jobSandbox): in their own namespaces, seeing the system dirs and verilator installs read-only, " +
+		"only their work dir writable and no network, within the limits of jobLimits.",
+	Commands: []*cli.Command{
+		{
+			Name:      "submit",
+			Usage:     "queue a job: lint, build or run (build, then run the testbench)",
+			ArgsUsage: "<lint|build|run> [args...]",
+			Description: "Extra args go to verilator for lint jobs and to the testbench executable for run jobs, " +
+				"put them after -- when they start with a dash.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "manifest",
+					Aliases: []string{"m"},
+					Usage:   "path of the manifest",
+				},
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "fail the job if it runs longer, default the jobTimeout config value",
+				},
+				&cli.BoolFlag{
+					Name:  "wait",
+					Usage: "wait for the job to finish and exit non-zero unless it succeeded",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if cmd.Args().Len() < 1 {
+					return fmt.Errorf("missing job type, expected lint, build or run")
+				}
+				path := cmd.String("manifest")
+				if path == "" {
+					var err error
+					if path, err = project.Find("."); err != nil {
+						return err
+					}
+				}
+				path, err := filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				spec := jobs.Spec{
+					Type:     cmd.Args().First(),
+					Manifest: path,
+					Version:  cmd.String("verilator-version"),
+					Args:     cmd.Args().Tail(),
+					Timeout:  cmd.Duration("timeout"),
+				}
+				client, err := jobsClient(ctx)
+				if err != nil {
+					return err
+				}
+				j, err := client.SubmitJob(ctx, spec)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Job %s queued: %s %s\n", j.ID, j.Type, j.Project)
+				if !cmd.Bool("wait") {
+					return nil
+				}
+				return waitJob(ctx, client, j.ID)
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list jobs, newest first",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the jobs as JSON",
+				},
+				&cli.StringFlag{
+					Name:  "state",
+					Usage: "only jobs in this state (queued|running|succeeded|failed|cancelled)",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				all, err := jobs.List(ctx)
+				if err != nil {
+					return err
+				}
+				list := []jobs.Job{}
+				for _, j := range all {
+					if state := cmd.String("state"); state == "" || j.State == state {
+						list = append(list, j)
+					}
+				}
+				if cmd.Bool("json") {
+					return printJSON(list)
+				}
+				if len(list) == 0 {
+					fmt.Println("No jobs.")
+					return nil
+				}
+				for _, j := range list {
+					fmt.Printf("%s  %-5s  %-9s  %-16s %s\n", j.ID, j.Type, j.State, j.Project, jobSummary(j))
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "show a job",
+			ArgsUsage: "<id>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the job as JSON",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				j, err := jobs.Get(ctx, cmd.Args().First())
+				if err != nil {
+					return err
+				}
+				if cmd.Bool("json") {
+					return printJSON(j)
+				}
+				printJob(j)
+				return nil
+			},
+		},
+		{
+			Name:      "logs",
+			Usage:     "print the log of a job",
+			ArgsUsage: "<id>",
+			Description: "With --follow the log is streamed from the daemon until the job finishes, reconnecting " +
+				"where it left off if the connection drops. Interrupting stops following, not the job.",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "follow",
+					Aliases: []string{"f"},
+					Usage:   "keep printing the log as it's written, exit non-zero unless the job succeeded",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				j, err := jobs.Get(ctx, cmd.Args().First())
+				if err != nil {
+					return err
+				}
+				if !cmd.Bool("follow") {
+					f, err := j.OpenLog()
+					if errors.Is(err, fs.ErrNotExist) {
+						return nil // not started yet
+					} else if err != nil {
+						return err
+					}
+					defer f.Close()
+					_, err = io.Copy(os.Stdout, f)
+					return err
+				}
+				client, err := jobsClient(ctx)
+				if err != nil {
+					return err
+				}
+				return followJob(ctx, client, j.ID)
+			},
+		},
+		{
+			Name:      "report",
+			Usage:     "print the test report of a run job",
+			ArgsUsage: "<id>",
+			Description: "Run jobs detect test results in the simulation output: %Error and %Fatal lines, failed " +
+				"assertions and the patterns of the manifest's [results] section. The report lists each test with its " +
+				"duration, seed and an excerpt of its failure. The daemon serves it too, at " +
+				"/api/v1/jobs/<id>/report?format=junit|json.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "format",
+					Aliases: []string{"f"},
+					Value:   results.FormatJUnit,
+					Usage:   "output format (junit|json|text)",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "write the report to this file instead of stdout",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				j, err := jobs.Get(ctx, cmd.Args().First())
+				if err != nil {
+					return err
+				}
+				format, source := cmd.String("format"), cmd.String("format")
+				switch format {
+				case results.FormatJUnit, results.FormatJSON:
+				case results.FormatText:
+					source = results.FormatJSON // rendered from the JSON report
+				default:
+					return fmt.Errorf("unknown format %q, expected junit, json or text", format)
+				}
+				f, err := j.OpenReport(source)
+				if errors.Is(err, fs.ErrNotExist) {
+					return fmt.Errorf("job %s has no test report", j.ID)
+				} else if err != nil {
+					return err
+				}
+				data, err := io.ReadAll(f)
+				f.Close()
+				if err != nil {
+					return err
+				}
+				if format == results.FormatText {
+					var report results.Report
+					if err := json.Unmarshal(data, &report); err != nil {
+						return fmt.Errorf("failed to read test report: %w", err)
+					}
+					var b strings.Builder
+					report.Write(&b, format)
+					data = []byte(b.String())
+				}
+				if output := cmd.String("output"); output != "" {
+					return os.WriteFile(output, data, 0o644)
+				}
+				_, err = os.Stdout.Write(data)
+				return err
+			},
+		},
+		{
+			Name:      "cancel",
+			Usage:     "cancel a queued or running job",
+			ArgsUsage: "<id>",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				client, err := jobsClient(ctx)
+				if err != nil {
+					return err
+				}
+				j, err := client.CancelJob(ctx, cmd.Args().First())
+				if err != nil {
+					return err
+				}
+				if j.State == jobs.StateRunning {
+					fmt.Printf("Cancelling job %s.\n", j.ID)
+				} else {
+					fmt.Printf("Job %s %s.\n", j.ID, j.State)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// jobsClient returns a control client, failing with a hint when the daemon isn't running.
+func jobsClient(ctx context.Context) (*control.Client, error) {
+	client, err := control.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.Version(ctx); errors.Is(err, control.ErrNotRunning) {
+		return nil, fmt.Errorf("jobs run in the daemon, start it with '%s service start'", appCommand(ctx))
+	} else if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// waitJob polls a job until it finishes. Interrupting cancels it.
+func waitJob(ctx context.Context, client *control.Client, id string) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	state := jobs.StateQueued // printed on submit
+	for {
+		j, err := client.Job(ctx, id)
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to get job %s: %w", id, err)
+		}
+		if err == nil {
+			if j.State != state {
+				state = j.State
+				fmt.Printf("Job %s %s\n", j.ID, state)
+			}
+			if j.Finished() {
+				printJob(j)
+				if j.State != jobs.StateSucceeded {
+					return fmt.Errorf("job %s %s", j.ID, j.State)
+				}
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := client.CancelJob(cancelCtx, id); err != nil {
+				return fmt.Errorf("failed to cancel job %s: %w", id, err)
+			}
+			return fmt.Errorf("job %s cancelled", id)
+		case <-ticker.C:
+		}
+	}
+}
+
+// followJob prints a job's log as it's written until the job finishes, resuming after
+// dropped connections and daemon restarts. Errors the daemon answers with are returned.
+func followJob(ctx context.Context, client *control.Client, id string) error {
+	var offset int64
+	backoff := time.Second
+	for {
+		j, n, err := client.FollowJobLogs(ctx, id, offset, true, os.Stdout)
+		if n > offset {
+			offset, backoff = n, time.Second
+		}
+		if err == nil {
+			if j.State != jobs.StateSucceeded {
+				return fmt.Errorf("job %s %s", j.ID, j.State)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !transient(err) {
+			return fmt.Errorf("failed to follow job %s: %w", id, err)
+		}
+		fmt.Fprintf(os.Stderr, "Log stream interrupted (%s), reconnecting in %s\n", err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// transient reports whether following a log can resume after err: the daemon isn't running
+// or the connection to it failed.
+func transient(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, control.ErrNotRunning) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+func printJob(j jobs.Job) {
+	field := func(label, format string, args ...any) {
+		fmt.Printf("    %-10s %s\n", label+":", fmt.Sprintf(format, args...))
+	}
+	fmt.Printf("Job %s\n", j.ID)
+	field("Type", "%s", strings.TrimSpace(j.Type+" "+strings.Join(j.Args, " ")))
+	field("Project", "%s (%s)", j.Project, j.Manifest)
+	field("State", "%s", j.State)
+	if j.Version != "" {
+		field("Verilator", "%s", j.Version)
+	}
+	field("Created", "%s", j.CreatedAt.Local().Format(time.DateTime))
+	if !j.StartedAt.IsZero() && j.Attempts > 0 {
+		field("Started", "%s (attempt %d)", j.StartedAt.Local().Format(time.DateTime), j.Attempts)
+	}
+	if !j.EndedAt.IsZero() && !j.StartedAt.IsZero() {
+		field("Duration", "%s", j.EndedAt.Sub(j.StartedAt).Round(time.Millisecond))
+	}
+	if j.Result != "" {
+		field("Result", "%s", j.Result)
+	}
+	if j.Error != "" {
+		field("Error", "%s", j.Error)
+	}
+	field("Work dir", "%s", j.Dir())
+	field("Log", "%s", j.Log())
+}
+
+// jobSummary is the last column of jobs list.
+func jobSummary(j jobs.Job) string {
+	switch {
+	case j.Error != "":
+		return j.Error
+	case j.Result != "":
+		return j.Result
+	case j.State == jobs.StateRunning:
+		return "since " + j.StartedAt.Local().Format(time.DateTime)
+	}
+	return "submitted " + j.CreatedAt.Local().Format(time.DateTime)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"ssv/go/services/tasks/lint"
+	"ssv/go/services/tasks/project"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Lint exit codes.
+const (
+	lintClean    = 0
+	lintFindings = 1 // new findings at or above --fail-on
+	lintFailed   = 2 // verilator or the command itself failed
+)
+
+// ExitStatus makes the program exit with Code, printing Err unless nil. It's not a
+// cli.ExitCoder, those make urfave/cli exit before the deferred cleanup in main runs.
+type ExitStatus struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitStatus) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitStatus) Unwrap() error { return e.Err }
+
+var Lint = &cli.Command{
+	Name:      "lint",
+	Usage:     "lint the design with verilator --lint-only",
+	ArgsUsage: "[files and verilator args...]",
+	Description: "Lints the sources of the " + project.ManifestName + " in the current dir or its closest parent (or --manifest), " +
+		"plus the given files and verilator args, or only those outside a project. Prints the warnings and errors as text, " +
+		"JSON or SARIF 2.1 for code scanning tools.\n\n" +
+		"Warnings listed in the --baseline file don't fail the run, --update-baseline records the current ones in it.\n\n" +
+		"Exit codes: 0 clean, 1 new findings at or above --fail-on, 2 verilator or the command failed.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "manifest",
+			Aliases: []string{"m"},
+			Usage:   "path of the manifest",
+		},
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Value:   lint.FormatText,
+			Usage:   "output format (text|json|sarif)",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "write the report to this file instead of stdout",
+		},
+		&cli.StringFlag{
+			Name:  "baseline",
+			Usage: "JSON file of known warnings to suppress",
+		},
+		&cli.BoolFlag{
+			Name:  "update-baseline",
+			Usage: "write the current warnings to the --baseline file",
+		},
+		&cli.StringFlag{
+			Name:  "fail-on",
+			Value: "warning",
+			Usage: "lowest severity failing the run (error|warning|none)",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		if err := runLint(ctx, cmd); err != nil {
+			var status *ExitStatus
+			if errors.As(err, &status) {
+				return err
+			}
+			return &ExitStatus{Code: lintFailed, Err: err}
+		}
+		return nil
+	},
+}
+
+func runLint(ctx context.Context, cmd *cli.Command) error {
+	format, failOn := cmd.String("format"), cmd.String("fail-on")
+	switch format {
+	case lint.FormatText, lint.FormatJSON, lint.FormatSARIF:
+	default:
+		return fmt.Errorf("unknown format %q, expected text, json or sarif", format)
+	}
+	switch failOn {
+	case "error", "warning", "none":
+	default:
+		return fmt.Errorf("unknown --fail-on %q, expected error, warning or none", failOn)
+	}
+	baselinePath := cmd.String("baseline")
+	if cmd.Bool("update-baseline") && baselinePath == "" {
+		return errors.New("--update-baseline needs --baseline")
+	}
+
+	opts := lint.Options{Version: cmd.String("verilator-version"), Dir: ".", Args: cmd.Args().Slice()}
+	path := cmd.String("manifest")
+	if path == "" {
+		path, _ = project.Find(".") // no project lints only the args
+	}
+	if path != "" {
+		m, err := project.Load(path)
+		if err != nil {
+			return err
+		}
+		opts.Manifest = m
+	}
+	report, err := lint.Run(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if baselinePath != "" {
+		var baseline *lint.Baseline
+		if cmd.Bool("update-baseline") {
+			baseline = lint.NewBaseline(report)
+			if err := baseline.Save(baselinePath); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %d known warnings to %s\n", len(baseline.Warnings), baselinePath)
+		} else if baseline, err = lint.LoadBaseline(baselinePath); err != nil {
+			return err
+		}
+		baseline.Apply(report)
+	}
+
+	var w io.Writer = os.Stdout
+	if output := cmd.String("output"); output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := report.Write(w, format); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, report.Summary())
+	}
+
+	errs, warnings, _ := report.Counts()
+	if errs > 0 && failOn != "none" || warnings > 0 && failOn == "warning" {
+		return &ExitStatus{Code: lintFindings}
+	}
+	return nil
+}
@@ -2,62 +2,54 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"ssv/go/app"
 	"ssv/go/database/config"
 	"ssv/go/database/datapath"
 	"ssv/go/server"
+	"ssv/go/services/tasks/jobs"
+	"ssv/go/services/tasks/verilator"
+	"ssv/go/system/control"
+	"ssv/go/system/instance"
+	"ssv/go/system/sdnotify"
+	"ssv/go/system/systemd"
 	"ssv/go/system/update"
 	"ssv/go/x"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/Data-Corruption/stdx/xhttp"
 	"github.com/Data-Corruption/stdx/xlog"
 	"github.com/Data-Corruption/stdx/xnet"
+	"github.com/Data-Corruption/stdx/xterm/prompt"
 	"github.com/urfave/cli/v3"
 )
 
 var Service = &cli.Command{
 	Name:  "service",
 	Usage: "service management commands",
-	Action: func(ctx context.Context, cmd *cli.Command) error {
-		// get service name
-		appData, ok := app.FromContext(ctx)
-		if !ok {
-			return fmt.Errorf("failed to get appData from context")
-		}
-		if appData.Name == "" {
-			return fmt.Errorf("app name not found")
-		}
-		serviceName := appData.Name + ".service"
-
-		// get env file path
-		dataPath := datapath.FromContext(ctx)
-		envFilePath := fmt.Sprintf("%s/%s.env", dataPath, appData.Name)
-
-		// print service management commands
-		fmt.Printf("🖧 Service Cheat Sheet\n")
-		fmt.Printf("    Start:   systemctl --user start %s\n", serviceName)
-		fmt.Printf("    Stop:    systemctl --user stop %s\n", serviceName)
-		fmt.Printf("    Status:  systemctl --user status %s\n", serviceName)
-		fmt.Printf("    Restart: systemctl --user restart %s\n", serviceName)
-		fmt.Printf("    Reset:   systemctl --user reset-failed %s\n", serviceName)
-		fmt.Printf("    Enable:  systemctl --user enable %s\n", serviceName)
-		fmt.Printf("    Disable: systemctl --user disable %s\n", serviceName)
-		fmt.Printf("    Logs:    journalctl --user -u %s -n 200 --no-pager\n", serviceName)
-		fmt.Printf("    Env:     edit %s then restart the service\n", envFilePath)
-
-		fmt.Println("\nIf you've manually edited the unit file, you'll need to reload the systemd")
-		fmt.Println("manager configuration 'systemctl --user daemon-reload'. Keep in mind updating")
-		fmt.Println("will overwrite your changes, so keep a backup.")
-
-		return nil
-	},
 	Commands: []*cli.Command{
 		{
 			Name:        "run",
 			Description: "Runs service in foreground. Typically called by systemd. If you need to run it manually/unmanaged, use this command.",
 			Action: func(ctx context.Context, cmd *cli.Command) error {
+				// one daemon per data dir, held until we exit (or handed off on update)
+				lock, err := instance.Acquire(ctx)
+				if err != nil {
+					var locked *instance.LockedError
+					if errors.As(err, &locked) {
+						return fmt.Errorf("%w, stop it first with '%s service stop'", err, appCommand(ctx))
+					}
+					return err
+				}
+				defer lock.Release()
+
 				// wait for network (systemd user mode Wants/After is unreliable)
 				if err := xnet.Wait(ctx, 0); err != nil {
 					return fmt.Errorf("failed to wait for network: %w", err)
@@ -85,40 +77,106 @@ var Service = &cli.Command{
 				}
 				isTLS := port == 443
 				appData.UrlPrefix = fmt.Sprintf("http%s://%s%s/", x.Ternary(isTLS, "s", ""), host, x.Ternary(isTLS, "", fmt.Sprintf(":%d", port)))
+				ctx = server.UrlPrefixIntoContext(ctx, appData.UrlPrefix)
 
 				// TODO pass appData pointer into router creation func or smth
 
-				var srv *xhttp.Server
+				// lint, build and simulation jobs, requeued when the daemon stops
+				jobsCtx, stopJobs := context.WithCancel(ctx)
+				engine, err := jobs.NewEngine(jobsCtx)
+				if err != nil {
+					stopJobs()
+					return fmt.Errorf("failed to create job engine: %w", err)
+				}
+				defer func() {
+					stopJobs()
+					engine.Wait()
+				}()
+				if err := engine.Start(); err != nil {
+					return err
+				}
 
 				// hello world handler
 				mux := http.NewServeMux()
 				mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte("Hello World 4\n"))
 				})
-				mux.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
-					// daemon update example. add auth ofc, etc
-					w.Write([]byte("Starting update...\n"))
-					if err := update.Update(ctx, true); err != nil {
-						xlog.Errorf(ctx, "/update update start failed: %s", err)
-					}
-				})
-				mux.HandleFunc("/shutdown", func(w http.ResponseWriter, r *http.Request) {
-					// daemon shutdown example. add auth ofc, etc
-					if srv != nil {
-						w.Write([]byte("Shutting down...\n"))
-						if err := srv.Shutdown(context.TODO()); err != nil {
-							xlog.Errorf(ctx, "/shutdown srv.Shutdown failed: %s", err)
-						}
-					}
-					w.Write([]byte("Internal server error\n"))
-					w.WriteHeader(500)
-				})
+				mux.Handle("GET /api/v1/jobs/{id}/logs", jobs.LocalOnly(http.HandlerFunc(engine.ServeLogs)))
+				mux.Handle("GET /api/v1/jobs/{id}/report", jobs.LocalOnly(http.HandlerFunc(engine.ServeReport)))
 
 				// create server
-				srv, err = server.New(ctx, mux)
+				srv, err := server.New(ctx, mux)
 				if err != nil {
 					return fmt.Errorf("failed to create server: %w", err)
 				}
+				ctx = server.IntoContext(ctx, srv)
+				srv.OnShutdown(engine.StopStreams)
+				srv.PassOnHandoff(instance.LockFDEnv, lock.File())
+				if err := lock.WriteStatus(instance.Info{Version: appData.Version, StartedAt: time.Now(), Listeners: srv.Addrs()}); err != nil {
+					xlog.Warnf(ctx, "failed to write status file: %s", err)
+				}
+
+				// background verilator builds, stopped when the daemon exits
+				buildCtx, stopBuilds := context.WithCancel(ctx)
+				defer stopBuilds()
+				builder := verilator.NewBuilder(buildCtx)
+
+				activeJobs := func() int { return builder.Active() + engine.Active() }
+
+				// local control API, replaces the old unauthenticated /update and /shutdown endpoints
+				ctl, err := control.Listen(ctx, control.Hooks{
+					ActiveJobs:  activeJobs,
+					Listeners:   srv.Addrs,
+					Connections: srv.Connections,
+					Reload:      reloadConfig,
+					Update: func(ctx context.Context) error {
+						return update.Update(ctx, update.Options{Detach: true})
+					},
+					Shutdown: func() {
+						if err := srv.Shutdown(context.Background()); err != nil {
+							xlog.Errorf(ctx, "control shutdown failed: %s", err)
+						}
+					},
+					VerilatorBuild:  builder.Start,
+					VerilatorStatus: builder.Get,
+					VerilatorCancel: builder.Cancel,
+					JobSubmit:       engine.Submit,
+					JobCancel:       engine.Cancel,
+					JobLogs:         engine.ServeLogs,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to start control socket: %w", err)
+				}
+				defer ctl.Close()
+
+				// watchdog + STATUS= heartbeat, withholds pings if the http server or the job
+				// dispatcher stops answering
+				go sdnotify.Supervise(ctx, sdnotify.Heartbeat{
+					Checks: []sdnotify.Check{{Name: "http", Fn: srv.HealthCheck}, {Name: "jobs", Fn: engine.HealthCheck}},
+					Status: func() string {
+						return fmt.Sprintf("Listening on %s, %d connection(s), %d job(s) running", strings.Join(srv.Addrs(), ", "), srv.Connections(), activeJobs())
+					},
+				})
+
+				// opt-in auto-update during the maintenance window
+				go update.AutoUpdate(ctx, update.Jobs{Active: activeJobs, Pause: engine.Pause, Resume: engine.Resume})
+
+				// SIGHUP (systemctl reload) reloads the config, same as the control API
+				hupCh := make(chan os.Signal, 1)
+				signal.Notify(hupCh, syscall.SIGHUP)
+				defer signal.Stop(hupCh)
+				go func() {
+					for {
+						select {
+						case <-ctx.Done():
+							return
+						case <-hupCh:
+							if err := reloadConfig(ctx); err != nil {
+								xlog.Errorf(ctx, "SIGHUP reload failed: %s", err)
+							}
+						}
+					}
+				}()
 
 				// start http server
 				if err := srv.Listen(); err != nil {
@@ -130,5 +188,247 @@ var Service = &cli.Command{
 				return nil
 			},
 		},
+		{
+			Name:  "install",
+			Usage: "install (or repair) the systemd user unit, then enable and start it",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if err := systemd.CheckVersion(ctx); err != nil {
+					return err
+				}
+				unit, err := appUnit(ctx)
+				if err != nil {
+					return err
+				}
+				installed, drifted, err := systemd.Drift(unit)
+				if err != nil {
+					return err
+				}
+				if drifted && !cmd.Bool("yes") {
+					path, _ := systemd.UnitPath(unit.Name)
+					fmt.Printf("%s differs from the expected unit (manual edit or older version).\n", path)
+					ok, err := prompt.YesNo("Overwrite it with the expected unit?")
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return fmt.Errorf("aborted, unit left unchanged")
+					}
+				}
+				if !installed || drifted {
+					fmt.Printf("Writing %s ...\n", unit.Name)
+					if err := systemd.WriteUnit(unit); err != nil {
+						return err
+					}
+					if err := systemd.DaemonReload(ctx); err != nil {
+						return err
+					}
+				}
+				if err := systemd.Enable(ctx, unit.Name); err != nil {
+					return err
+				}
+				_ = systemd.ResetFailed(ctx, unit.Name)
+				fmt.Printf("Starting %s (waiting up to %s for it to become ready) ...\n", unit.Name, unit.ReadyTimeout)
+				if err := systemd.StartAndWait(ctx, unit.Name, unit.ReadyTimeout); err != nil {
+					return err
+				}
+				fmt.Printf("🖧 %s installed and running\n", unit.Name)
+				return nil
+			},
+		},
+		{
+			Name:  "uninstall",
+			Usage: "stop, disable and remove the systemd user unit",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				unit, err := appUnit(ctx)
+				if err != nil {
+					return err
+				}
+				if systemd.IsActive(ctx, unit.Name) {
+					if err := systemd.Stop(ctx, unit.Name); err != nil {
+						return err
+					}
+				}
+				if systemd.IsEnabled(ctx, unit.Name) {
+					if err := systemd.Disable(ctx, unit.Name); err != nil {
+						return err
+					}
+				}
+				if err := systemd.RemoveUnit(unit.Name); err != nil {
+					return err
+				}
+				if err := systemd.DaemonReload(ctx); err != nil {
+					return err
+				}
+				_ = systemd.ResetFailed(ctx, unit.Name)
+				fmt.Printf("%s removed\n", unit.Name)
+				return nil
+			},
+		},
+		{
+			Name:  "enable",
+			Usage: "start the service automatically on login",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				unit, err := appUnit(ctx)
+				if err != nil {
+					return err
+				}
+				return systemd.Enable(ctx, unit.Name)
+			},
+		},
+		{
+			Name:  "disable",
+			Usage: "don't start the service automatically on login",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				unit, err := appUnit(ctx)
+				if err != nil {
+					return err
+				}
+				return systemd.Disable(ctx, unit.Name)
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "show the status of the running service",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				client, err := control.NewClient(ctx)
+				if err != nil {
+					return err
+				}
+				// unit drift is worth mentioning whether or not the daemon is up
+				if unit, err := appUnit(ctx); err == nil {
+					if _, drifted, err := systemd.Drift(unit); err == nil && drifted {
+						fmt.Printf("⚠ %s differs from the expected unit, run '%s service install' to repair it\n", unit.Name, appCommand(ctx))
+					}
+				}
+				st, err := client.Status(ctx)
+				if errors.Is(err, control.ErrNotRunning) {
+					// the lock tells a daemon that's starting or stuck apart from none at all
+					if info, running, lErr := instance.Running(ctx); lErr == nil && running {
+						fmt.Printf("🖧 Service is running (pid %d) but not answering on the control socket\n", info.PID)
+						return nil
+					}
+					fmt.Println("🖧 Service is not running")
+					return nil
+				} else if err != nil {
+					return err
+				}
+				fmt.Printf("🖧 %s %s is running\n", st.Name, st.Version)
+				if st.Instance != "" {
+					fmt.Printf("    Instance:    %s\n", st.Instance)
+				}
+				fmt.Printf("    PID:         %d%s\n", st.PID, x.Ternary(st.Systemd, " (systemd)", ""))
+				fmt.Printf("    Data dir:    %s\n", st.DataDir)
+				fmt.Printf("    Uptime:      %s (since %s)\n", st.Uptime, st.StartedAt.Format(time.RFC3339))
+				fmt.Printf("    Active jobs: %d\n", st.ActiveJobs)
+				fmt.Printf("    Connections: %d\n", st.Connections)
+				for _, l := range st.Listeners {
+					fmt.Printf("    Listening:   %s\n", l)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "reload",
+			Usage: "make the running service re-read its config",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				client, err := control.NewClient(ctx)
+				if err != nil {
+					return err
+				}
+				if err := client.Reload(ctx); err != nil {
+					return err
+				}
+				fmt.Println("Service config reloaded.")
+				return nil
+			},
+		},
+		{
+			Name:  "stop",
+			Usage: "gracefully stop the running service",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				client, err := control.NewClient(ctx)
+				if err != nil {
+					return err
+				}
+				st, err := client.Status(ctx)
+				if err != nil {
+					return err
+				}
+				// under systemd an exit would just be restarted (Restart=always), so ask systemd instead
+				if st.Systemd {
+					c := exec.CommandContext(ctx, "systemctl", "--user", "stop", systemd.UnitName(st.Name, st.Instance))
+					c.Stdout, c.Stderr = os.Stdout, os.Stderr
+					if err := c.Run(); err != nil {
+						return fmt.Errorf("systemctl stop failed: %w", err)
+					}
+				} else if err := client.Shutdown(ctx); err != nil {
+					return err
+				}
+				fmt.Println("Service stopped.")
+				return nil
+			},
+		},
 	},
 }
+
+// reloadConfig re-applies config values the daemon caches at startup.
+// systemd is told about the reload so `systemctl reload` waits for it.
+func reloadConfig(ctx context.Context) error {
+	if err := sdnotify.Reloading("Reloading config"); err != nil {
+		xlog.Debugf(ctx, "sd_notify RELOADING failed: %v", err)
+	}
+	defer func() {
+		if err := sdnotify.Ready("Config reloaded"); err != nil {
+			xlog.Debugf(ctx, "sd_notify READY failed: %v", err)
+		}
+	}()
+	logLevel, err := config.Get[string](ctx, "logLevel")
+	if err != nil {
+		return fmt.Errorf("failed to get log level from config: %w", err)
+	}
+	if log := xlog.FromContext(ctx); log != nil {
+		if err := log.SetLevel(logLevel); err != nil {
+			return fmt.Errorf("failed to set log level: %w", err)
+		}
+	}
+	return nil
+}
+
+// appUnit returns the expected systemd unit for this installation.
+func appUnit(ctx context.Context) (systemd.Unit, error) {
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return systemd.Unit{}, fmt.Errorf("failed to get appData from context")
+	}
+	dirs := datapath.DirsFromContext(ctx)
+	if dirs.Data == "" {
+		return systemd.Unit{}, fmt.Errorf("data path not set in context")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return systemd.Unit{}, fmt.Errorf("failed to get executable path: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return systemd.Unit{}, fmt.Errorf("failed to resolve executable path: %w", err)
+	}
+	description := "web server daemon for CLI application " + appData.Name
+	if dirs.Options.Instance != "" {
+		description += " (instance " + dirs.Options.Instance + ")"
+	}
+	return systemd.Unit{
+		Name:             systemd.UnitName(appData.Name, dirs.Options.Instance),
+		Description:      description,
+		Exec:             exe,
+		Args:             append(dirs.Args(), "service", "run"),
+		WorkingDirectory: dirs.Data,
+		EnvironmentFile:  filepath.Join(dirs.Data, appData.Name+".env"),
+		ReadyTimeout:     systemd.DefaultReadyTimeout,
+		Watchdog:         systemd.DefaultWatchdog,
+	}, nil
+}
+
+// appCommand returns how to invoke this instance, for hints, e.g. "ssv --instance staging".
+func appCommand(ctx context.Context) string {
+	appData, _ := app.FromContext(ctx)
+	return strings.Join(append([]string{appData.Name}, datapath.DirsFromContext(ctx).Args()...), " ")
+}
@@ -5,17 +5,228 @@ package commands
 import (
 	"context"
 	"fmt"
+	"os"
 	"ssv/go/database/config"
 	"ssv/go/system/update"
+	"ssv/go/x"
+	"ssv/go/x/cron"
+	"time"
 
+	"github.com/Data-Corruption/stdx/xterm/prompt"
 	"github.com/urfave/cli/v3"
 )
 
 var Update = &cli.Command{
 	Name:  "update",
 	Usage: "update the application",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "install this version instead of the latest on the channel, downgrades ask for confirmation",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "install a downloaded release asset (e.g. ./linux-amd64.gz) with its .sha256 and .minisig next to it, for offline hosts",
+		},
+		&cli.BoolFlag{
+			Name:  "changelog",
+			Usage: "show the release notes between the installed version and the one that would be installed, without updating",
+		},
+		&cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "apply updates without a valid release signature (the checksum is still verified)",
+		},
+	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
-		return update.Update(ctx, false)
+		if cmd.String("from") != "" && cmd.String("to") != "" {
+			return fmt.Errorf("--from and --to can't be used together")
+		}
+		if cmd.Bool("changelog") {
+			return update.Changelog(ctx, cmd.String("to"), os.Stdout)
+		}
+		return update.Update(ctx, update.Options{
+			Insecure: cmd.Bool("insecure"),
+			To:       cmd.String("to"),
+			From:     cmd.String("from"),
+			Confirm:  confirm(cmd),
+		})
+	},
+	Commands: []*cli.Command{
+		{
+			Name:  "check",
+			Usage: "check for a newer version on the update channel",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "quiet",
+					Usage: "only record the result for the update notice",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				available, err := update.Check(ctx)
+				if err != nil || cmd.Bool("quiet") {
+					return err
+				}
+				if !available {
+					fmt.Println("No updates available.")
+					return nil
+				}
+				latest, err := config.Get[string](ctx, "latestVersion")
+				if err != nil {
+					return fmt.Errorf("failed to get latestVersion from config: %w", err)
+				}
+				fmt.Printf("New version available: %s\n", latest)
+				return nil
+			},
+		},
+		{
+			Name:      "channel",
+			Usage:     "show or set the update channel",
+			ArgsUsage: "[stable|prerelease]",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if cmd.Args().Len() == 0 {
+					channel, err := update.Channel(ctx)
+					if err != nil {
+						return err
+					}
+					fmt.Println(channel)
+					return nil
+				}
+				channel := cmd.Args().First()
+				if err := update.ValidateChannel(channel); err != nil {
+					return err
+				}
+				if err := config.Set(ctx, "updateChannel", channel); err != nil {
+					return fmt.Errorf("failed to set updateChannel in config: %w", err)
+				}
+				fmt.Printf("Update channel set to %s.\n", channel)
+				return nil
+			},
+		},
+		{
+			Name:      "pin",
+			Usage:     "stay on a version, update notifications are suppressed while pinned",
+			ArgsUsage: "<version>",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if cmd.Args().Len() != 1 {
+					return fmt.Errorf("expected exactly one version")
+				}
+				version, err := update.NormalizeVersion(cmd.Args().First())
+				if err != nil {
+					return err
+				}
+				if err := config.Set(ctx, "pinnedVersion", version); err != nil {
+					return fmt.Errorf("failed to set pinnedVersion in config: %w", err)
+				}
+				if err := config.Set(ctx, "updateAvailable", false); err != nil {
+					return fmt.Errorf("failed to set updateAvailable in config: %w", err)
+				}
+				fmt.Printf("Pinned to %s, run 'update' to install it if needed.\n", version)
+				return nil
+			},
+		},
+		{
+			Name:      "rollback",
+			Usage:     "reinstall a previous version kept by the updater, the most recent one by default",
+			ArgsUsage: "[version]",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				return update.Rollback(ctx, cmd.Args().First(), confirm(cmd))
+			},
+		},
+		{
+			Name:      "auto",
+			Usage:     "show or set daemon auto-updates during a maintenance window",
+			ArgsUsage: "[on|off]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "window",
+					Usage: "cron expression (local time) for the start of the maintenance window, e.g. \"0 3 * * 0\"",
+				},
+				&cli.DurationFlag{
+					Name:  "length",
+					Usage: "how long after the window start an update may begin",
+				},
+				&cli.DurationFlag{
+					Name:  "drain",
+					Usage: "max wait for running jobs to finish before updating anyway",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				switch cmd.Args().First() {
+				case "", "on", "off":
+				default:
+					return fmt.Errorf("expected on or off, got %q", cmd.Args().First())
+				}
+				if cmd.IsSet("window") {
+					if _, err := cron.Parse(cmd.String("window")); err != nil {
+						return err
+					}
+				}
+				if cmd.Args().First() != "" {
+					if err := config.Set(ctx, "autoUpdate", cmd.Args().First() == "on"); err != nil {
+						return fmt.Errorf("failed to set autoUpdate in config: %w", err)
+					}
+				}
+				if cmd.IsSet("window") {
+					if err := config.Set(ctx, "autoUpdateWindow", cmd.String("window")); err != nil {
+						return fmt.Errorf("failed to set autoUpdateWindow in config: %w", err)
+					}
+				}
+				if cmd.IsSet("length") {
+					if err := config.Set(ctx, "autoUpdateWindowLength", cmd.Duration("length").String()); err != nil {
+						return fmt.Errorf("failed to set autoUpdateWindowLength in config: %w", err)
+					}
+				}
+				if cmd.IsSet("drain") {
+					if err := config.Set(ctx, "autoUpdateDrainTimeout", cmd.Duration("drain").String()); err != nil {
+						return fmt.Errorf("failed to set autoUpdateDrainTimeout in config: %w", err)
+					}
+				}
+
+				w, err := update.LoadWindow(ctx)
+				if err != nil {
+					return err
+				}
+				window, _ := config.Get[string](ctx, "autoUpdateWindow")
+				fmt.Printf("Auto-update:   %s\n", x.Ternary(w.Enabled, "on", "off"))
+				fmt.Printf("Window:        %s, %s long\n", window, w.Length)
+				fmt.Printf("Drain timeout: %s\n", w.DrainTimeout)
+				if w.Enabled {
+					if next := w.Schedule.Next(time.Now()); !next.IsZero() {
+						fmt.Printf("Next window:   %s\n", next.Format(time.DateTime))
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "list the previous versions kept for rollback",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				entries, err := update.History(ctx)
+				if err != nil {
+					return err
+				}
+				if len(entries) == 0 {
+					fmt.Println("No previous versions kept.")
+					return nil
+				}
+				for _, e := range entries {
+					fmt.Printf("%-12s config %-8s saved %s\n", e.Version, e.ConfigVersion, e.SavedAt.Local().Format(time.DateTime))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "unpin",
+			Usage: "follow the update channel again",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if err := config.Set(ctx, "pinnedVersion", ""); err != nil {
+					return fmt.Errorf("failed to set pinnedVersion in config: %w", err)
+				}
+				fmt.Println("Unpinned.")
+				return nil
+			},
+		},
 	},
 }
 
@@ -40,3 +251,13 @@ var UpdateToggleNotify = &cli.Command{
 		return nil
 	},
 }
+
+// confirm returns a prompt func that answers yes to everything when --yes is given.
+func confirm(cmd *cli.Command) func(string) (bool, error) {
+	return func(p string) (bool, error) {
+		if cmd.Bool("yes") {
+			return true, nil
+		}
+		return prompt.YesNo(p)
+	}
+}
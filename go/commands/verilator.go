@@ -2,49 +2,220 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"ssv/go/services/tasks/verilator"
+	"ssv/go/system/control"
 	"time"
 
+	"github.com/Data-Corruption/stdx/xterm/prompt"
 	"github.com/urfave/cli/v3"
 )
 
 var Verilator = &cli.Command{
-	Name:  "verilator",
-	Usage: "verilator passthrough",
+	Name:            "verilator",
+	Usage:           "verilator passthrough and version management",
+	ArgsUsage:       "[verilator args...]",
+	Description:     "Runs the selected verilator version (or --verilator-version) with the given args, unless the first arg is one of the commands below.",
+	SkipFlagParsing: true,
 	Action: func(ctx context.Context, cmd *cli.Command) error {
-		return verilator.Passthrough(ctx, cmd)
+		return verilator.Passthrough(ctx, cmd.String("verilator-version"), cmd.Args().Slice())
 	},
-}
-
-var InstallVerilator = &cli.Command{
-	Name:  "install-verilator",
-	Usage: "install a specific verilator version",
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:    "version",
-			Usage:   "the version of verilator to install (e.g. v4.212, v5.006, latest(default))",
-			Aliases: []string{"v"},
-			Value:   "latest",
+	Commands: []*cli.Command{
+		{
+			Name:      "install",
+			Usage:     "build and install a verilator version next to the installed ones",
+			ArgsUsage: "[version]",
+			Description: fmt.Sprintf("Builds the given release tag (e.g. v5.020), 'latest', or %s by default (a tarball source's own version). "+
+				"The first installed version is selected, use --use or 'verilator use' to switch to later ones.\n\n"+
+				"Sources: a mirror URL serving <tag>.tar.gz, git+<repo URL>, a local dir of <tag>.tar.gz files or a single tarball "+
+				"for offline installs. Defaults to the 'verilatorSource' config value, or GitHub if unset.", verilator.DefaultVersion),
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:    "threads",
+					Usage:   "override the recommended number of threads to use for building",
+					Aliases: []string{"t"},
+				},
+				&cli.BoolFlag{
+					Name:  "use",
+					Usage: "select the version once installed",
+				},
+				&cli.StringFlag{
+					Name:  "source",
+					Usage: "where to get the sources from, overrides the verilatorSource config value",
+				},
+				&cli.StringFlag{
+					Name:  "sha256",
+					Usage: "checksum of the source tarball, required for mirrors and local tarballs unless pinned in verilatorChecksums",
+				},
+				&cli.BoolFlag{
+					Name:  "daemon",
+					Usage: "build in the running daemon, Ctrl-C cancels the build",
+				},
+				&cli.BoolFlag{
+					Name:  "verbose",
+					Usage: "print the build output, it always goes to the build log",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if cmd.Args().Len() > 1 {
+					return fmt.Errorf("expected at most one version")
+				}
+				opts := verilator.InstallOptions{
+					Version: cmd.Args().First(),
+					Threads: int(cmd.Int("threads")),
+					Use:     cmd.Bool("use"),
+					Source:  cmd.String("source"),
+					SHA256:  cmd.String("sha256"),
+				}
+				if cmd.Bool("daemon") {
+					if cmd.Bool("verbose") {
+						return fmt.Errorf("--verbose can't be used with --daemon, the output is in the build log")
+					}
+					return daemonInstall(ctx, opts)
+				}
+				opts.Progress = printEvent
+				if cmd.Bool("verbose") {
+					opts.Output = os.Stdout
+				}
+				_, err := verilator.Install(ctx, opts, os.Stdout)
+				return err
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list the installed verilator versions",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				tags, err := verilator.Installed(ctx)
+				if err != nil {
+					return err
+				}
+				if len(tags) == 0 {
+					fmt.Printf("No verilator versions installed, run '%s verilator install'.\n", appCommand(ctx))
+					return nil
+				}
+				current, err := verilator.Current(ctx)
+				if err != nil {
+					return err
+				}
+				for i := len(tags) - 1; i >= 0; i-- {
+					if tags[i] == current {
+						fmt.Printf("* %s (current)\n", tags[i])
+					} else {
+						fmt.Printf("  %s\n", tags[i])
+					}
+				}
+				return nil
+			},
 		},
-		&cli.IntFlag{
-			Name:    "threads",
-			Usage:   "override the recommended number of threads to use for building",
-			Aliases: []string{"t"},
-			Value:   0,
+		{
+			Name:      "use",
+			Usage:     "select the verilator version to run",
+			ArgsUsage: "<version>",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if cmd.Args().Len() != 1 {
+					return fmt.Errorf("expected exactly one version")
+				}
+				tag := cmd.Args().First()
+				if err := verilator.Use(ctx, tag); err != nil {
+					return err
+				}
+				fmt.Printf("Using verilator %s.\n", tag)
+				return nil
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "delete an installed verilator version",
+			ArgsUsage: "<version>",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if cmd.Args().Len() != 1 {
+					return fmt.Errorf("expected exactly one version")
+				}
+				tag := cmd.Args().First()
+				current, err := verilator.Current(ctx)
+				if err != nil {
+					return err
+				}
+				if tag == current && !cmd.Bool("yes") {
+					ok, err := prompt.YesNo(fmt.Sprintf("%s is the current version, nothing will be selected after removing it. Continue?", tag))
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return fmt.Errorf("aborted, %s kept", tag)
+					}
+				}
+				if err := verilator.Remove(ctx, tag); err != nil {
+					return err
+				}
+				fmt.Printf("Removed verilator %s.\n", tag)
+				return nil
+			},
 		},
 	},
-	Action: func(ctx context.Context, cmd *cli.Command) error {
-		url := "https://raw.githubusercontent.com/Data-Corruption/ssv/main/scripts/install_verilator.sh"
-		pipeline := fmt.Sprintf("curl -fsSL %s | sh -s version=%s threads=%d", url, cmd.String("version"), cmd.Int("threads"))
+}
+
+// printEvent prints build progress, e.g. "[3/7] autoconf ... done (1.2s)".
+func printEvent(ev verilator.Event) {
+	switch {
+	case !ev.Done:
+		fmt.Printf("[%d/%d] %s ...\n", ev.Index, ev.Total, ev.Stage)
+	case ev.Err != "":
+		fmt.Printf("[%d/%d] %s failed after %s: %s\n", ev.Index, ev.Total, ev.Stage, ev.Duration.Round(100*time.Millisecond), ev.Err)
+	default:
+		fmt.Printf("[%d/%d] %s done (%s)\n", ev.Index, ev.Total, ev.Stage, ev.Duration.Round(100*time.Millisecond))
+	}
+}
 
-		iCtx, cancel := context.WithTimeout(ctx, 1*time.Hour)
-		defer cancel()
+// daemonInstall runs the build in the daemon and follows it until it ends. Interrupting
+// cancels the build.
+func daemonInstall(ctx context.Context, opts verilator.InstallOptions) error {
+	client, err := control.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	st, err := client.StartVerilatorBuild(ctx, opts)
+	if errors.Is(err, control.ErrNotRunning) {
+		return fmt.Errorf("daemon is not running, start it with '%s service start' or build without --daemon", appCommand(ctx))
+	} else if err != nil {
+		return fmt.Errorf("failed to start the build: %w", err)
+	}
+	fmt.Printf("Build %s started in the daemon.\n", st.ID)
 
-		command := exec.CommandContext(iCtx, "sh", "-c", pipeline)
-		command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
-		return command.Run()
-	},
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	printed := 0
+	for {
+		for _, ev := range st.Events[printed:] {
+			printEvent(ev)
+		}
+		printed = len(st.Events)
+		switch st.State {
+		case verilator.BuildDone:
+			fmt.Printf("Verilator %s installed.\n", st.Version)
+			return nil
+		case verilator.BuildFailed, verilator.BuildCanceled:
+			return fmt.Errorf("build %s: %s", st.State, st.Error)
+		}
+		select {
+		case <-ctx.Done():
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := client.CancelVerilatorBuild(cancelCtx, st.ID); err != nil {
+				return fmt.Errorf("failed to cancel build %s: %w", st.ID, err)
+			}
+			return fmt.Errorf("build %s cancelled", st.ID)
+		case <-ticker.C:
+		}
+		next, err := client.VerilatorBuild(ctx, st.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue // interrupted, cancel above
+			}
+			return fmt.Errorf("failed to get build %s: %w", st.ID, err)
+		}
+		st = next
+	}
 }
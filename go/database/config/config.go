@@ -29,6 +29,8 @@ import (
 	"fmt"
 	"ssv/go/database"
 	"ssv/go/database/helpers"
+	"ssv/go/system/sdnotify"
+	"time"
 
 	"github.com/Data-Corruption/lmdb-go/lmdb"
 	"github.com/Data-Corruption/lmdb-go/wrap"
@@ -207,6 +209,9 @@ func (cfg *Config) Migrate() error {
 		migratePath := discVersion + "->" + cfg.Version
 		fmt.Printf("config migration: %s\n", migratePath)
 		if migrationFunc, ok := cfg.Migrations[migratePath]; ok {
+			// migrations can be slow, keep systemd from timing out the start
+			stopExtending := sdnotify.KeepExtending(30 * time.Second)
+			defer stopExtending()
 			if err := migrationFunc(txn, cfg.DBI, cfg.Schemas); err != nil {
 				return fmt.Errorf("migration failed: %w", err)
 			}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"fmt"
+	"ssv/go/database/helpers"
+
 	"github.com/Data-Corruption/lmdb-go/lmdb"
 )
 
@@ -8,6 +11,7 @@ type MigrationFunc func(txn *lmdb.Txn, dbi lmdb.DBI, schemas map[string]schema)
 
 var Migrations = map[string]MigrationFunc{
 	"v0.0.1->v0.0.2": migrateV0_0_1toV0_0_2, // Example
+	"v1.0.0->v1.1.0": migrateV1_0_0toV1_1_0,
 }
 
 // Example migration function
@@ -16,3 +20,23 @@ func migrateV0_0_1toV0_0_2(txn *lmdb.Txn, dbi lmdb.DBI, schemas map[string]schem
 	// Use old schema to read data and new schema to write updated data
 	return nil
 }
+
+// v1.1.0 only adds keys, existing values carry over untouched.
+func migrateV1_0_0toV1_1_0(txn *lmdb.Txn, dbi lmdb.DBI, schemas map[string]schema) error {
+	return addMissingDefaults(txn, dbi, schemas["v1.1.0"])
+}
+
+// addMissingDefaults writes the default value of every key in s that is not already present.
+func addMissingDefaults(txn *lmdb.Txn, dbi lmdb.DBI, s schema) error {
+	for key, value := range s {
+		if _, err := txn.Get(dbi, []byte(key)); err == nil {
+			continue
+		} else if !lmdb.IsNotFound(err) {
+			return fmt.Errorf("failed to check key '%s': %w", key, err)
+		}
+		if err := helpers.MarshalAndPut(txn, dbi, []byte(key), value.DefaultValue()); err != nil {
+			return fmt.Errorf("failed to write default value for key '%s': %w", key, err)
+		}
+	}
+	return nil
+}
@@ -16,7 +16,7 @@ type Example struct {
 */
 
 // Version is the current version of the schema
-const Version = "v1.0.0"
+const Version = "v1.1.0"
 
 // key -> default value
 type schema map[string]valueInterface
@@ -25,6 +25,48 @@ type schema map[string]valueInterface
 // After making changes to the schema, before the next release you must add a new version entry to this variable
 // and migration funcs for it in `migration.go`. The newest version is assumed to be the current version.
 var SchemaRecord = map[string]schema{
+	"v1.1.0": {
+		"version":                &value[string]{"v1.1.0"},
+		"logLevel":               &value[string]{"warn"},
+		"host":                   &value[string]{"localhost"},
+		"port":                   &value[int]{28080},
+		"bindAddress":            &value[[]string]{[]string{}}, // empty means all interfaces. e.g. ["127.0.0.1", "::1", "10.0.0.5:8080"]
+		"unixSocket":             &value[string]{""},           // path of an optional unix socket listener, empty means disabled
+		"unixSocketMode":         &value[string]{"0660"},       // octal permissions applied to the unix socket
+		"proxyPort":              &value[int]{0},               // 0 means no proxy
+		"proxyTLS":               &value[bool]{true},
+		"emailSender":            &value[string]{""},
+		"emailPassword":          &value[string]{""},
+		"ppVersion":              &value[int]{1},     // privacy policy version in use
+		"newPpDate":              &value[string]{""}, // date new pp goes into effect, empty if none, RFC3339 format
+		"updateNotify":           &value[bool]{true},
+		"lastUpdateCheck":        &value[string]{time.Now().Format(time.RFC3339)}, // time of last update check in RFC3339 format
+		"updateAvailable":        &value[bool]{false},
+		"latestVersion":          &value[string]{""},          // latest version seen by the last update check
+		"notesShownFor":          &value[string]{""},          // latest version whose release notes were shown in the update notice
+		"lastRunVersion":         &value[string]{""},          // for the one-time "What's new" notice after an update
+		"updateSource":           &value[string]{""},          // GitHub releases mirror URL, file:// URL or directory, empty means the GitHub repo
+		"updateChannel":          &value[string]{"stable"},    // stable|prerelease
+		"pinnedVersion":          &value[string]{""},          // stay on this version, empty means follow the channel
+		"updateKeepVersions":     &value[int]{3},              // replaced binaries (and db snapshots) kept for `update rollback`
+		"autoUpdate":             &value[bool]{false},         // daemon applies updates itself during the maintenance window
+		"autoUpdateWindow":       &value[string]{"0 3 * * *"}, // cron expression (local time) for the start of the maintenance window
+		"autoUpdateWindowLength": &value[string]{"1h"},        // how long after the start updates may begin
+		"autoUpdateDrainTimeout": &value[string]{"30m"},       // max wait for running jobs before updating anyway
+		"verilatorSource":        &value[string]{""},          // verilator source: tarball mirror URL, git+URL, local dir or tarball, empty means GitHub
+		"buildCache":             &value[bool]{true},          // restore unchanged `ssv build` models from the build cache
+		"buildCacheMaxSize":      &value[string]{"10GiB"},     // least recently used builds are evicted above this
+		"jobWorkers":             &value[int]{0},              // jobs run at once by the daemon, 0 means a quarter of the CPUs
+		"jobTimeout":             &value[string]{"2h"},        // default job timeout, 0 means none
+		"jobMaxAttempts":         &value[int]{2},              // jobs interrupted by daemon crashes this often are failed instead of requeued
+		"jobRetention":           &value[string]{"168h"},      // finished jobs and their work dirs are removed after this, 0 keeps them
+		"jobSandbox":             &value[string]{"auto"},      // on|off|auto: jobs run in namespaces seeing only their work dir, auto when the kernel allows
+		"jobSandboxPaths":        &value[[]string]{nil},       // extra absolute paths visible read-only in sandboxes, e.g. shared IP or DPI libraries
+		"jobLimits": &value[map[string]string]{map[string]string{ // sandbox limits by "*" and job type, which overrides "*"
+			"*": "fsize=16GiB procs=4096 network=off", // keys cpu, memory, fsize, procs and network=on|off, 0 removes a limit
+		}},
+		"verilatorChecksums": &value[map[string]string]{map[string]string{}}, // sha256 of verilator source tarballs by tag, pinned by the first build from GitHub or --sha256
+	},
 	"v1.0.0": {
 		"version":         &value[string]{"v1.0.0"},
 		"logLevel":        &value[string]{"warn"},
@@ -26,14 +26,20 @@ Session:
 	session.<sha256(token)> -> Session struct (JSON)
 	user_sessions.<sha256(user_id)> -> list of session.<sha256(token)> (JSON array)
 
+Job:
+	job.<id> -> Job struct (JSON), see the jobs package
+	queue.<created unix nano>.<id> -> <id> (queued jobs in submit order)
+
 */
 
 const (
 	ConfigDBIName  = "config"
 	UserDBIName    = "user"
 	SessionDBIName = "session"
+	JobDBIName     = "job"
 	// Add more DBI names as needed, e.g. Also update the slice below to include them.
-	// WARNING: If you add more DBIs you'll need to clean and reinitialize the database from scratch pretty sure.
+	// Existing databases get the new DBIs when next opened, wrap.New creates missing ones and
+	// the max number of DBIs isn't stored in the database.
 )
 
 type ctxKey struct{}
@@ -55,11 +61,10 @@ func New(ctx context.Context) (*wrap.DB, error) {
 		return nil, errors.New("nexus data path not set before database initialization")
 	}
 	db, _, err := wrap.New(filepath.Join(path, "db"),
-		[]string{ConfigDBIName, UserDBIName, SessionDBIName},
+		[]string{ConfigDBIName, UserDBIName, SessionDBIName, JobDBIName},
 	)
 	if err != nil {
-		db.Close()
-		return nil, err
+		return nil, err // wrap.New cleans up after itself
 	}
 	return db, nil
 }
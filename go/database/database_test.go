@@ -0,0 +1,35 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/Data-Corruption/lmdb-go/wrap"
+)
+
+// an existing database opened with more DBIs keeps its data and gets the new ones
+func TestNewDBI(t *testing.T) {
+	dir := t.TempDir()
+	db, _, err := wrap.New(dir, []string{ConfigDBIName, UserDBIName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Write(UserDBIName, []byte("user.1"), []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, _, err = wrap.New(dir, []string{ConfigDBIName, UserDBIName, SessionDBIName, JobDBIName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.Read(UserDBIName, []byte("user.1")); err != nil || string(v) != "alice" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if err := db.Write(JobDBIName, []byte("job.1"), []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Read(JobDBIName, []byte("job.1")); err != nil || string(v) != "{}" {
+		t.Fatalf("new dbi: got %q, %v", v, err)
+	}
+}
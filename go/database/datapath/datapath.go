@@ -7,39 +7,165 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
+// Options select the directories of an instance, see [Resolve]. The zero value is the default
+// instance in ~/.<app>.
+type Options struct {
+	Dir      string // explicit data dir (--data-dir / <APP>_HOME), holds everything, overrides the rest
+	Instance string // named instance (--instance / <APP>_INSTANCE), empty for the default one
+	XDG      bool   // split data, state and cache into the XDG base dirs (--xdg / <APP>_XDG)
+}
+
+// Dirs are the resolved directories of an instance. Outside of XDG mode they're all the same.
+type Dirs struct {
+	Data    string  // database, control socket, installed tools, kept versions
+	State   string  // logs
+	Cache   string  // anything that can be deleted and fetched or rebuilt again
+	Options Options // effective options, see [Dirs.Args]
+}
+
+// Args returns the global flags that make another process (the systemd unit, a re-exec after
+// an update, ...) use the same dirs. Empty for the default instance.
+func (d Dirs) Args() []string {
+	var args []string
+	if d.Options.Dir != "" {
+		args = append(args, "--data-dir", d.Data)
+	}
+	if d.Options.Instance != "" {
+		args = append(args, "--instance", d.Options.Instance)
+	}
+	if d.Options.XDG && d.Options.Dir == "" {
+		args = append(args, "--xdg")
+	}
+	return args
+}
+
 type ctxKey struct{}
 
-func IntoContext(ctx context.Context, path string) context.Context {
-	return context.WithValue(ctx, ctxKey{}, path)
+func IntoContext(ctx context.Context, dirs Dirs) context.Context {
+	return context.WithValue(ctx, ctxKey{}, dirs)
 }
 
+// FromContext returns the data dir.
 func FromContext(ctx context.Context) string {
-	if path, ok := ctx.Value(ctxKey{}).(string); ok {
-		return path
+	return DirsFromContext(ctx).Data
+}
+
+// DirsFromContext returns all the dirs of the instance.
+func DirsFromContext(ctx context.Context) Dirs {
+	if dirs, ok := ctx.Value(ctxKey{}).(Dirs); ok {
+		return dirs
 	}
-	return ""
+	return Dirs{}
 }
 
-// Get returns the data path for the application.
+// OptionsFromEnv reads <APP>_HOME, <APP>_INSTANCE and <APP>_XDG, e.g. SSV_HOME.
+func OptionsFromEnv(appName string) Options {
+	prefix := strings.ToUpper(appName) + "_"
+	xdg := os.Getenv(prefix + "XDG")
+	return Options{
+		Dir:      os.Getenv(prefix + "HOME"),
+		Instance: os.Getenv(prefix + "INSTANCE"),
+		XDG:      xdg != "" && xdg != "0" && xdg != "false",
+	}
+}
+
+var instanceRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidateInstance checks an instance name, it ends up in paths and unit names.
+func ValidateInstance(name string) error {
+	if !instanceRe.MatchString(name) {
+		return fmt.Errorf("invalid instance name %q: use up to 32 lower case letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// Get returns the default data path for the application, ~/.<app>.
 // Assumes CGO is enabled.
 func Get(appName string) (string, error) {
+	home, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "."+appName), nil
+}
+
+// Resolve returns the dirs for opts, in order of precedence:
+//
+//   - opts.Dir: everything in that dir
+//   - XDG mode: $XDG_DATA_HOME/<app>, $XDG_STATE_HOME/<app> and $XDG_CACHE_HOME/<app>. Used when
+//     opts.XDG is set, or when ~/.<app> doesn't exist but the XDG data dir does
+//   - ~/.<app>
+//
+// A named instance gets "-<instance>" appended to the dir names, e.g. ~/.ssv-staging.
+func Resolve(appName string, opts Options) (Dirs, error) {
+	if opts.Instance != "" {
+		if err := ValidateInstance(opts.Instance); err != nil {
+			return Dirs{}, err
+		}
+	}
+	if opts.Dir != "" {
+		dir, err := filepath.Abs(opts.Dir)
+		if err != nil {
+			return Dirs{}, fmt.Errorf("invalid data dir %q: %w", opts.Dir, err)
+		}
+		return Dirs{Data: dir, State: dir, Cache: dir, Options: opts}, nil
+	}
+
+	home, err := homeDir()
+	if err != nil {
+		return Dirs{}, err
+	}
+	name := appName
+	if opts.Instance != "" {
+		name += "-" + opts.Instance
+	}
+	legacy := filepath.Join(home, "."+name)
+	data := filepath.Join(xdgDir("XDG_DATA_HOME", home, ".local/share"), name)
+	if !opts.XDG && !exists(legacy) && exists(data) {
+		opts.XDG = true
+	}
+	if !opts.XDG {
+		return Dirs{Data: legacy, State: legacy, Cache: legacy, Options: opts}, nil
+	}
+	return Dirs{
+		Data:    data,
+		State:   filepath.Join(xdgDir("XDG_STATE_HOME", home, ".local/state"), name),
+		Cache:   filepath.Join(xdgDir("XDG_CACHE_HOME", home, ".cache"), name),
+		Options: opts,
+	}, nil
+}
+
+// xdgDir returns the XDG base dir in env, or its default under home. The variables are
+// ignored when running as root, they'd be root's rather than the invoking user's.
+func xdgDir(env, home, def string) string {
+	if dir := os.Getenv(env); dir != "" && filepath.IsAbs(dir) && os.Geteuid() != 0 {
+		return dir
+	}
+	return filepath.Join(home, def)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// homeDir returns the current user's home, or the invoking user's when run as root.
+func homeDir() (string, error) {
 	// non-root: use current user's home.
 	if os.Geteuid() != 0 {
 		home, err := os.UserHomeDir()
 		if err != nil {
 			return "", fmt.Errorf("cannot determine home dir: %w", err)
 		}
-		return filepath.Join(home, "."+appName), nil
+		return home, nil
 	}
 
 	// root: require an invoking non-root user (sudo/doas).
-	home, err := invokingUserHome()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(home, "."+appName), nil
+	return invokingUserHome()
 }
 
 func invokingUserHome() (string, error) {
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/Data-Corruption/lmdb-go/lmdb"
+	"github.com/Data-Corruption/lmdb-go/wrap"
+)
+
+// Snapshot copies every DBI of db into a new environment at dir, in a single read transaction.
+func Snapshot(db *wrap.DB, dir string) error {
+	dbis := db.GetDBis()
+	dst, _, err := wrap.New(dir, slices.Sorted(maps.Keys(dbis)))
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot environment: %w", err)
+	}
+	defer dst.Close()
+	dstDBIs := dst.GetDBis()
+
+	return db.View(func(txn *lmdb.Txn) error {
+		return dst.Update(func(dTxn *lmdb.Txn) error {
+			for name, dbi := range dbis {
+				if err := copyDBI(txn, dbi, dTxn, dstDBIs[name]); err != nil {
+					return fmt.Errorf("failed to copy DBI '%s': %w", name, err)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Restore replaces the contents of every DBI in db with the snapshot at dir, see [Snapshot].
+// It's a single write transaction, so it's safe with other processes having db open.
+func Restore(db *wrap.DB, dir string) error {
+	dbis := db.GetDBis()
+	src, _, err := wrap.New(dir, slices.Sorted(maps.Keys(dbis)))
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+	srcDBIs := src.GetDBis()
+
+	return db.Update(func(txn *lmdb.Txn) error {
+		return src.View(func(sTxn *lmdb.Txn) error {
+			for name, dbi := range dbis {
+				if err := txn.Drop(dbi, false); err != nil {
+					return fmt.Errorf("failed to clear DBI '%s': %w", name, err)
+				}
+				if err := copyDBI(sTxn, srcDBIs[name], txn, dbi); err != nil {
+					return fmt.Errorf("failed to restore DBI '%s': %w", name, err)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func copyDBI(src *lmdb.Txn, srcDBI lmdb.DBI, dst *lmdb.Txn, dstDBI lmdb.DBI) error {
+	cur, err := src.OpenCursor(srcDBI)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for {
+		k, v, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := dst.Put(dstDBI, k, v, 0); err != nil {
+			return err
+		}
+	}
+}
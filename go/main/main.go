@@ -2,20 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
-	"time"
 
 	"ssv/go/app"
 	"ssv/go/commands"
 	"ssv/go/database"
 	"ssv/go/database/config"
 	"ssv/go/database/datapath"
+	"ssv/go/services/tasks/sandbox"
+	"ssv/go/system/doctor"
 	"ssv/go/system/update"
 
+	"github.com/Data-Corruption/lmdb-go/wrap"
 	"github.com/Data-Corruption/stdx/xlog"
 	"github.com/urfave/cli/v3"
 )
@@ -31,6 +37,7 @@ const DefaultLogLevel = "warn"
 var Version string // set by build script
 
 func main() {
+	sandbox.Main() // job commands re-exec us to set up their sandbox
 	exitCode, err := run()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
@@ -46,19 +53,26 @@ func run() (int, error) {
 	appData := app.AppData{Name: Name, Version: Version, UrlPrefix: ""}
 	ctx = app.IntoContext(ctx, appData)
 
-	// get data path
-	dataPath, err := datapath.Get(Name)
+	// get data dirs, before the cli parses anything since the database is opened first
+	opts, command, err := dataOptions(os.Args[1:])
+	if err != nil {
+		return 1, err
+	}
+	dirs, err := datapath.Resolve(Name, opts)
 	if err != nil {
 		return 1, fmt.Errorf("failed to get data path: %w", err)
 	}
-	// create data dir if it doesn't exist
-	if err := os.MkdirAll(dataPath, 0755); err != nil {
-		return 1, fmt.Errorf("failed to create data path: %w", err)
+	fresh := !exists(filepath.Join(dirs.Data, "db"))
+	// create data dirs if they don't exist
+	for _, dir := range []string{dirs.Data, dirs.State, dirs.Cache} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return 1, fmt.Errorf("failed to create data path: %w", err)
+		}
 	}
-	ctx = datapath.IntoContext(ctx, dataPath)
+	ctx = datapath.IntoContext(ctx, dirs)
 
 	// get log path
-	logPath := filepath.Join(dataPath, "logs")
+	logPath := filepath.Join(dirs.State, "logs")
 	if err := os.MkdirAll(logPath, 0755); err != nil {
 		return 1, fmt.Errorf("failed to create log path: %w", err)
 	}
@@ -71,64 +85,36 @@ func run() (int, error) {
 	ctx = xlog.IntoContext(ctx, log)
 	defer log.Close()
 
-	// init database
-	db, err := database.New(ctx)
-	if err != nil {
-		return 1, fmt.Errorf("failed to initialize database: %w", err)
+	// init database and config, doctor still runs without them to report what's wrong
+	ctx, db, err := openDatabase(ctx)
+	if db != nil {
+		defer db.Close()
 	}
-	ctx = database.IntoContext(ctx, db)
-	defer db.Close()
-	xlog.Debug(ctx, "Database initialized")
-
-	// init config
-	ctx, err = config.Init(ctx)
 	if err != nil {
-		return 1, fmt.Errorf("failed to initialize config: %w", err)
-	}
-	xlog.Debug(ctx, "Config initialized")
-
-	// set log level
-	cfgLogLevel, err := config.Get[string](ctx, "logLevel")
-	if err != nil {
-		return 1, fmt.Errorf("failed to get log level from config: %w", err)
-	}
-	if err := log.SetLevel(cfgLogLevel); err != nil {
-		return 1, fmt.Errorf("failed to set log level: %w", err)
-	}
+		if command != "doctor" {
+			return 1, err
+		}
+		ctx = doctor.StartupErrorIntoContext(ctx, err)
+	} else {
+		// a new named instance gets its own port so it can run next to the others
+		if fresh && opts.Instance != "" {
+			if err := instancePort(ctx, opts.Instance); err != nil {
+				return 1, err
+			}
+		}
 
-	// Update check
-	updateNotify, err := config.Get[bool](ctx, "updateNotify")
-	if err != nil {
-		return 1, fmt.Errorf("failed to get updateNotify from config: %w", err)
-	}
-	if updateNotify {
-		// get last update check time from config
-		tStr, err := config.Get[string](ctx, "lastUpdateCheck")
+		// set log level
+		cfgLogLevel, err := config.Get[string](ctx, "logLevel")
 		if err != nil {
-			return 1, fmt.Errorf("failed to get lastUpdateCheck from config: %w", err)
+			return 1, fmt.Errorf("failed to get log level from config: %w", err)
 		}
-		t, err := time.Parse(time.RFC3339, tStr)
-		if err != nil {
-			return 1, fmt.Errorf("failed to parse lastUpdateCheck time: %w", err)
+		if err := log.SetLevel(cfgLogLevel); err != nil {
+			return 1, fmt.Errorf("failed to set log level: %w", err)
 		}
 
-		// once a day, very lightweight check
-		if time.Since(t) > 24*time.Hour {
-			xlog.Debug(ctx, "Checking for updates...")
-
-			// update check time in config
-			if err := config.Set(ctx, "lastUpdateCheck", time.Now().Format(time.RFC3339)); err != nil {
-				return 1, fmt.Errorf("failed to set lastUpdateCheck in config: %w", err)
-			}
-
-			updateAvailable, err := update.Check(ctx)
-			if err != nil {
-				return 1, fmt.Errorf("failed to check for updates: %w", err)
-			}
-			if updateAvailable {
-				fmt.Printf("Update available! Run '%s update' to update.", Name)
-			}
-		}
+		// update notice from the last check, refreshed in the background once a day
+		update.StartupCheck(ctx)
+		update.WhatsNew(ctx)
 	}
 
 	// init app
@@ -142,6 +128,30 @@ func run() (int, error) {
 				Value: DefaultLogLevel,
 				Usage: "override log level (debug|info|warn|error|none)",
 			},
+			// the data dir flags are read before the cli runs, see dataOptions
+			&cli.StringFlag{
+				Name:    "data-dir",
+				Usage:   "use this data dir for everything instead of ~/." + Name,
+				Sources: cli.EnvVars(strings.ToUpper(Name) + "_HOME"),
+				Local:   true,
+			},
+			&cli.StringFlag{
+				Name:    "instance",
+				Usage:   "run a separate named instance, with its own data dir, port and service unit",
+				Sources: cli.EnvVars(strings.ToUpper(Name) + "_INSTANCE"),
+				Local:   true,
+			},
+			&cli.BoolFlag{
+				Name:    "xdg",
+				Usage:   "keep data, logs and caches in the XDG base dirs ($XDG_DATA_HOME, $XDG_STATE_HOME, $XDG_CACHE_HOME)",
+				Sources: cli.EnvVars(strings.ToUpper(Name) + "_XDG"),
+				Local:   true,
+			},
+			&cli.StringFlag{
+				Name:    "verilator-version",
+				Usage:   "run this installed verilator version instead of the selected one",
+				Sources: cli.EnvVars(strings.ToUpper(Name) + "_VERILATOR_VERSION"),
+			},
 			&cli.BoolFlag{
 				Name:    "yes",
 				Aliases: []string{"y"},
@@ -153,8 +163,17 @@ func run() (int, error) {
 			commands.UpdateToggleNotify,
 			commands.Service,
 			commands.Verilator,
+			commands.Build,
+			commands.Lint,
+			commands.Jobs,
+			commands.Cache,
+			commands.Doctor,
 		},
 		Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+			// the data dir is already open, flags after the command would be silently ignored
+			if cmd.String("data-dir") != opts.Dir || cmd.String("instance") != opts.Instance || (cmd.Bool("xdg") && !opts.XDG) {
+				return ctx, fmt.Errorf("--data-dir, --instance and --xdg must come before the command")
+			}
 			// handle log level override
 			logLevel := cmd.String("log")
 			if logLevel != DefaultLogLevel {
@@ -168,8 +187,106 @@ func run() (int, error) {
 
 	// run app
 	if err := app.Run(ctx, os.Args); err != nil {
+		// commands with their own exit codes, e.g. lint for CI gating
+		var status *commands.ExitStatus
+		if errors.As(err, &status) {
+			if status.Err == nil {
+				return status.Code, nil
+			}
+			log.Error(status.Err)
+			return status.Code, status.Err
+		}
 		log.Error(err)
 		return 1, fmt.Errorf("app run failed: %w", err)
 	}
 	return 0, nil
 }
+
+// dataOptions reads the data dir options from the environment and the global flags in args,
+// the ones before the command, and returns them with the command name. Flags override the
+// environment.
+func dataOptions(args []string) (datapath.Options, string, error) {
+	opts := datapath.OptionsFromEnv(Name)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return opts, arg, nil
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "data-dir", "instance", "log", "verilator-version":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return opts, "", fmt.Errorf("flag needs an argument: %s", arg)
+				}
+				i++
+				value = args[i]
+			}
+			switch name {
+			case "data-dir":
+				opts.Dir = value
+			case "instance":
+				opts.Instance = value
+			}
+		case "xdg":
+			opts.XDG = !hasValue || value == "true"
+		}
+	}
+	return opts, "", nil
+}
+
+// instancePort sets the port of a new named instance, derived from its name so it stays the
+// same if the instance is recreated, and skipping ports already in use. If all the probed ports
+// are in use it keeps the first, the instance's database already exists so it isn't probed again.
+func instancePort(ctx context.Context, instance string) error {
+	base, err := config.Get[int](ctx, "port")
+	if err != nil {
+		return fmt.Errorf("failed to get port from config: %w", err)
+	}
+	first := base + 1 + int(crc32.ChecksumIEEE([]byte(instance))%1000)
+	port := 0
+	for p := first; p < first+100; p++ {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", p))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		port = p
+		break
+	}
+	if port == 0 {
+		port = first
+		fmt.Fprintf(os.Stderr, "⚠ no free port found in %d-%d, the new instance %q may fail to listen\n", first, first+99, instance)
+	}
+	if err := config.Set(ctx, "port", port); err != nil {
+		return fmt.Errorf("failed to set port in config: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "New instance %q will listen on port %d\n", instance, port)
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// openDatabase opens the database and loads the config. On error the returned context has
+// whatever did open, and db must still be closed if not nil.
+func openDatabase(ctx context.Context) (context.Context, *wrap.DB, error) {
+	db, err := database.New(ctx)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	ctx = database.IntoContext(ctx, db)
+	xlog.Debug(ctx, "Database initialized")
+
+	cfgCtx, err := config.Init(ctx)
+	if err != nil {
+		return ctx, db, fmt.Errorf("failed to initialize config: %w", err)
+	}
+	xlog.Debug(cfgCtx, "Config initialized")
+	return cfgCtx, db, nil
+}
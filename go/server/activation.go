@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	listenFdsStart = 3 // SD_LISTEN_FDS_START
+
+	// fdName is the FDNAME our own listeners are stored under in systemd's fd store, anything
+	// else passed in LISTEN_FDS came from a .socket unit (socket activation).
+	fdName = "http"
+
+	// HandoffSocketEnv names the env var carrying the parent's notify socket during a
+	// fork/exec handoff, the child sends READY=1 there once it's serving. See [Server.Handoff].
+	HandoffSocketEnv = "SSV_HANDOFF_SOCKET"
+)
+
+// inheritedListener is a listener passed to us by systemd (socket activation or fd store)
+// or by a parent process during a handoff.
+type inheritedListener struct {
+	net.Listener
+	name string
+}
+
+// inheritedListeners returns the listeners passed through LISTEN_FDS / LISTEN_FDNAMES and
+// unsets those vars so they aren't passed on to children. LISTEN_PID is checked if present,
+// a handoff from our own parent can't know the child's PID in advance so it omits it.
+func inheritedListeners() ([]inheritedListener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil // meant for another process
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	var names []string
+	if v := os.Getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+
+	var out []inheritedListener
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := "unknown"
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f) // dups the fd
+		f.Close()
+		if err != nil {
+			for _, il := range out {
+				il.Close()
+			}
+			return nil, fmt.Errorf("inherited fd %d (%s) is not a listening socket: %w", fd, name, err)
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false) // the socket file belongs to whoever created it
+		}
+		out = append(out, inheritedListener{Listener: l, name: name})
+	}
+	return out, nil
+}
+
+// takeInherited removes and returns the inherited listener bound to network/addr, if any.
+func takeInherited(inherited *[]inheritedListener, network, addr string) net.Listener {
+	for i, il := range *inherited {
+		if sameAddr(il.Addr(), network, addr) {
+			*inherited = append((*inherited)[:i], (*inherited)[i+1:]...)
+			return il.Listener
+		}
+	}
+	return nil
+}
+
+// sameAddr reports whether a bound address matches a configured network/addr pair.
+// Unspecified hosts ("", "0.0.0.0", "::") compare equal to each other.
+func sameAddr(bound net.Addr, network, addr string) bool {
+	switch b := bound.(type) {
+	case *net.UnixAddr:
+		return network == "unix" && b.Name == addr
+	case *net.TCPAddr:
+		if !strings.HasPrefix(network, "tcp") {
+			return false
+		}
+		want, err := net.ResolveTCPAddr(network, addr)
+		if err != nil || want.Port != b.Port {
+			return false
+		}
+		wantAny := want.IP == nil || want.IP.IsUnspecified()
+		if wantAny || b.IP.IsUnspecified() {
+			return wantAny && b.IP.IsUnspecified()
+		}
+		return want.IP.Equal(b.IP)
+	}
+	return false
+}
+
+// listenerFile returns a dup of the listener's fd.
+func listenerFile(l net.Listener) (*os.File, error) {
+	switch v := l.(type) {
+	case *net.TCPListener:
+		return v.File()
+	case *net.UnixListener:
+		return v.File()
+	}
+	return nil, fmt.Errorf("unsupported listener type %T", l)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ssv/go/system/sdnotify"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// HandoffTimeout is how long [Server.Handoff] waits for the new process to become ready.
+const HandoffTimeout = 90 * time.Second
+
+// storeListeners hands our listeners to systemd's fd store so a restart (e.g. after an
+// update) gets them back through LISTEN_FDS instead of closing and re-binding the port.
+// Connections arriving in between queue in the kernel backlog instead of being refused.
+func storeListeners(ctx context.Context, listeners []net.Listener) {
+	if !sdnotify.Enabled() {
+		return
+	}
+	// replace whatever a previous run stored, the config may have changed
+	if err := sdnotify.FDStoreRemove(fdName); err != nil {
+		xlog.Debugf(ctx, "sd_notify FDSTOREREMOVE failed: %v", err)
+	}
+	var files []*os.File
+	for _, l := range listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false) // the next run inherits the socket, keep the path
+		}
+		f, err := listenerFile(l)
+		if err != nil {
+			xlog.Warnf(ctx, "can't store listener %s: %v", l.Addr(), err)
+			continue
+		}
+		files = append(files, f)
+	}
+	if err := sdnotify.FDStore(fdName, files...); err != nil {
+		xlog.Debugf(ctx, "sd_notify FDSTORE failed: %v", err)
+	}
+	for _, f := range files {
+		f.Close() // systemd has its own copies now
+	}
+}
+
+// Handoff starts exe with args, passing it our listeners through LISTEN_FDS, waits for it to
+// report ready, then shuts this server down gracefully. Used to swap binaries without refusing
+// connections when not managed by systemd (under systemd the fd store does this, see [New]).
+func (s *Server) Handoff(exe string, args []string) error {
+	// notify socket the child reports READY=1 on
+	dir, err := os.MkdirTemp("", "ssv-handoff-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	notifyPath := filepath.Join(dir, "notify.sock")
+	notifyConn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifyPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to create handoff notify socket: %w", err)
+	}
+	defer notifyConn.Close()
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range s.listeners {
+		f, err := listenerFile(l)
+		if err != nil {
+			return fmt.Errorf("failed to dup listener %s: %w", l.Addr(), err)
+		}
+		files = append(files, f)
+	}
+	names := make([]string, len(files))
+	for i := range names {
+		names[i] = fdName
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(handoffEnviron(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		HandoffSocketEnv+"="+notifyPath,
+	)
+	cmd.ExtraFiles = files // fd 3.. in the child, matching LISTEN_FDS
+	for env, f := range s.passOn {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", env, 3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f) // after the listeners, not counted in LISTEN_FDS
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", exe, err)
+	}
+	xlog.Infof(s.ctx, "handoff: started %s (pid %d), waiting for it to become ready", exe, cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		_ = notifyConn.SetReadDeadline(time.Now().Add(HandoffTimeout))
+		for {
+			n, err := notifyConn.Read(buf)
+			if err != nil {
+				ready <- err
+				return
+			}
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				if line == "READY=1" {
+					ready <- nil
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return fmt.Errorf("new process did not become ready: %w", err)
+		}
+	case err := <-exited:
+		return fmt.Errorf("new process exited before becoming ready: %v", err)
+	}
+
+	// the child owns the unix socket paths now
+	for _, l := range s.listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	xlog.Infof(s.ctx, "handoff: pid %d is serving, shutting down", cmd.Process.Pid)
+	go s.Shutdown(context.Background())
+	return nil
+}
+
+// handoffEnviron returns our environment minus anything describing our own fds or supervisor.
+func handoffEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		switch k {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID", HandoffSocketEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"ssv/go/database/config"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenAll opens every listener described by the config keys "bindAddress", "port",
+// "unixSocket" and "unixSocketMode". Inherited listeners bound to a configured address are
+// reused and removed from inherited. On failure, any listener already opened is closed.
+func listenAll(ctx context.Context, inherited *[]inheritedListener) ([]net.Listener, error) {
+	port, err := config.Get[int](ctx, "port")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get port from config: %w", err)
+	}
+	bindAddrs, err := config.Get[[]string](ctx, "bindAddress")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bindAddress from config: %w", err)
+	}
+	socketPath, err := config.Get[string](ctx, "unixSocket")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unixSocket from config: %w", err)
+	}
+	socketModeStr, err := config.Get[string](ctx, "unixSocketMode")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unixSocketMode from config: %w", err)
+	}
+
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	// tcp
+	if len(bindAddrs) == 0 {
+		bindAddrs = []string{""} // all interfaces, same as ":<port>"
+	}
+	for _, a := range bindAddrs {
+		network, addr, err := resolveBindAddress(a, port)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if l := takeInherited(inherited, network, addr); l != nil {
+			listeners = append(listeners, l)
+			continue
+		}
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			closeAll()
+			return nil, wrapListenErr(addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	// unix
+	if socketPath != "" {
+		mode, err := strconv.ParseUint(socketModeStr, 8, 32)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("invalid unixSocketMode %q: %w", socketModeStr, err)
+		}
+		if l := takeInherited(inherited, "unix", socketPath); l != nil {
+			return append(listeners, l), nil
+		}
+		l, err := listenUnix(socketPath, os.FileMode(mode))
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// resolveBindAddress turns a bindAddress entry into a network and address for [net.Listen].
+// Entries may be a bare host ("127.0.0.1", "::1", "[::1]"), a host:port pair ("0.0.0.0:8080", "[::]:8080"),
+// or empty / "*" for all interfaces. The configured port is used when the entry has none.
+// IPv4 and IPv6 literals get "tcp4" / "tcp6" so "0.0.0.0" and "::" can be bound side by side.
+func resolveBindAddress(entry string, port int) (string, string, error) {
+	entry = strings.TrimSpace(entry)
+	host, portStr := entry, strconv.Itoa(port)
+	if h, p, err := net.SplitHostPort(entry); err == nil {
+		host, portStr = h, p
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if host == "*" {
+		host = ""
+	}
+	if p, err := strconv.Atoi(portStr); err != nil || p < 0 || p > 65535 {
+		return "", "", fmt.Errorf("invalid port in bindAddress %q", entry)
+	}
+
+	network := "tcp"
+	if ip := net.ParseIP(host); ip != nil {
+		network = "tcp6"
+		if ip.To4() != nil {
+			network = "tcp4"
+		}
+	}
+	return network, net.JoinHostPort(host, portStr), nil
+}
+
+// listenUnix listens on a unix domain socket at path, removing a stale socket file left
+// behind by a previous run, then applies mode to the socket file.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create unix socket dir: %w", err)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, wrapListenErr(path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set unix socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+// removeStaleSocket removes path if it is a socket nobody is listening on.
+// Anything else at path (a live socket, a regular file) is left alone and reported.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to stat unix socket path: %w", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unix socket path %s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %s is already in use", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale unix socket: %w", err)
+	}
+	return nil
+}
+
+func wrapListenErr(addr string, err error) error {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("address %s already in use: %w", addr, err)
+	}
+	if errors.Is(err, syscall.EACCES) {
+		return fmt.Errorf("permission denied listening on %s: %w", addr, err)
+	}
+	return fmt.Errorf("failed to listen on %s: %w", addr, err)
+}
@@ -2,15 +2,43 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"ssv/go/database/config"
+	"os"
 	"ssv/go/system/sdnotify"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/Data-Corruption/stdx/xhttp"
 	"github.com/Data-Corruption/stdx/xlog"
 )
 
+// HealthPath is answered by the server itself, before the app handler, for [Server.HealthCheck].
+const HealthPath = "/healthz"
+
+const (
+	ReadTimeout     = 5 * time.Second
+	WriteTimeout    = 10 * time.Second
+	IdleTimeout     = 120 * time.Second
+	ShutdownTimeout = 10 * time.Second
+)
+
+type ctxKey struct{}
+
+func IntoContext(ctx context.Context, srv *Server) context.Context {
+	return context.WithValue(ctx, ctxKey{}, srv)
+}
+
+func FromContext(ctx context.Context) *Server {
+	if srv, ok := ctx.Value(ctxKey{}).(*Server); ok {
+		return srv
+	}
+	return nil
+}
+
 type urlPrefixCtxKey struct{}
 
 // format: https://example.com:port/ :port being omitted if 80/443
@@ -26,38 +54,211 @@ func UrlPrefixFromContext(ctx context.Context) string {
 	return ""
 }
 
-func New(ctx context.Context, handler http.Handler) (*xhttp.Server, error) {
-	// get http server related stuff from config
-	port, err := config.Get[int](ctx, "port")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get port from config: %w", err)
-	}
+// Server is an [http.Server] serving the same handler on every configured listener
+// (TCP bind addresses and an optional unix socket). It shuts down gracefully when
+// the context it was created with is done, or when [Server.Shutdown] is called.
+type Server struct {
+	ctx       context.Context
+	http      *http.Server
+	listeners []net.Listener
+	urlPrefix string
+	conns     atomic.Int64        // open client connections
+	passOn    map[string]*os.File // env name -> file, inherited by the handoff process
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+	done         chan struct{}
+}
+
+// New opens all configured listeners and returns a server ready to [Server.Listen].
+func New(ctx context.Context, handler http.Handler) (*Server, error) {
 	urlPrefix := UrlPrefixFromContext(ctx)
 	if urlPrefix == "" {
 		xlog.Warnf(ctx, "urlPrefix not set in context, defaulting to localhost")
-		urlPrefix = fmt.Sprintf("http://localhost:%d/", port)
-	}
-	// create http server
-	var srv *xhttp.Server
-	srv, err = xhttp.NewServer(&xhttp.ServerConfig{
-		Addr:    fmt.Sprintf(":%d", port),
-		UseTLS:  false,
-		Handler: handler,
-		AfterListen: func() {
-			// tell systemd we're ready
-			status := fmt.Sprintf("Listening on %s", srv.Addr())
-			if err := sdnotify.Ready(status); err != nil {
-				xlog.Warnf(ctx, "sd_notify READY failed: %v", err)
+		urlPrefix = "http://localhost/"
+	}
+
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	listeners, err := listenAll(ctx, &inherited)
+	if err != nil {
+		for _, il := range inherited {
+			il.Close()
+		}
+		return nil, err
+	}
+	for _, il := range inherited {
+		if il.name == fdName {
+			// ours from a previous run, but no longer configured
+			xlog.Infof(ctx, "dropping inherited listener %s, no longer configured", il.Addr())
+			il.Close()
+			continue
+		}
+		// socket activation, serve whatever the .socket unit gave us
+		xlog.Infof(ctx, "serving socket activated listener %s (%s)", il.Addr(), il.name)
+		listeners = append(listeners, il.Listener)
+	}
+	storeListeners(ctx, listeners)
+
+	srv := &Server{
+		ctx:       ctx,
+		listeners: listeners,
+		urlPrefix: urlPrefix,
+		done:      make(chan struct{}),
+	}
+	srv.http = &http.Server{
+		Handler:      withHealth(handler),
+		ReadTimeout:  ReadTimeout,
+		WriteTimeout: WriteTimeout,
+		IdleTimeout:  IdleTimeout,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				srv.conns.Add(1)
+			case http.StateClosed, http.StateHijacked:
+				srv.conns.Add(-1)
 			}
-			fmt.Printf("Server is listening on %s\n", urlPrefix)
 		},
-		OnShutdown: func() {
-			// tell systemd we’re stopping
-			if err := sdnotify.Stopping("Shutting down"); err != nil {
-				xlog.Debugf(ctx, "sd_notify STOPPING failed: %v", err)
-			}
-			fmt.Println("shutting down, cleaning up resources ...")
+	}
+	srv.http.RegisterOnShutdown(func() {
+		// tell systemd we’re stopping
+		if err := sdnotify.Stopping("Shutting down"); err != nil {
+			xlog.Debugf(ctx, "sd_notify STOPPING failed: %v", err)
+		}
+		fmt.Println("shutting down, cleaning up resources ...")
+	})
+	return srv, nil
+}
+
+// Addrs returns the addresses of all listeners, e.g. "[::]:28080" or "/run/ssv.sock".
+func (s *Server) Addrs() []string {
+	addrs := make([]string, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr().String())
+	}
+	return addrs
+}
+
+// Connections returns the number of open client connections.
+func (s *Server) Connections() int {
+	return int(s.conns.Load())
+}
+
+// HealthCheck makes a request to [HealthPath] through the first listener, so it fails if the
+// accept loop or handler goroutines are wedged. Used by the systemd watchdog.
+func (s *Server) HealthCheck(ctx context.Context) error {
+	if len(s.listeners) == 0 {
+		return errors.New("no listeners")
+	}
+	addr := s.listeners[0].Addr()
+	network, target := addr.Network(), addr.String()
+	if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.IsUnspecified() {
+		// wildcard bind, dial loopback of the same family
+		loopback := net.IPv6loopback
+		if tcp.IP.To4() != nil {
+			loopback = net.IPv4(127, 0, 0, 1)
+		}
+		target = net.JoinHostPort(loopback.String(), fmt.Sprint(tcp.Port))
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, target)
 		},
+		DisableKeepAlives: true,
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://health"+HealthPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+func withHealth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == HealthPath {
+			w.Write([]byte("ok\n"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OnShutdown registers f to be called when shutdown starts, for long-lived responses like log
+// streams to end instead of holding it up until [ShutdownTimeout].
+func (s *Server) OnShutdown(f func()) {
+	s.http.RegisterOnShutdown(f)
+}
+
+// PassOnHandoff makes f inherited by the process [Server.Handoff] starts, with its fd number
+// in the env variable env. f stays open here.
+func (s *Server) PassOnHandoff(env string, f *os.File) {
+	if s.passOn == nil {
+		s.passOn = make(map[string]*os.File)
+	}
+	s.passOn[env] = f
+}
+
+// Listen serves on all listeners and blocks until the server is shut down or a listener fails.
+func (s *Server) Listen() error {
+	serveErrCh := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		go func(l net.Listener) {
+			serveErrCh <- s.http.Serve(l)
+		}(l)
+	}
+
+	// listeners are already bound, so we're ready as soon as serving starts
+	status := fmt.Sprintf("Listening on %s", strings.Join(s.Addrs(), ", "))
+	if err := sdnotify.Ready(status); err != nil {
+		xlog.Warnf(s.ctx, "sd_notify READY failed: %v", err)
+	}
+	xlog.Info(s.ctx, status)
+	if addr := os.Getenv(HandoffSocketEnv); addr != "" {
+		// started by [Server.Handoff], tell the parent it can go
+		os.Unsetenv(HandoffSocketEnv)
+		if err := sdnotify.ReadyTo(addr, status); err != nil {
+			xlog.Warnf(s.ctx, "handoff READY failed: %v", err)
+		}
+	}
+	fmt.Printf("Server is listening on %s\n", s.urlPrefix)
+
+	select {
+	case <-s.ctx.Done():
+		return s.Shutdown(context.Background())
+	case <-s.done:
+		return s.shutdownErr
+	case err := <-serveErrCh:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Shutdown(context.Background())
+			return err
+		}
+		<-s.done
+		return s.shutdownErr
+	}
+}
+
+// Shutdown gracefully stops the server, waiting up to [ShutdownTimeout] for active requests.
+//
+// Thread-safe, can be called from any goroutine. Only the first call has an effect,
+// later calls return its result.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		sCtx, cancel := context.WithTimeout(ctx, ShutdownTimeout)
+		defer cancel()
+		s.shutdownErr = s.http.Shutdown(sCtx)
+		close(s.done)
 	})
-	return srv, err
+	<-s.done
+	return s.shutdownErr
 }
@@ -0,0 +1,536 @@
+// Package buildcache keeps built verilator models (obj_dir and executable) keyed by a hash of
+// everything that went into them, so unchanged designs are restored instead of rebuilt.
+//
+// Layout, under <cache dir>/build-cache:
+//
+//	<key>/obj_dir/      the verilator output dir
+//	<key>/bin/<name>    the executable, if the model has a testbench
+//	<key>/meta.json     [Entry]
+//	stats.json          hit and miss counters
+//	.lock               held while changing the cache
+package buildcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"ssv/go/database/config"
+	"ssv/go/database/datapath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	dirName   = "build-cache"
+	metaName  = "meta.json"
+	statsName = "stats.json"
+
+	// keyVersion is part of every key, bump it when the layout or what's hashed changes
+	keyVersion = "2"
+)
+
+// Artifacts are the outputs of a build.
+type Artifacts struct {
+	ObjDir string   // verilator --Mdir
+	Binary string   // executable, empty if the model has none
+	Root   string   // relative Deps are relative to it
+	Deps   []string // files the build read, a cached build is only restored while they're unchanged
+}
+
+// Entry describes a cached build.
+type Entry struct {
+	Key      string            `json:"key"`
+	Name     string            `json:"name"`
+	Binary   string            `json:"binary,omitempty"` // executable name under bin/
+	Deps     map[string]string `json:"deps,omitempty"`   // sha256 of the files the build read, by path
+	Size     int64             `json:"size"`
+	Created  time.Time         `json:"created"`
+	LastUsed time.Time         `json:"lastUsed"`
+}
+
+// Stats summarizes the cache.
+type Stats struct {
+	Dir     string    `json:"dir"`
+	Entries int       `json:"entries"`
+	Size    int64     `json:"size"`
+	MaxSize int64     `json:"maxSize"`
+	Hits    int64     `json:"hits"`
+	Misses  int64     `json:"misses"`
+	Oldest  time.Time `json:"oldest,omitzero"` // least recently used
+}
+
+// Cache is the build cache of the data path in the context it was opened with.
+type Cache struct {
+	dir     string
+	maxSize int64
+}
+
+// Open returns the build cache, with its size limit from the "buildCacheMaxSize" config value.
+func Open(ctx context.Context) (*Cache, error) {
+	cacheDir := datapath.DirsFromContext(ctx).Cache
+	if cacheDir == "" {
+		return nil, fmt.Errorf("cache path not set in context")
+	}
+	limit, err := config.Get[string](ctx, "buildCacheMaxSize")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get buildCacheMaxSize from config: %w", err)
+	}
+	maxSize, err := ParseSize(limit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid buildCacheMaxSize: %w", err)
+	}
+	dir := filepath.Join(cacheDir, dirName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create build cache dir: %w", err)
+	}
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+// Enabled returns the "buildCache" config value.
+func Enabled(ctx context.Context) (bool, error) {
+	enabled, err := config.Get[bool](ctx, "buildCache")
+	if err != nil {
+		return false, fmt.Errorf("failed to get buildCache from config: %w", err)
+	}
+	return enabled, nil
+}
+
+// Key accumulates the inputs of a build into a cache key. Every input is labelled so that
+// moving a value from one input to another changes the key.
+type Key struct {
+	h hash.Hash
+}
+
+func NewKey() *Key {
+	k := &Key{h: sha256.New()}
+	k.String("ssv build cache", keyVersion)
+	return k
+}
+
+// String adds a labelled value.
+func (k *Key) String(label, value string) {
+	fmt.Fprintf(k.h, "%s\x00%d\x00%s\x00", label, len(value), value)
+}
+
+// File adds the contents of a file, labelled with its name.
+func (k *Key) File(label, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(k.h, "%s\x00%d\x00", label, fi.Size())
+	if _, err := io.Copy(k.h, f); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return nil
+}
+
+// Sum returns the key.
+func (k *Key) Sum() string {
+	return hex.EncodeToString(k.h.Sum(nil))
+}
+
+// Get restores the build with key into dst, replacing what's there. It returns false on a miss.
+func (c *Cache) Get(key string, dst Artifacts) (bool, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	entry, err := c.entry(key)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && (entry.Binary == "") != (dst.Binary == "")) {
+		return false, c.count(false)
+	} else if err != nil {
+		return false, err
+	}
+	// the key only hashes the inputs known before building, e.g. not headers included from
+	// subdirs of the include dirs, the build's own deps cover those
+	if !fresh(dst.Root, entry.Deps) {
+		return false, c.count(false)
+	}
+	dir := filepath.Join(c.dir, key)
+	if err := os.RemoveAll(dst.ObjDir); err != nil {
+		return false, fmt.Errorf("failed to clear %s: %w", dst.ObjDir, err)
+	}
+	if err := copyDir(filepath.Join(dir, "obj_dir"), dst.ObjDir); err != nil {
+		return false, fmt.Errorf("failed to restore %s: %w", dst.ObjDir, err)
+	}
+	if dst.Binary != "" {
+		os.Remove(dst.Binary) // don't write through a running executable
+		if err := copyFile(filepath.Join(dir, "bin", entry.Binary), dst.Binary, 0o755); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", dst.Binary, err)
+		}
+	}
+	entry.LastUsed = time.Now()
+	if err := writeJSON(filepath.Join(dir, metaName), entry); err != nil {
+		return false, err
+	}
+	return true, c.count(true)
+}
+
+// Put stores the artifacts of a build with key, then evicts the least recently used builds
+// while the cache is over its size limit. The artifacts may have been written by sandboxed
+// build code, so symlinks are copied as links and never followed.
+func (c *Cache) Put(key, name string, src Artifacts) error {
+	if fi, err := os.Lstat(src.ObjDir); err != nil {
+		return fmt.Errorf("failed to cache %s: %w", src.ObjDir, err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("failed to cache %s: not a dir", src.ObjDir)
+	}
+	if src.Binary != "" {
+		if fi, err := os.Lstat(src.Binary); err != nil {
+			return fmt.Errorf("failed to cache %s: %w", src.Binary, err)
+		} else if !fi.Mode().IsRegular() {
+			return fmt.Errorf("failed to cache %s: not a regular file", src.Binary)
+		}
+	}
+	tmp, err := os.MkdirTemp(c.dir, ".put-")
+	if err != nil {
+		return fmt.Errorf("failed to create build cache entry: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyDir(src.ObjDir, filepath.Join(tmp, "obj_dir")); err != nil {
+		return fmt.Errorf("failed to cache %s: %w", src.ObjDir, err)
+	}
+	entry := Entry{Key: key, Name: name, Created: time.Now(), Deps: map[string]string{}}
+	entry.LastUsed = entry.Created
+	for _, dep := range src.Deps {
+		// gone since the build, e.g. a temp file, nothing to compare later
+		if sum, err := fileSum(resolve(src.Root, dep)); err == nil {
+			entry.Deps[dep] = sum
+		}
+	}
+	if src.Binary != "" {
+		entry.Binary = filepath.Base(src.Binary)
+		if err := os.MkdirAll(filepath.Join(tmp, "bin"), 0o755); err != nil {
+			return err
+		}
+		if err := copyFile(src.Binary, filepath.Join(tmp, "bin", entry.Binary), 0o755); err != nil {
+			return fmt.Errorf("failed to cache %s: %w", src.Binary, err)
+		}
+	}
+	if entry.Size, err = dirSize(tmp); err != nil {
+		return err
+	}
+	if err := writeJSON(filepath.Join(tmp, metaName), entry); err != nil {
+		return err
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	dir := filepath.Join(c.dir, key)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to replace build cache entry: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf("failed to store build cache entry: %w", err)
+	}
+	_, _, err = c.evict(c.maxSize, 0)
+	return err
+}
+
+// Stats returns the cache's size and hit counters.
+func (c *Cache) Stats() (Stats, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return Stats{}, err
+	}
+	defer unlock()
+	st := Stats{Dir: c.dir, MaxSize: c.maxSize}
+	if err := readJSON(filepath.Join(c.dir, statsName), &st); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Stats{}, err
+	}
+	entries, err := c.entries()
+	if err != nil {
+		return Stats{}, err
+	}
+	st.Entries = len(entries)
+	for _, e := range entries {
+		st.Size += e.Size
+	}
+	if len(entries) > 0 {
+		st.Oldest = entries[0].LastUsed
+	}
+	return st, nil
+}
+
+// Prune removes builds unused for longer than olderThan (if set) and the least recently used
+// ones while the cache is over maxSize, a negative maxSize meaning its configured limit. It
+// returns how many builds were removed and their size.
+func (c *Cache) Prune(maxSize int64, olderThan time.Duration) (int, int64, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+	if maxSize < 0 {
+		maxSize = c.maxSize
+	}
+	return c.evict(maxSize, olderThan)
+}
+
+// evict removes entries, oldest first, c.lock must be held.
+func (c *Cache) evict(maxSize int64, olderThan time.Duration) (int, int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	removed, freed := 0, int64(0)
+	for _, e := range entries {
+		if total <= maxSize && (olderThan <= 0 || time.Since(e.LastUsed) < olderThan) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.dir, e.Key)); err != nil {
+			return removed, freed, fmt.Errorf("failed to evict build cache entry %s: %w", e.Key, err)
+		}
+		total -= e.Size
+		removed++
+		freed += e.Size
+	}
+	return removed, freed, nil
+}
+
+// entries returns the cached builds, least recently used first.
+func (c *Cache) entries() ([]Entry, error) {
+	dirs, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build cache: %w", err)
+	}
+	var entries []Entry
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		e, err := c.entry(d.Name())
+		if err != nil {
+			// half written or damaged, count it so it gets evicted first
+			size, _ := dirSize(filepath.Join(c.dir, d.Name()))
+			e = Entry{Key: d.Name(), Size: size}
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return a.LastUsed.Compare(b.LastUsed) })
+	return entries, nil
+}
+
+func (c *Cache) entry(key string) (Entry, error) {
+	var e Entry
+	if err := readJSON(filepath.Join(c.dir, key, metaName), &e); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// count records a hit or a miss, c.lock must be held.
+func (c *Cache) count(hit bool) error {
+	path := filepath.Join(c.dir, statsName)
+	var st struct {
+		Hits   int64 `json:"hits"`
+		Misses int64 `json:"misses"`
+	}
+	if err := readJSON(path, &st); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if hit {
+		st.Hits++
+	} else {
+		st.Misses++
+	}
+	return writeJSON(path, st)
+}
+
+// lock takes the cache lock, shared between processes.
+func (c *Cache) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(c.dir, ".lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open build cache lock: %w", err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock build cache: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// ParseSize parses a size like "10GiB", "500MB" or "1048576" (bytes).
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	units := map[string]float64{
+		"": 1, "b": 1,
+		"k": 1 << 10, "kb": 1e3, "kib": 1 << 10,
+		"m": 1 << 20, "mb": 1e6, "mib": 1 << 20,
+		"g": 1 << 30, "gb": 1e9, "gib": 1 << 30,
+		"t": 1 << 40, "tb": 1e12, "tib": 1 << 40,
+	}
+	unit, ok := units[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q, unknown unit", s)
+	}
+	return int64(n * unit), nil
+}
+
+// FormatSize formats a size in bytes with a binary unit.
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON writes v to path atomically.
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		fi, err := d.Info()
+		if err == nil {
+			size += fi.Size()
+		}
+		return err
+	})
+	return size, err
+}
+
+// fresh reports whether the deps of a cached build, relative to root, are unchanged.
+func fresh(root string, deps map[string]string) bool {
+	for dep, want := range deps {
+		if sum, err := fileSum(resolve(root, dep)); err != nil || sum != want {
+			return false
+		}
+	}
+	return true
+}
+
+func resolve(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// fileSum returns the sha256 of a regular file, following symlinks.
+func fileSum(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyDir copies a tree of dirs, regular files and symlinks.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return copyFile(path, target, fi.Mode().Perm())
+		}
+		return nil // sockets, fifos, ...
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.OpenFile(src, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,183 @@
+package buildcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// build writes the artifacts of a fake build to dir.
+func build(t *testing.T, dir string) Artifacts {
+	t.Helper()
+	a := Artifacts{ObjDir: filepath.Join(dir, "obj_dir"), Binary: filepath.Join(dir, "sim")}
+	if err := os.MkdirAll(a.ObjDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range map[string]string{
+		filepath.Join(a.ObjDir, "Vtop.mk"): "# make",
+		a.Binary:                           "#!/bin/sh\n",
+	} {
+		if err := os.WriteFile(path, []byte(data), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a
+}
+
+func TestPutGet(t *testing.T) {
+	c := &Cache{dir: t.TempDir(), maxSize: 1 << 30}
+	src := build(t, t.TempDir())
+	if err := c.Put("key", "top", src); err != nil {
+		t.Fatal(err)
+	}
+	dst := Artifacts{ObjDir: filepath.Join(t.TempDir(), "obj_dir"), Binary: filepath.Join(t.TempDir(), "sim")}
+	if hit, err := c.Get("key", dst); err != nil || !hit {
+		t.Fatalf("got %v, %v", hit, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dst.ObjDir, "Vtop.mk")); err != nil || string(b) != "# make" {
+		t.Fatalf("restored %q, %v", b, err)
+	}
+	if hit, err := c.Get("other", dst); err != nil || hit {
+		t.Fatalf("other key: got %v, %v", hit, err)
+	}
+}
+
+func TestPutRefusesSymlinks(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "data.mdb")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, replace := range map[string]func(a Artifacts){
+		"binary": func(a Artifacts) {
+			os.Remove(a.Binary)
+			os.Symlink(secret, a.Binary)
+		},
+		"obj dir": func(a Artifacts) {
+			os.RemoveAll(a.ObjDir)
+			os.Symlink(filepath.Dir(secret), a.ObjDir)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := &Cache{dir: t.TempDir(), maxSize: 1 << 30}
+			src := build(t, t.TempDir())
+			replace(src)
+			if err := c.Put("key", "top", src); err == nil {
+				t.Fatal("cached a symlink")
+			}
+			if _, err := c.entry("key"); err == nil {
+				t.Fatal("entry stored")
+			}
+		})
+	}
+
+	// links inside the obj dir are copied as links, not followed
+	c := &Cache{dir: t.TempDir(), maxSize: 1 << 30}
+	src := build(t, t.TempDir())
+	if err := os.Symlink(secret, filepath.Join(src.ObjDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("key", "top", src); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(c.dir, "key", "obj_dir", "link"))
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("got %v, %v, want a symlink", fi, err)
+	}
+}
+
+func TestGetChecksDeps(t *testing.T) {
+	c := &Cache{dir: t.TempDir(), maxSize: 1 << 30}
+	root := t.TempDir()
+	header := filepath.Join(root, "rtl", "inc", "sub", "defs.svh")
+	if err := os.MkdirAll(filepath.Dir(header), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(data string) {
+		if err := os.WriteFile(header, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("`define W 8")
+	src := build(t, t.TempDir())
+	src.Root, src.Deps = root, []string{"rtl/inc/sub/defs.svh", "gone.h"}
+	if err := c.Put("key", "top", src); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := c.entry("key"); err != nil || len(e.Deps) != 1 {
+		t.Fatalf("got %v, %v, want the one dep that exists", e.Deps, err)
+	}
+
+	// the deps are resolved against the root of the project the build is restored into
+	other := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(other, "rtl", "inc", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "rtl", "inc", "sub", "defs.svh"), []byte("`define W 8"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func()
+		root   string
+		hit    bool
+	}{
+		{"unchanged", func() {}, root, true},
+		{"copy", func() {}, other, true},
+		{"changed", func() { write("`define W 16") }, root, false},
+		{"changed back", func() { write("`define W 8") }, root, true},
+		{"removed", func() { os.Remove(header) }, root, false},
+	} {
+		tc.change()
+		dst := Artifacts{ObjDir: filepath.Join(t.TempDir(), "obj_dir"), Binary: filepath.Join(t.TempDir(), "sim"), Root: tc.root}
+		if hit, err := c.Get("key", dst); err != nil || hit != tc.hit {
+			t.Errorf("%s: got %v, %v, want %v", tc.name, hit, err, tc.hit)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"1048576", 1 << 20},
+		{"512b", 512},
+		{"10GiB", 10 << 30},
+		{"10gib", 10 << 30},
+		{"10G", 10 << 30},
+		{"500MB", 500e6},
+		{"500 MiB", 500 << 20},
+		{" 2 TB ", 2e12},
+		{"1.5k", 1536},
+		{"1.5KB", 1500},
+		{"0.5GiB", 1 << 29},
+	} {
+		got, err := ParseSize(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("%q: got %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "GiB", "-1", "-1GiB", "10XB", "10 G B", "1.2.3M", "1e3"} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("%q: got %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1 << 10:       "1.0 KiB",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		10 << 30:      "10.0 GiB",
+		(3 << 40) / 2: "1536.0 GiB",
+	} {
+		if got := FormatSize(n); got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+}
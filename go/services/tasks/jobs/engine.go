@@ -0,0 +1,447 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"ssv/go/database/config"
+	"ssv/go/services/tasks/project"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+const (
+	dispatchTick = 5 * time.Second // picks up jobs requeued by another daemon, e.g. during a handoff
+	pruneTick    = time.Hour
+)
+
+// cancellation causes of a running job
+var (
+	errCancelled = errors.New("cancelled")
+	errTimeout   = errors.New("timed out")
+)
+
+// Engine runs queued jobs with a bounded pool of workers. There is one per daemon.
+type Engine struct {
+	ctx     context.Context
+	root    string
+	workers int
+	pid     int
+	slots   chan struct{}
+	wake    chan struct{}
+	ping    chan struct{} // received by the dispatcher, see [Engine.HealthCheck]
+	wg      sync.WaitGroup
+
+	streams     context.Context // log streams, see [Engine.Follow]
+	stopStreams context.CancelFunc
+
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+	logs    map[string]*liveLog
+	paused  bool // no queued jobs are started, see [Engine.Pause]
+}
+
+// NewEngine returns an engine whose jobs stop when ctx is cancelled. The pool size is the
+// "jobWorkers" config value, 0 means a quarter of the CPUs.
+func NewEngine(ctx context.Context) (*Engine, error) {
+	root, err := Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create jobs dir: %w", err)
+	}
+	workers, err := config.Get[int](ctx, "jobWorkers")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobWorkers from config: %w", err)
+	}
+	if workers <= 0 {
+		workers = max(1, runtime.NumCPU()/4)
+	}
+	streams, stopStreams := context.WithCancel(ctx)
+	return &Engine{
+		ctx:         ctx,
+		root:        root,
+		workers:     workers,
+		pid:         os.Getpid(),
+		slots:       make(chan struct{}, workers),
+		wake:        make(chan struct{}, 1),
+		ping:        make(chan struct{}),
+		streams:     streams,
+		stopStreams: stopStreams,
+		running:     map[string]context.CancelCauseFunc{},
+		logs:        map[string]*liveLog{},
+	}, nil
+}
+
+// Start recovers the jobs of a daemon that died and starts dispatching queued jobs.
+func (e *Engine) Start() error {
+	if err := e.recover(); err != nil {
+		return fmt.Errorf("failed to recover jobs: %w", err)
+	}
+	e.wg.Add(1)
+	go e.dispatch()
+	xlog.Infof(e.ctx, "job engine started with %d workers", e.workers)
+	return nil
+}
+
+// Wait blocks until the dispatcher and all workers have stopped, after the engine's context is
+// cancelled. Running jobs are requeued, call it before closing the database.
+func (e *Engine) Wait() {
+	e.wg.Wait()
+}
+
+// Pause stops starting queued jobs until [Engine.Resume], running jobs carry on. Jobs are
+// still accepted and queued meanwhile.
+func (e *Engine) Pause() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.paused = true
+}
+
+// Resume starts queued jobs again after [Engine.Pause].
+func (e *Engine) Resume() {
+	e.mu.Lock()
+	e.paused = false
+	e.mu.Unlock()
+	e.notify()
+}
+
+// Active returns the number of running jobs.
+func (e *Engine) Active() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.running)
+}
+
+// HealthCheck fails if the dispatcher doesn't answer before ctx is done, e.g. it's stuck on
+// the database or the engine's lock, so the watchdog stops pinging and systemd restarts a
+// daemon whose jobs would otherwise sit queued forever.
+func (e *Engine) HealthCheck(ctx context.Context) error {
+	select {
+	case e.ping <- struct{}{}:
+		return nil
+	case <-e.ctx.Done():
+		return e.ctx.Err()
+	case <-ctx.Done():
+		return errors.New("job dispatcher not responding")
+	}
+}
+
+// Submit validates a job, snapshots its project into a new work dir and queues it.
+func (e *Engine) Submit(spec Spec) (Job, error) {
+	switch spec.Type {
+	case TypeLint, TypeBuild, TypeRun:
+	default:
+		return Job{}, fmt.Errorf("%w: unknown type %q, expected lint, build or run", ErrInvalid, spec.Type)
+	}
+	if spec.Timeout < 0 {
+		return Job{}, fmt.Errorf("%w: negative timeout", ErrInvalid)
+	}
+	if !filepath.IsAbs(spec.Manifest) {
+		return Job{}, fmt.Errorf("%w: manifest path %q isn't absolute", ErrInvalid, spec.Manifest)
+	}
+	m, err := project.Load(spec.Manifest)
+	if err != nil {
+		return Job{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if spec.Type == TypeRun && len(m.Testbench) == 0 {
+		return Job{}, fmt.Errorf("%w: %s has no testbench to run", ErrInvalid, m.Name)
+	}
+
+	now := time.Now()
+	j := Job{Spec: spec, ID: newID(now), Project: m.Name, State: StateQueued, CreatedAt: now}
+	j.Workdir = filepath.Join(e.root, j.ID)
+	if err := os.Mkdir(j.Workdir, 0o700); err != nil {
+		return Job{}, fmt.Errorf("failed to create job dir: %w", err)
+	}
+	if err := os.Mkdir(j.Dir(), 0o700); err != nil {
+		os.RemoveAll(j.Workdir)
+		return Job{}, fmt.Errorf("failed to create job dir: %w", err)
+	}
+	if err := snapshot(m, filepath.Join(j.Dir(), srcDirName)); err != nil {
+		os.RemoveAll(j.Workdir)
+		return Job{}, fmt.Errorf("failed to copy project: %w", err)
+	}
+	if err := create(e.ctx, &j); err != nil {
+		os.RemoveAll(j.Workdir)
+		return Job{}, fmt.Errorf("failed to store job: %w", err)
+	}
+	xlog.Infof(e.ctx, "job %s queued: %s %s", j.ID, j.Type, j.Project)
+	e.notify()
+	return j, nil
+}
+
+// Cancel stops a running job or dequeues a queued one.
+func (e *Engine) Cancel(id string) (Job, error) {
+	if !ValidID(id) {
+		return Job{}, ErrNotFound
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cancel, ok := e.running[id]; ok {
+		cancel(errCancelled) // the worker records the state
+		return Get(e.ctx, id)
+	}
+	return update(e.ctx, id, func(j *Job) error {
+		switch j.State {
+		case StateQueued:
+			j.State, j.EndedAt = StateCancelled, time.Now()
+			return nil
+		case StateRunning:
+			return fmt.Errorf("%w: job %s is running in another daemon (pid %d)", ErrInvalid, j.ID, j.PID)
+		}
+		return fmt.Errorf("%w: job %s already %s", ErrInvalid, j.ID, j.State)
+	})
+}
+
+func (e *Engine) notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch starts queued jobs while workers are free.
+func (e *Engine) dispatch() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(dispatchTick)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+	for {
+		if time.Since(lastPrune) > pruneTick {
+			lastPrune = time.Now()
+			if err := e.prune(); err != nil {
+				xlog.Errorf(e.ctx, "failed to prune jobs: %s", err)
+			}
+		}
+	fill:
+		for {
+			select {
+			case e.slots <- struct{}{}:
+			default:
+				break fill // all workers busy
+			}
+			if !e.startNext() {
+				<-e.slots
+				break
+			}
+		}
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.wake:
+		case <-e.ping:
+		case <-ticker.C:
+		}
+	}
+}
+
+// startNext claims the oldest queued job and runs it in a worker holding a slot, it returns
+// false if there was none or the engine is paused.
+func (e *Engine) startNext() bool {
+	if e.ctx.Err() != nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.paused {
+		return false
+	}
+	j, ok, err := claim(e.ctx, e.pid)
+	if err != nil {
+		xlog.Errorf(e.ctx, "failed to claim a job: %s", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	ctx, cancel := context.WithCancelCause(e.ctx)
+	e.running[j.ID] = cancel
+	e.logs[j.ID] = newLiveLog()
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer func() { <-e.slots }()
+		e.run(ctx, j)
+		e.mu.Lock()
+		delete(e.running, j.ID)
+		live := e.logs[j.ID]
+		delete(e.logs, j.ID)
+		e.mu.Unlock()
+		live.notify() // followers see the final state
+		cancel(nil)
+		e.notify()
+	}()
+	return true
+}
+
+// run runs one attempt of a job and records how it ended.
+func (e *Engine) run(ctx context.Context, j Job) {
+	timeout := j.Timeout
+	if timeout == 0 {
+		timeout = e.defaultTimeout()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, errTimeout)
+		defer cancel()
+	}
+
+	xlog.Infof(e.ctx, "job %s started: %s %s, attempt %d", j.ID, j.Type, j.Project, j.Attempts)
+	var result string
+	var exitCode int
+	f, err := openFile(j.Log(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	e.mu.Lock()
+	log := &logWriter{f: f, live: e.logs[j.ID]}
+	e.mu.Unlock()
+	if err == nil {
+		fmt.Fprintf(log, "===== %s %s job %s, attempt %d =====\n", time.Now().Format(time.RFC3339), j.Type, j.ID, j.Attempts)
+		result, exitCode, err = e.execute(ctx, &j, log)
+	} else {
+		err = fmt.Errorf("failed to open job log: %w", err)
+	}
+
+	state, msg := StateSucceeded, ""
+	cause := context.Cause(ctx)
+	switch {
+	case err == nil:
+	case errors.Is(cause, errCancelled):
+		state, msg = StateCancelled, "cancelled"
+	case e.ctx.Err() != nil:
+		state, msg = StateQueued, "interrupted by daemon shutdown, requeued"
+	case errors.Is(cause, errTimeout):
+		state, msg = StateFailed, fmt.Sprintf("timed out after %s", timeout)
+	default:
+		state, msg = StateFailed, err.Error()
+	}
+	if f != nil {
+		if msg != "" {
+			fmt.Fprintf(log, "===== %s: %s =====\n", state, msg)
+		} else {
+			fmt.Fprintf(log, "===== %s in %s =====\n", state, time.Since(j.StartedAt).Round(time.Millisecond))
+		}
+		f.Close()
+	}
+
+	_, err = update(e.ctx, j.ID, func(stored *Job) error {
+		stored.State, stored.Error, stored.Result, stored.ExitCode = state, msg, result, exitCode
+		stored.PID = 0
+		if state == StateQueued {
+			stored.Attempts-- // shutdowns don't count towards jobMaxAttempts
+		} else {
+			stored.EndedAt = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		xlog.Errorf(e.ctx, "failed to record the end of job %s: %s", j.ID, err)
+		return
+	}
+	xlog.Infof(e.ctx, "job %s %s", j.ID, state)
+}
+
+func (e *Engine) defaultTimeout() time.Duration {
+	s, err := config.Get[string](e.ctx, "jobTimeout")
+	if err != nil {
+		xlog.Errorf(e.ctx, "failed to get jobTimeout from config: %s", err)
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		xlog.Errorf(e.ctx, "invalid jobTimeout %q: %s", s, err)
+		return 0
+	}
+	return d
+}
+
+// recover handles jobs left running by a daemon that is gone: they are requeued, or failed once
+// they were interrupted "jobMaxAttempts" times, in case they are what brings the daemon down.
+// Jobs of a daemon still alive, the previous one during an update handoff, are left to it, it
+// requeues them when it stops.
+func (e *Engine) recover() error {
+	maxAttempts, err := config.Get[int](e.ctx, "jobMaxAttempts")
+	if err != nil {
+		return fmt.Errorf("failed to get jobMaxAttempts from config: %w", err)
+	}
+	all, err := List(e.ctx)
+	if err != nil {
+		return err
+	}
+	for _, j := range all {
+		if j.State != StateRunning || (j.PID != e.pid && daemonAlive(j.PID)) {
+			continue
+		}
+		_, err := update(e.ctx, j.ID, func(j *Job) error {
+			j.PID = 0
+			if j.Attempts >= maxAttempts {
+				j.State, j.EndedAt = StateFailed, time.Now()
+				j.Error = fmt.Sprintf("the daemon stopped while running it, %d times", j.Attempts)
+			} else {
+				j.State, j.Error = StateQueued, "the daemon stopped while running it, requeued"
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		xlog.Warnf(e.ctx, "job %s was running when the daemon stopped, attempt %d", j.ID, j.Attempts)
+	}
+	return nil
+}
+
+// daemonAlive reports whether pid is a running process of this program.
+func daemonAlive(pid int) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return false
+	}
+	self, err := os.ReadFile("/proc/self/comm")
+	return err == nil && strings.TrimSpace(string(comm)) == strings.TrimSpace(string(self))
+}
+
+// prune removes finished jobs older than the "jobRetention" config value and their work dirs.
+func (e *Engine) prune() error {
+	s, err := config.Get[string](e.ctx, "jobRetention")
+	if err != nil {
+		return fmt.Errorf("failed to get jobRetention from config: %w", err)
+	}
+	retention, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid jobRetention %q: %w", s, err)
+	}
+	if retention <= 0 {
+		return nil // keep forever
+	}
+	all, err := List(e.ctx)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, j := range all {
+		if !j.Finished() || time.Since(j.EndedAt) < retention {
+			continue
+		}
+		if err := remove(e.ctx, &j); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Join(e.root, j.ID)); err != nil {
+			xlog.Warnf(e.ctx, "failed to remove work dir of job %s: %s", j.ID, err)
+		}
+		n++
+	}
+	if n > 0 {
+		xlog.Infof(e.ctx, "pruned %d finished jobs", n)
+	}
+	return nil
+}
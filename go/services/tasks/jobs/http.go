@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"ssv/go/services/tasks/results"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xhttp"
+	"github.com/Data-Corruption/stdx/xlog"
+	"github.com/coder/websocket"
+)
+
+// ServeLogs streams the log of the job {id}: GET .../jobs/{id}/logs
+//
+// WebSocket upgrades get the log as binary messages, then the job as a JSON text message, then
+// a normal close. Other requests get Server-Sent Events: "log" events whose data lines are
+// complete log lines and whose id is the byte offset after them, then an "end" event with the
+// job. ?offset=N resumes at byte N, for SSE the Last-Event-ID header does the same, so
+// reconnecting EventSources resume by themselves. ?follow=false stops at the current end of
+// the log instead of following it until the job finishes.
+func (e *Engine) ServeLogs(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := Get(e.ctx, id); err != nil {
+		code := 500
+		if errors.Is(err, ErrNotFound) {
+			code = 404
+		}
+		xhttp.Error(e.ctx, w, &xhttp.Err{Code: code, Msg: err.Error(), Err: err})
+		return
+	}
+	offsetStr := r.URL.Query().Get("offset")
+	if offsetStr == "" {
+		offsetStr = r.Header.Get("Last-Event-ID")
+	}
+	var offset int64
+	if offsetStr != "" {
+		var err error
+		if offset, err = strconv.ParseInt(offsetStr, 10, 64); err != nil || offset < 0 {
+			xhttp.Error(e.ctx, w, &xhttp.Err{Code: 400, Msg: "invalid offset " + strconv.Quote(offsetStr)})
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") != "false"
+
+	// streams outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		rc.SetReadDeadline(time.Time{})
+		e.serveLogsWebSocket(w, r, id, offset, follow)
+		return
+	}
+	e.serveLogsSSE(w, r, rc, id, offset, follow)
+}
+
+func (e *Engine) serveLogsSSE(w http.ResponseWriter, r *http.Request, rc *http.ResponseController, id string, offset int64, follow bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	// events carry whole lines, a partial last line waits for the rest
+	var pending []byte
+	pos := offset // of pending[0]
+	event := func(data []byte, end int64) error {
+		fmt.Fprintf(w, "id: %d\nevent: log\n", end)
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		_, err := fmt.Fprint(w, "\n")
+		return err
+	}
+	j, err := e.Follow(r.Context(), id, offset, follow, func(p []byte, _ int64) error {
+		if len(p) == 0 {
+			fmt.Fprint(w, ": ping\n\n")
+			return rc.Flush()
+		}
+		pending = append(pending, p...)
+		i := bytes.LastIndexByte(pending, '\n')
+		if i < 0 {
+			return nil
+		}
+		// the last newline is implied by the event, but counted in its id
+		if err := event(pending[:i], pos+int64(i+1)); err != nil {
+			return err
+		}
+		pos += int64(i + 1)
+		pending = append([]byte(nil), pending[i+1:]...)
+		return rc.Flush()
+	})
+	if err != nil {
+		if r.Context().Err() == nil && !errors.Is(err, context.Canceled) {
+			xlog.Warnf(e.ctx, "log stream of job %s: %s", id, err)
+		}
+		return // the client reconnects from the last event id
+	}
+	if len(pending) > 0 {
+		event(pending, pos+int64(len(pending))) // no newline, the id tells
+	}
+	data, _ := json.Marshal(j)
+	fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+	rc.Flush()
+}
+
+func (e *Engine) serveLogsWebSocket(w http.ResponseWriter, r *http.Request, id string, offset int64, follow bool) {
+	c, err := websocket.Accept(w, r, nil) // rejects cross-origin requests
+	if err != nil {
+		return // Accept wrote the error
+	}
+	defer c.CloseNow()
+	ctx := c.CloseRead(r.Context())
+	j, err := e.Follow(ctx, id, offset, follow, func(p []byte, _ int64) error {
+		if len(p) == 0 {
+			return nil // websocket has its own keepalive
+		}
+		return c.Write(ctx, websocket.MessageBinary, p)
+	})
+	if err != nil {
+		if e.streams.Err() != nil {
+			c.Close(websocket.StatusGoingAway, "daemon stopping")
+		}
+		return
+	}
+	data, _ := json.Marshal(j)
+	if err := c.Write(ctx, websocket.MessageText, data); err != nil {
+		return
+	}
+	c.Close(websocket.StatusNormalClosure, "")
+}
+
+// ServeReport serves the test report of the run job {id}: GET .../jobs/{id}/report
+// ?format=junit, the default, gets JUnit XML and ?format=json the JSON report.
+func (e *Engine) ServeReport(w http.ResponseWriter, r *http.Request) {
+	j, err := Get(e.ctx, r.PathValue("id"))
+	if err != nil {
+		code := 500
+		if errors.Is(err, ErrNotFound) {
+			code = 404
+		}
+		xhttp.Error(e.ctx, w, &xhttp.Err{Code: code, Msg: err.Error(), Err: err})
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = results.FormatJUnit
+	}
+	path, err := j.Report(format)
+	if err != nil {
+		xhttp.Error(e.ctx, w, &xhttp.Err{Code: 400, Msg: err.Error()})
+		return
+	}
+	f, err := j.OpenReport(format)
+	if errors.Is(err, fs.ErrNotExist) {
+		xhttp.Error(e.ctx, w, &xhttp.Err{Code: 404, Msg: "job has no test report"})
+		return
+	} else if err != nil {
+		xhttp.Error(e.ctx, w, &xhttp.Err{Code: 500, Msg: "failed to read test report", Err: err})
+		return
+	}
+	defer f.Close()
+	contentType := "application/xml"
+	if format == results.FormatJSON {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", j.ID+"-"+filepath.Base(path)))
+	io.Copy(w, f)
+}
+
+// LocalOnly serves only requests from this host: loopback addresses and unix sockets, not
+// forwarded by a proxy. For endpoints exposing job data until the web app has sessions.
+func LocalOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local := true
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ip := net.ParseIP(host)
+			local = ip != nil && ip.IsLoopback()
+		}
+		if !local || r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Forwarded") != "" {
+			xhttp.Error(r.Context(), w, &xhttp.Err{Code: 403, Msg: "only available from the daemon's host"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,340 @@
+// Package jobs runs lint, build and simulation jobs in the daemon. Jobs are persisted in the
+// job DBI and run by a bounded pool of workers, each in its own work dir holding a snapshot of
+// the project, the job log and its outputs.
+//
+// Layout, under <data dir>/jobs:
+//
+//	<id>/work/        the only dir the job's commands can write when sandboxed, run jobs start in it
+//	<id>/work/src/    snapshot of the project dir taken at submit time, without its out dir
+//	<id>/job.log      output of every attempt
+//	<id>/lint.json    reports, out of the commands' reach as the daemon writes and serves them,
+//	                  run jobs write results.json and junit.xml
+//	<id>/.sandbox/    where sandboxes mount their root
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"ssv/go/database"
+	"ssv/go/database/datapath"
+	"ssv/go/database/helpers"
+	"ssv/go/services/tasks/results"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Data-Corruption/lmdb-go/lmdb"
+	"github.com/Data-Corruption/lmdb-go/wrap"
+)
+
+// Job types.
+const (
+	TypeLint  = "lint"  // verilator --lint-only, see the lint package
+	TypeBuild = "build" // ssv build
+	TypeRun   = "run"   // build, then run the testbench executable
+)
+
+// Job states.
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+const (
+	dirName     = "jobs"
+	workDirName = "work"
+	srcDirName  = "src"
+	sandboxName = ".sandbox"
+	logName     = "job.log"
+	resultsName = "results.json"
+	junitName   = "junit.xml"
+	jobPrefix   = "job."
+	queuePrefix = "queue."
+)
+
+var (
+	ErrNotFound = errors.New("no such job")
+	ErrInvalid  = errors.New("invalid job")
+)
+
+// Spec is what to run, as submitted.
+type Spec struct {
+	Type     string        `json:"type"`
+	Manifest string        `json:"manifest"`          // absolute path of the project's ssv.toml
+	Version  string        `json:"version,omitempty"` // verilator version, overrides the manifest's pin
+	Args     []string      `json:"args,omitempty"`    // extra verilator args for lint, simulation args for run
+	Timeout  time.Duration `json:"timeout,omitempty"` // 0 uses the jobTimeout config value
+}
+
+// Job is a submitted job and its state.
+type Job struct {
+	Spec
+	ID        string    `json:"id"`
+	Project   string    `json:"project"` // manifest name
+	State     string    `json:"state"`
+	Workdir   string    `json:"workdir"`
+	CreatedAt time.Time `json:"createdAt"`
+	StartedAt time.Time `json:"startedAt,omitzero"` // of the last attempt
+	EndedAt   time.Time `json:"endedAt,omitzero"`
+	Attempts  int       `json:"attempts"`
+	PID       int       `json:"pid,omitempty"` // of the daemon running it
+	ExitCode  int       `json:"exitCode"`      // of the simulation, run jobs only
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Finished reports whether the job reached a final state.
+func (j *Job) Finished() bool {
+	return j.State == StateSucceeded || j.State == StateFailed || j.State == StateCancelled
+}
+
+// Log returns the path of the job's log.
+func (j *Job) Log() string {
+	return filepath.Join(j.Workdir, logName)
+}
+
+// Dir returns the dir the job's commands run in, the only one they can write when sandboxed.
+func (j *Job) Dir() string {
+	return filepath.Join(j.Workdir, workDirName)
+}
+
+// OpenLog opens the job's log for reading.
+func (j *Job) OpenLog() (*os.File, error) {
+	return openFile(j.Log(), os.O_RDONLY, 0)
+}
+
+// OpenReport opens a run job's test report in format for reading, see [Job.Report].
+func (j *Job) OpenReport(format string) (*os.File, error) {
+	path, err := j.Report(format)
+	if err != nil {
+		return nil, err
+	}
+	return openFile(path, os.O_RDONLY, 0)
+}
+
+// openFile opens a file the daemon keeps in a job's work dir without following a symlink at
+// path, in case an unsandboxed command planted one.
+func openFile(path string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(path, flag|syscall.O_NOFOLLOW, perm)
+}
+
+// Report returns the path of a run job's test report in format, results.FormatJSON or
+// results.FormatJUnit.
+func (j *Job) Report(format string) (string, error) {
+	switch format {
+	case results.FormatJSON:
+		return filepath.Join(j.Workdir, resultsName), nil
+	case results.FormatJUnit:
+		return filepath.Join(j.Workdir, junitName), nil
+	}
+	return "", fmt.Errorf("unknown report format %q, expected junit or json", format)
+}
+
+// Root returns the dir holding the job work dirs.
+func Root(ctx context.Context) (string, error) {
+	dataPath := datapath.FromContext(ctx)
+	if dataPath == "" {
+		return "", errors.New("data path not set in context")
+	}
+	return filepath.Join(dataPath, dirName), nil
+}
+
+// newID returns a job id, sortable by creation time: 20261018-142501-3fa9c2.
+func newID(now time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return now.UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// ValidID reports whether id has the shape of a job id, so it's safe in paths and keys.
+func ValidID(id string) bool {
+	if len(id) != len("20060102-150405-000000") {
+		return false
+	}
+	return strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r == '-')
+	}) < 0
+}
+
+func jobKey(id string) []byte {
+	return []byte(jobPrefix + id)
+}
+
+func queueKey(j *Job) []byte {
+	return []byte(fmt.Sprintf("%s%020d.%s", queuePrefix, j.CreatedAt.UnixNano(), j.ID))
+}
+
+// helper for funcs doing txns
+func getJobDB(ctx context.Context) (*wrap.DB, lmdb.DBI, error) {
+	return helpers.GetDbAndDBI(ctx, database.JobDBIName)
+}
+
+// Get returns a job.
+func Get(ctx context.Context, id string) (Job, error) {
+	var j Job
+	if !ValidID(id) {
+		return j, ErrNotFound
+	}
+	db, dbi, err := getJobDB(ctx)
+	if err != nil {
+		return j, err
+	}
+	err = db.View(func(txn *lmdb.Txn) error {
+		return helpers.GetAndUnmarshal(txn, dbi, jobKey(id), &j)
+	})
+	if lmdb.IsNotFound(err) {
+		return j, ErrNotFound
+	}
+	return j, err
+}
+
+// List returns all jobs, newest first.
+func List(ctx context.Context) ([]Job, error) {
+	db, dbi, err := getJobDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []Job{}
+	err = db.View(func(txn *lmdb.Txn) error {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		prefix := []byte(jobPrefix)
+		k, v, err := cur.Get(prefix, nil, lmdb.SetRange)
+		for ; err == nil && bytes.HasPrefix(k, prefix); k, v, err = cur.Get(nil, nil, lmdb.Next) {
+			var j Job
+			if err := json.Unmarshal(v, &j); err != nil {
+				return fmt.Errorf("unmarshal %q: %w", string(k), err)
+			}
+			out = append(out, j)
+		}
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	slices.Reverse(out) // ids sort by creation time
+	return out, err
+}
+
+// update applies fn to a stored job in a write txn, keeping the queue index in sync with its
+// state. fn returning an error aborts the txn.
+func update(ctx context.Context, id string, fn func(j *Job) error) (Job, error) {
+	var j Job
+	db, dbi, err := getJobDB(ctx)
+	if err != nil {
+		return j, err
+	}
+	err = db.Update(func(txn *lmdb.Txn) error {
+		if err := helpers.GetAndUnmarshal(txn, dbi, jobKey(id), &j); err != nil {
+			return err
+		}
+		wasQueued := j.State == StateQueued
+		if err := fn(&j); err != nil {
+			return err
+		}
+		return put(txn, dbi, &j, wasQueued)
+	})
+	if lmdb.IsNotFound(err) {
+		return j, ErrNotFound
+	}
+	return j, err
+}
+
+// create stores a new job.
+func create(ctx context.Context, j *Job) error {
+	db, dbi, err := getJobDB(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(txn *lmdb.Txn) error {
+		return put(txn, dbi, j, false)
+	})
+}
+
+// put stores a job and adds or removes its queue entry.
+func put(txn *lmdb.Txn, dbi lmdb.DBI, j *Job, wasQueued bool) error {
+	if err := helpers.MarshalAndPut(txn, dbi, jobKey(j.ID), j); err != nil {
+		return err
+	}
+	switch isQueued := j.State == StateQueued; {
+	case isQueued && !wasQueued:
+		return txn.Put(dbi, queueKey(j), []byte(j.ID), 0)
+	case !isQueued && wasQueued:
+		if err := txn.Del(dbi, queueKey(j), nil); err != nil && !lmdb.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// claim moves the oldest queued job to running for the daemon pid, it returns false if the
+// queue is empty.
+func claim(ctx context.Context, pid int) (Job, bool, error) {
+	var j Job
+	found := false
+	db, dbi, err := getJobDB(ctx)
+	if err != nil {
+		return j, false, err
+	}
+	err = db.Update(func(txn *lmdb.Txn) error {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(queuePrefix)
+		k, v, err := cur.Get(prefix, nil, lmdb.SetRange)
+		cur.Close()
+		if lmdb.IsNotFound(err) || (err == nil && !bytes.HasPrefix(k, prefix)) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := helpers.GetAndUnmarshal(txn, dbi, jobKey(string(v)), &j); err != nil {
+			if lmdb.IsNotFound(err) { // stale index entry
+				return txn.Del(dbi, bytes.Clone(k), nil)
+			}
+			return err
+		}
+		j.State = StateRunning
+		j.StartedAt = time.Now()
+		j.EndedAt = time.Time{}
+		j.Attempts++
+		j.PID = pid
+		j.Error, j.Result, j.ExitCode = "", "", 0
+		found = true
+		return put(txn, dbi, &j, true)
+	})
+	return j, found, err
+}
+
+// remove deletes a job from the database.
+func remove(ctx context.Context, j *Job) error {
+	db, dbi, err := getJobDB(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(txn *lmdb.Txn) error {
+		for _, k := range [][]byte{jobKey(j.ID), queueKey(j)} {
+			if err := txn.Del(dbi, k, nil); err != nil && !lmdb.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
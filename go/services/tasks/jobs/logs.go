@@ -0,0 +1,138 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	logChunk     = 32 << 10
+	logPollTick  = time.Second      // for jobs not running here: queued, or in another daemon
+	logHeartbeat = 15 * time.Second // keeps idle streams alive through proxies
+)
+
+// liveLog wakes up the followers of a job running in this daemon when its log grows.
+type liveLog struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every write
+}
+
+func newLiveLog() *liveLog {
+	return &liveLog{changed: make(chan struct{})}
+}
+
+func (l *liveLog) wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+func (l *liveLog) notify() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// logWriter appends a job's output, both streams, to its log file and wakes up its followers.
+type logWriter struct {
+	f    *os.File
+	live *liveLog
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if n > 0 {
+		w.live.notify()
+	}
+	return n, err
+}
+
+// Follow sends a job's log from byte offset on: what's there, then, with follow, what's written
+// until the job finishes. send gets the bytes and the offset after them; it is called with no
+// bytes every [logHeartbeat] while nothing is written. Follow returns the job as of its last
+// read, finished unless ctx was cancelled, the daemon is stopping or follow is false.
+func (e *Engine) Follow(ctx context.Context, id string, offset int64, follow bool, send func(p []byte, offset int64) error) (Job, error) {
+	heartbeat := time.NewTicker(logHeartbeat)
+	defer heartbeat.Stop()
+	poll := time.NewTicker(logPollTick)
+	defer poll.Stop()
+	for {
+		// wait for changes from before reading, so none are missed
+		e.mu.Lock()
+		live := e.logs[id]
+		e.mu.Unlock()
+		var changed <-chan struct{}
+		var tick <-chan time.Time
+		if live != nil {
+			changed = live.wait()
+		} else {
+			tick = poll.C
+		}
+
+		j, err := Get(e.ctx, id)
+		if err != nil {
+			return j, err
+		}
+		// the log is complete once the state is final, it's written first
+		n, err := readLog(j.Log(), offset, send)
+		offset += n
+		if err != nil || !follow || j.Finished() {
+			return j, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return j, ctx.Err()
+		case <-e.streams.Done():
+			return j, e.streams.Err()
+		case <-heartbeat.C:
+			if err := send(nil, offset); err != nil {
+				return j, err
+			}
+		case <-changed:
+		case <-tick:
+		}
+	}
+}
+
+// StopStreams ends the log streams of [Engine.Follow], when the daemon starts shutting down
+// so they don't hold it up.
+func (e *Engine) StopStreams() {
+	e.stopStreams()
+}
+
+// readLog sends path from offset to its current end and returns the number of bytes sent.
+// A log that doesn't exist yet is empty.
+func readLog(path string, offset int64, send func(p []byte, offset int64) error) (int64, error) {
+	f, err := openFile(path, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	buf := make([]byte, logChunk)
+	var total int64
+	for {
+		n, err := f.ReadAt(buf, offset+total)
+		if n > 0 {
+			total += int64(n)
+			if err := send(buf[:n], offset+total); err != nil {
+				return total, err
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"ssv/go/services/tasks/buildcache"
+	"ssv/go/services/tasks/lint"
+	"ssv/go/services/tasks/project"
+	"ssv/go/services/tasks/results"
+	"ssv/go/services/tasks/verilator"
+	"strings"
+)
+
+const lintReportName = "lint.json"
+
+// execute runs a job's work in its work dir, writing its output to log. It returns a short
+// result and, for run jobs, the simulation's exit code.
+func (e *Engine) execute(ctx context.Context, j *Job, log io.Writer) (string, int, error) {
+	m, err := project.Load(filepath.Join(j.Dir(), srcDirName, filepath.Base(j.Manifest)))
+	if err != nil {
+		return "", 0, err
+	}
+	sb, err := e.sandbox(j, log)
+	if err != nil {
+		return "", 0, err
+	}
+	var wrap func(*exec.Cmd) error
+	if sb != nil {
+		defer sb.Close()
+		wrap = sb.Wrap
+	}
+	switch j.Type {
+	case TypeLint:
+		return e.lint(ctx, j, m, wrap, log)
+	case TypeBuild:
+		product, err := e.build(ctx, j, m, wrap, log)
+		return product, 0, err
+	case TypeRun:
+		product, err := e.build(ctx, j, m, wrap, log)
+		if err != nil {
+			return "", 0, err
+		}
+		return e.simulate(ctx, j, m, product, wrap, log)
+	}
+	return "", 0, fmt.Errorf("unknown job type %q", j.Type)
+}
+
+func (e *Engine) lint(ctx context.Context, j *Job, m *project.Manifest, wrap func(*exec.Cmd) error, log io.Writer) (string, int, error) {
+	report, err := lint.Run(ctx, lint.Options{Version: j.Version, Manifest: m, Args: j.Args, Wrap: wrap})
+	if err != nil {
+		return "", 0, err
+	}
+	if err := report.Write(log, lint.FormatText); err != nil {
+		return "", 0, err
+	}
+	f, err := openFile(filepath.Join(j.Workdir, lintReportName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write lint report: %w", err)
+	}
+	defer f.Close()
+	if err := report.Write(f, lint.FormatJSON); err != nil {
+		return "", 0, fmt.Errorf("failed to write lint report: %w", err)
+	}
+	if errs, _, _ := report.Counts(); errs > 0 {
+		return report.Summary(), 0, errors.New(report.Summary())
+	}
+	return report.Summary(), 0, nil
+}
+
+// build builds the model, sharing the CPUs between the workers.
+func (e *Engine) build(ctx context.Context, j *Job, m *project.Manifest, wrap func(*exec.Cmd) error, log io.Writer) (string, error) {
+	opts := project.BuildOptions{Version: j.Version, Jobs: max(1, runtime.NumCPU()/e.workers), Wrap: wrap}
+	enabled, err := buildcache.Enabled(e.ctx)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		if opts.Cache, err = buildcache.Open(e.ctx); err != nil {
+			return "", err
+		}
+	}
+	return project.Build(ctx, m, opts, log)
+}
+
+// simulate runs the testbench executable from the job's work dir and writes the test reports
+// of its output. Failed tests fail the job even if the simulation exits with status 0.
+func (e *Engine) simulate(ctx context.Context, j *Job, m *project.Manifest, product string, wrap func(*exec.Cmd) error, log io.Writer) (string, int, error) {
+	parser, err := results.NewParser(results.Options{
+		Suite: m.Name,
+		Args:  j.Args,
+		Test:  m.Results.Test,
+		Pass:  m.Results.Pass,
+		Fail:  m.Results.Fail,
+		Seed:  m.Results.Seed,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	fmt.Fprintf(log, "===== %s %s =====\n", filepath.Base(product), strings.Join(j.Args, " "))
+	cmd := exec.CommandContext(ctx, product, j.Args...)
+	cmd.Dir = j.Dir()
+	out := io.MultiWriter(log, parser)
+	cmd.Stdout, cmd.Stderr = out, out // the same writer, exec serializes the writes
+	verilator.KillGroup(cmd)
+	if wrap != nil {
+		if err := wrap(cmd); err != nil {
+			return "", 0, err
+		}
+	}
+	err = cmd.Run()
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && ctx.Err() == nil:
+		code = exitErr.ExitCode()
+	case err != nil:
+		code = -1
+	}
+
+	report := parser.Finish(code)
+	fmt.Fprintln(log, "===== results =====")
+	report.Write(log, results.FormatText)
+	if werr := writeReports(j, report); werr != nil {
+		fmt.Fprintf(log, "%s\n", werr)
+	}
+	result := report.Summary()
+	if code >= 0 {
+		result += fmt.Sprintf(", exit status %d", code)
+	}
+	switch {
+	case code > 0:
+		return result, code, fmt.Errorf("simulation exited with status %d", code)
+	case err != nil:
+		return result, 0, fmt.Errorf("simulation failed: %w", err)
+	case report.Failed():
+		tests, failed := report.Counts()
+		return result, 0, fmt.Errorf("%d of %d tests failed", failed, tests)
+	}
+	return result, 0, nil
+}
+
+// writeReports writes the JSON and JUnit reports of a run job.
+func writeReports(j *Job, report *results.Report) error {
+	for _, format := range []string{results.FormatJSON, results.FormatJUnit} {
+		path, err := j.Report(format)
+		if err != nil {
+			return err
+		}
+		f, err := openFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to write test report: %w", err)
+		}
+		err = report.Write(f, format)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write test report: %w", err)
+		}
+	}
+	return nil
+}
+
+// snapshot copies the project dir to dst, without its out dir and hidden files, so jobs build
+// what was submitted and don't race each other or the user's own builds.
+func snapshot(m *project.Manifest, dst string) error {
+	out := m.OutPath()
+	return filepath.WalkDir(m.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(m.Dir, path)
+		target := filepath.Join(dst, rel)
+		if path != m.Dir && (path == out || strings.HasPrefix(d.Name(), ".")) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil // sockets, fifos
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
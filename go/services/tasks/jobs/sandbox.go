@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"ssv/go/database/config"
+	"ssv/go/services/tasks/sandbox"
+	"ssv/go/services/tasks/verilator"
+)
+
+// sandbox returns the sandbox for a job's commands, nil when jobSandbox is off, or auto and
+// sandboxes aren't supported here.
+func (e *Engine) sandbox(j *Job, log io.Writer) (*sandbox.Sandbox, error) {
+	mode, err := config.Get[string](e.ctx, "jobSandbox")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobSandbox from config: %w", err)
+	}
+	switch mode {
+	case "off":
+		return nil, nil
+	case "on", "auto":
+	default:
+		return nil, fmt.Errorf("invalid jobSandbox %q, expected on, off or auto", mode)
+	}
+	if err := sandbox.Available(); err != nil {
+		if mode == "on" {
+			return nil, fmt.Errorf("sandbox unavailable: %w", err)
+		}
+		fmt.Fprintf(log, "⚠ running without a sandbox, it's unavailable here: %s\n", err)
+		return nil, nil
+	}
+	limits, err := Limits(e.ctx, j.Type)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := config.Get[[]string](e.ctx, "jobSandboxPaths")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobSandboxPaths from config: %w", err)
+	}
+	installs, err := verilator.VersionsDir(e.ctx)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := sandbox.New(e.ctx, sandbox.Options{
+		Name:     j.ID,
+		Workdir:  j.Dir(),
+		Root:     filepath.Join(j.Workdir, sandboxName),
+		ReadOnly: append([]string{installs}, paths...),
+		Limits:   limits,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(log, sb)
+	return sb, nil
+}
+
+// Limits returns the sandbox limits of jobType jobs from jobLimits: those of "*", then the job
+// type's override them.
+func Limits(ctx context.Context, jobType string) (sandbox.Limits, error) {
+	var l sandbox.Limits
+	all, err := config.Get[map[string]string](ctx, "jobLimits")
+	if err != nil {
+		return l, fmt.Errorf("failed to get jobLimits from config: %w", err)
+	}
+	for _, key := range []string{"*", jobType} {
+		if err := l.Parse(all[key]); err != nil {
+			return l, fmt.Errorf("invalid jobLimits %q: %w", key, err)
+		}
+	}
+	return l, nil
+}
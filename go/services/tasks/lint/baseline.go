@@ -0,0 +1,119 @@
+package lint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"ssv/go/services/tasks/verilator"
+	"strings"
+)
+
+const baselineVersion = 1
+
+// Baseline lists known warnings that don't fail a lint run. Entries match by fingerprint, a
+// hash of the warning's code, file and message without the line and column, so they survive
+// unrelated edits. A warning appearing more often than counted is new.
+type Baseline struct {
+	Version  int             `json:"version"`
+	Warnings []BaselineEntry `json:"warnings"`
+}
+
+type BaselineEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Code        string `json:"code,omitempty"`
+	File        string `json:"file,omitempty"`
+	Message     string `json:"message"`
+	Count       int    `json:"count"`
+}
+
+// fingerprint identifies a diagnostic independently of where in its file it is.
+func fingerprint(d verilator.Diagnostic) string {
+	sum := sha256.Sum256([]byte(d.Severity + "\x00" + d.Code + "\x00" + filepath.ToSlash(d.File) + "\x00" + d.Message))
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadBaseline reads a baseline file, a missing one is empty.
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Baseline{Version: baselineVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	if b.Version != baselineVersion {
+		return nil, fmt.Errorf("unsupported baseline version %d in %s", b.Version, path)
+	}
+	return &b, nil
+}
+
+// NewBaseline returns a baseline of the report's warnings. Errors are never baselined.
+func NewBaseline(r *Report) *Baseline {
+	b := &Baseline{Version: baselineVersion, Warnings: []BaselineEntry{}}
+	index := map[string]int{}
+	for _, f := range r.Findings {
+		if f.Severity == verilator.SeverityError {
+			continue
+		}
+		if i, ok := index[f.Fingerprint]; ok {
+			b.Warnings[i].Count++
+			continue
+		}
+		index[f.Fingerprint] = len(b.Warnings)
+		b.Warnings = append(b.Warnings, BaselineEntry{
+			Fingerprint: f.Fingerprint,
+			Code:        f.Code,
+			File:        filepath.ToSlash(f.File),
+			Message:     f.Message,
+			Count:       1,
+		})
+	}
+	// stable order keeps baseline diffs readable
+	slices.SortFunc(b.Warnings, func(a, b BaselineEntry) int {
+		if c := strings.Compare(a.File, b.File); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Code, b.Code); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Message, b.Message)
+	})
+	return b
+}
+
+// Save writes the baseline to path.
+func (b *Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	return nil
+}
+
+// Apply marks the report's warnings listed in the baseline as suppressed.
+func (b *Baseline) Apply(r *Report) {
+	remaining := map[string]int{}
+	for _, e := range b.Warnings {
+		remaining[e.Fingerprint] += e.Count
+	}
+	for i := range r.Findings {
+		f := &r.Findings[i]
+		if f.Severity != verilator.SeverityError && remaining[f.Fingerprint] > 0 {
+			remaining[f.Fingerprint]--
+			f.Suppressed = true
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"ssv/go/services/tasks/verilator"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// report returns a report of diagnostics given as "severity code file:line message".
+func report(diags ...string) *Report {
+	r := &Report{Verilator: "v5.030", Dir: "."}
+	for _, s := range diags {
+		f := strings.SplitN(s, " ", 4)
+		d := verilator.Diagnostic{Severity: f[0], Code: f[1], Message: f[3]}
+		file, line, _ := strings.Cut(f[2], ":")
+		d.File = file
+		d.Line, _ = strconv.Atoi(line)
+		r.Findings = append(r.Findings, Finding{Diagnostic: d, Fingerprint: fingerprint(d)})
+	}
+	return r
+}
+
+func suppressed(r *Report) string {
+	var b strings.Builder
+	for _, f := range r.Findings {
+		if f.Suppressed {
+			b.WriteByte('s')
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+func TestFingerprint(t *testing.T) {
+	r := report(
+		"warning WIDTH rtl/a.sv:3 width",
+		"warning WIDTH rtl/a.sv:40 width", // moved
+		"warning WIDTH rtl/b.sv:3 width",
+		"warning UNUSED rtl/a.sv:3 width",
+		"warning WIDTH rtl/a.sv:3 other",
+		"error WIDTH rtl/a.sv:3 width",
+	)
+	if r.Findings[0].Fingerprint != r.Findings[1].Fingerprint {
+		t.Error("the line changes the fingerprint")
+	}
+	seen := map[string]bool{}
+	for _, f := range r.Findings[1:] {
+		if seen[f.Fingerprint] {
+			t.Errorf("%+v: same fingerprint as another finding", f.Diagnostic)
+		}
+		seen[f.Fingerprint] = true
+	}
+}
+
+func TestBaselineApply(t *testing.T) {
+	base := NewBaseline(report(
+		"warning WIDTH rtl/a.sv:3 width",
+		"warning WIDTH rtl/a.sv:9 width",
+		"warning UNUSED rtl/b.sv:1 unused",
+		"error  rtl/c.sv:1 syntax error",
+	))
+	if len(base.Warnings) != 2 {
+		t.Fatalf("got %d baseline entries, want 2 without the error: %+v", len(base.Warnings), base.Warnings)
+	}
+	if e := base.Warnings[0]; e.File != "rtl/a.sv" || e.Count != 2 {
+		t.Fatalf("first entry %+v, want rtl/a.sv counted twice", e)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		diags []string
+		want  string // s for each suppressed finding, - for the others
+	}{
+		{"same", []string{"warning WIDTH rtl/a.sv:3 width", "warning WIDTH rtl/a.sv:9 width", "warning UNUSED rtl/b.sv:1 unused"}, "sss"},
+		{"moved", []string{"warning WIDTH rtl/a.sv:30 width", "warning UNUSED rtl/b.sv:10 unused"}, "ss"},
+		{"one more", []string{"warning WIDTH rtl/a.sv:1 width", "warning WIDTH rtl/a.sv:3 width", "warning WIDTH rtl/a.sv:9 width"}, "ss-"},
+		{"new", []string{"warning WIDTH rtl/b.sv:3 width", "warning UNUSED rtl/b.sv:1 unused2"}, "--"},
+		{"errors", []string{"error  rtl/c.sv:1 syntax error"}, "-"},
+		{"none", nil, ""},
+	} {
+		r := report(tc.diags...)
+		base.Apply(r)
+		if got := suppressed(r); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	// an empty baseline suppresses nothing
+	r := report("warning WIDTH rtl/a.sv:3 width")
+	(&Baseline{Version: baselineVersion}).Apply(r)
+	if errs, warnings, supp := r.Counts(); errs != 0 || warnings != 1 || supp != 0 {
+		t.Errorf("empty baseline: got %d, %d, %d", errs, warnings, supp)
+	}
+}
+
+func TestBaselineFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lint-baseline.json")
+	b, err := LoadBaseline(path)
+	if err != nil || len(b.Warnings) != 0 {
+		t.Fatalf("missing file: got %+v, %v", b, err)
+	}
+
+	// sorted by file, code and message, so a baseline doesn't change with the output order
+	r := report(
+		"warning WIDTH rtl/b.sv:3 width",
+		"warning WIDTH rtl/a.sv:3 width",
+		"warning UNUSED rtl/a.sv:3 unused",
+	)
+	if err := NewBaseline(r).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err = LoadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, e := range b.Warnings {
+		order = append(order, e.File+" "+e.Code)
+	}
+	if got := strings.Join(order, ", "); got != "rtl/a.sv UNUSED, rtl/a.sv WIDTH, rtl/b.sv WIDTH" {
+		t.Errorf("order: %s", got)
+	}
+	b.Apply(r)
+	if got := suppressed(r); got != "sss" {
+		t.Errorf("loaded baseline: got %s", got)
+	}
+
+	for name, data := range map[string]string{
+		"version": `{"version": 2, "warnings": []}`,
+		"json":    `{"version": 1,`,
+	} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadBaseline(path); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package lint runs verilator --lint-only and reports its diagnostics as text, JSON or SARIF,
+// minus the known warnings listed in a baseline file.
+package lint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"ssv/go/services/tasks/project"
+	"ssv/go/services/tasks/verilator"
+	"strings"
+)
+
+// Options configure [Run].
+type Options struct {
+	Version  string                // verilator version, overrides the manifest's pin
+	Manifest *project.Manifest     // lint its sources, nil to lint only Args
+	Dir      string                // dir verilator runs in without a manifest
+	Args     []string              // extra verilator args, files or flags
+	Wrap     func(*exec.Cmd) error // applied to the verilator command last, e.g. to sandbox it
+}
+
+// Finding is a diagnostic in a lint report.
+type Finding struct {
+	verilator.Diagnostic
+	Fingerprint string `json:"fingerprint"`
+	Suppressed  bool   `json:"suppressed,omitempty"` // listed in the baseline
+}
+
+// Report is the outcome of a lint run.
+type Report struct {
+	Verilator string    `json:"verilator"` // version tag
+	Dir       string    `json:"dir"`       // the dir file paths are relative to
+	Findings  []Finding `json:"diagnostics"`
+}
+
+// Counts returns the number of errors and warnings not suppressed by the baseline, and the
+// number that were.
+func (r *Report) Counts() (errs, warnings, suppressed int) {
+	for _, f := range r.Findings {
+		switch {
+		case f.Suppressed:
+			suppressed++
+		case f.Severity == verilator.SeverityError:
+			errs++
+		case f.Severity == verilator.SeverityWarning:
+			warnings++
+		}
+	}
+	return errs, warnings, suppressed
+}
+
+// Run lints the manifest's sources and Args and returns verilator's diagnostics. Errors in the
+// design are findings; Run only fails when verilator couldn't run or failed without saying why.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	tag, dir := opts.Version, opts.Dir
+	var args []string
+	if m := opts.Manifest; m != nil {
+		if tag == "" {
+			tag = m.Verilator
+		}
+		dir = m.Dir
+		var err error
+		if args, err = m.LintArgs(); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(opts.Args) == 0 {
+			return nil, errors.New("nothing to lint, give files or run in an " + project.ManifestName + " project")
+		}
+		args = []string{"--lint-only", "-Wno-fatal"}
+	}
+	args = append(args, opts.Args...)
+
+	root, _, err := verilator.Resolve(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	tag = filepath.Base(root)
+	cmd, err := verilator.Command(ctx, tag, args...)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	verilator.KillGroup(cmd)
+	if opts.Wrap != nil {
+		if err := opts.Wrap(cmd); err != nil {
+			return nil, err
+		}
+	}
+	runErr := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	report := &Report{Verilator: tag, Dir: dir}
+	if report.Dir == "" {
+		report.Dir = "."
+	}
+	hasErrors := false
+	for _, d := range verilator.ParseDiagnostics(&out) {
+		report.Findings = append(report.Findings, Finding{Diagnostic: d, Fingerprint: fingerprint(d)})
+		hasErrors = hasErrors || d.Severity == verilator.SeverityError
+	}
+	var exitErr *exec.ExitError
+	if runErr != nil && (!errors.As(runErr, &exitErr) || !hasErrors) {
+		return nil, fmt.Errorf("verilator lint failed: %w%s", runErr, tail(out.String()))
+	}
+	return report, nil
+}
+
+// tail returns the last lines of verilator's output for an error message.
+func tail(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return ""
+	}
+	if len(lines) > 10 {
+		lines = lines[len(lines)-10:]
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
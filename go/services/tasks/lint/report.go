@@ -0,0 +1,243 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"ssv/go/services/tasks/verilator"
+	"strings"
+)
+
+// Formats of a report.
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+)
+
+// Write writes the report in format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatText:
+		return r.writeText(w)
+	case FormatJSON:
+		return r.writeJSON(w)
+	case FormatSARIF:
+		return r.writeSARIF(w)
+	}
+	return fmt.Errorf("unknown format %q, expected text, json or sarif", format)
+}
+
+// Summary is a one line count of the findings.
+func (r *Report) Summary() string {
+	errs, warnings, suppressed := r.Counts()
+	s := fmt.Sprintf("%s, %s", plural(errs, "error"), plural(warnings, "warning"))
+	if suppressed > 0 {
+		s += fmt.Sprintf(" (%d suppressed by the baseline)", suppressed)
+	}
+	return s
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// writeText prints the findings the way verilator does, without the suppressed ones.
+func (r *Report) writeText(w io.Writer) error {
+	for _, f := range r.Findings {
+		if f.Suppressed {
+			continue
+		}
+		loc := f.File
+		if f.Line > 0 {
+			loc += fmt.Sprintf(":%d", f.Line)
+			if f.Column > 0 {
+				loc += fmt.Sprintf(":%d", f.Column)
+			}
+		}
+		head := f.Severity
+		if f.Code != "" {
+			head += "-" + f.Code
+		}
+		if loc != "" {
+			fmt.Fprintf(w, "%s: %s: %s\n", loc, head, f.Message)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", head, f.Message)
+		}
+		for _, line := range f.Context {
+			fmt.Fprintln(w, line)
+		}
+	}
+	_, err := fmt.Fprintln(w, r.Summary())
+	return err
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	errs, warnings, suppressed := r.Counts()
+	out := struct {
+		*Report
+		Errors     int `json:"errors"`
+		Warnings   int `json:"warnings"`
+		Suppressed int `json:"suppressed"`
+	}{r, errs, warnings, suppressed}
+	if out.Findings == nil {
+		out.Findings = []Finding{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// SARIF 2.1.0, the subset code scanning tools read.
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifRootID  = "SRCROOT"
+	warningsHelp = "https://verilator.org/guide/latest/warnings.html#cmdoption-arg-"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                   `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLoc `json:"originalUriBaseIds"`
+	Results            []sarifResult               `json:"results"`
+}
+
+type sarifTool struct {
+	Driver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version,omitempty"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	} `json:"driver"`
+}
+
+type sarifRule struct {
+	ID      string       `json:"id"`
+	HelpURI string       `json:"helpUri,omitempty"`
+	Short   sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifArtifactLoc struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID              string             `json:"ruleId"`
+	RuleIndex           int                `json:"ruleIndex"`
+	Level               string             `json:"level"`
+	Message             sarifMessage       `json:"message"`
+	Locations           []sarifLocation    `json:"locations,omitempty"`
+	PartialFingerprints map[string]string  `json:"partialFingerprints"`
+	Suppressions        []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation sarifArtifactLoc `json:"artifactLocation"`
+		Region           *sarifRegion     `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification,omitempty"`
+}
+
+// writeSARIF writes a SARIF log with one rule per warning code. Paths are relative to the
+// SRCROOT base, the dir verilator ran in. Baselined warnings are included as suppressed.
+func (r *Report) writeSARIF(w io.Writer) error {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "Verilator"
+	run.Tool.Driver.Version = strings.TrimPrefix(r.Verilator, "v")
+	run.Tool.Driver.InformationURI = "https://verilator.org"
+	run.Tool.Driver.Rules = []sarifRule{}
+	if dir, err := filepath.Abs(r.Dir); err == nil {
+		run.OriginalURIBaseIDs = map[string]sarifArtifactLoc{sarifRootID: {URI: fileURI(dir) + "/"}}
+	}
+
+	rules := map[string]int{}
+	for _, f := range r.Findings {
+		id := f.Code
+		if id == "" {
+			id = strings.ToUpper(f.Severity) // syntax and other uncoded errors
+		}
+		index, ok := rules[id]
+		if !ok {
+			index = len(run.Tool.Driver.Rules)
+			rules[id] = index
+			rule := sarifRule{ID: id, Short: sarifMessage{Text: "Verilator " + id}}
+			if f.Code != "" {
+				rule.HelpURI = warningsHelp + f.Code
+			}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+		}
+
+		res := sarifResult{
+			RuleID:              id,
+			RuleIndex:           index,
+			Level:               sarifLevel(f.Severity),
+			Message:             sarifMessage{Text: f.Message},
+			PartialFingerprints: map[string]string{"ssvLint/v1": f.Fingerprint},
+		}
+		if f.File != "" {
+			var loc sarifLocation
+			if filepath.IsAbs(f.File) {
+				loc.PhysicalLocation.ArtifactLocation = sarifArtifactLoc{URI: fileURI(f.File)}
+			} else {
+				loc.PhysicalLocation.ArtifactLocation = sarifArtifactLoc{URI: relURI(f.File), URIBaseID: sarifRootID}
+			}
+			if f.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: f.Line, StartColumn: f.Column}
+			}
+			res.Locations = []sarifLocation{loc}
+		}
+		if f.Suppressed {
+			res.Suppressions = []sarifSuppression{{Kind: "external", Justification: "listed in the lint baseline"}}
+		}
+		run.Results = append(run.Results, res)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{run}})
+}
+
+func sarifLevel(severity string) string {
+	switch severity {
+	case verilator.SeverityError:
+		return "error"
+	case verilator.SeverityWarning:
+		return "warning"
+	}
+	return "note"
+}
+
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func relURI(path string) string {
+	return (&url.URL{Path: filepath.ToSlash(filepath.Clean(path))}).String()
+}
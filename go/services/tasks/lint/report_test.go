@@ -0,0 +1,102 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReportText(t *testing.T) {
+	r := report(
+		"warning WIDTH rtl/a.sv:3 width",
+		"warning UNUSED rtl/b.sv:0 unused",
+		"error  :0 no file",
+	)
+	r.Findings[0].Column = 7
+	r.Findings[0].Context = []string{"    3 | assign y = b;"}
+	r.Findings[1].Suppressed = true
+	var buf bytes.Buffer
+	if err := r.Write(&buf, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	want := "rtl/a.sv:3:7: warning-WIDTH: width\n" +
+		"    3 | assign y = b;\n" +
+		"error: no file\n" +
+		"1 error, 1 warning (1 suppressed by the baseline)\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if err := r.Write(&buf, "xml"); err == nil {
+		t.Error("unknown format: no error")
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&Report{Verilator: "v5.030", Dir: "."}).Write(&buf, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	// an empty list, not null, for tools reading it
+	if !strings.Contains(buf.String(), `"diagnostics": []`) {
+		t.Errorf("got %s", buf.String())
+	}
+}
+
+func TestReportSARIF(t *testing.T) {
+	r := report(
+		"warning WIDTH rtl/a.sv:3 width",
+		"warning WIDTH rtl/b.sv:4 width",
+		"error  rtl/c.sv:1 syntax error",
+		"warning DEPRECATED :0 option",
+	)
+	r.Findings[1].Suppressed = true
+	var buf bytes.Buffer
+	if err := r.Write(&buf, FormatSARIF); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("got version %s with %d runs", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	var rules []string
+	for _, rule := range run.Tool.Driver.Rules {
+		rules = append(rules, rule.ID)
+	}
+	if got := strings.Join(rules, " "); got != "WIDTH ERROR DEPRECATED" || run.Tool.Driver.Version != "5.030" {
+		t.Errorf("rules %s of version %s", got, run.Tool.Driver.Version)
+	}
+	if len(run.Results) != 4 {
+		t.Fatalf("got %d results", len(run.Results))
+	}
+	for i, want := range []struct {
+		rule, level, uri string
+		index, line      int
+		suppressed       bool
+	}{
+		{"WIDTH", "warning", "rtl/a.sv", 0, 3, false},
+		{"WIDTH", "warning", "rtl/b.sv", 0, 4, true},
+		{"ERROR", "error", "rtl/c.sv", 1, 1, false},
+		{"DEPRECATED", "warning", "", 2, 0, false},
+	} {
+		res := run.Results[i]
+		uri, line := "", 0
+		if len(res.Locations) > 0 {
+			loc := res.Locations[0].PhysicalLocation
+			uri = loc.ArtifactLocation.URI
+			if loc.Region != nil {
+				line = loc.Region.StartLine
+			}
+		}
+		if res.RuleID != want.rule || res.Level != want.level || res.RuleIndex != want.index || uri != want.uri || line != want.line || (len(res.Suppressions) > 0) != want.suppressed {
+			t.Errorf("result %d: got %+v", i, res)
+		}
+		if res.PartialFingerprints["ssvLint/v1"] != r.Findings[i].Fingerprint {
+			t.Errorf("result %d: fingerprint %v", i, res.PartialFingerprints)
+		}
+	}
+}
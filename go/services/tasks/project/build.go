@@ -0,0 +1,252 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"ssv/go/services/tasks/buildcache"
+	"ssv/go/services/tasks/verilator"
+	"strconv"
+	"strings"
+)
+
+const objDirName = "obj_dir" // under the out dir
+
+// BuildOptions configure [Build].
+type BuildOptions struct {
+	Version string                // verilator version, overrides the manifest's pin
+	Jobs    int                   // overrides the manifest's jobs
+	DryRun  bool                  // print the command instead of running it
+	Cache   *buildcache.Cache     // restore unchanged builds from and store new ones in it, nil disables
+	Wrap    func(*exec.Cmd) error // applied to the verilator command last, e.g. to sandbox it
+}
+
+// VerilatorArgs returns the verilator args building the manifest's model with jobs parallel compiles.
+// They are relative to the project root, verilator runs from there.
+func (m *Manifest) VerilatorArgs(jobs int) ([]string, error) {
+	sources, err := m.SourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	testbench, err := m.TestbenchFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"--cc", "--build", "-j", strconv.Itoa(jobs),
+		"--Mdir", filepath.Join(m.OutDir, objDirName),
+		"--top-module", m.Top,
+	}
+	if len(testbench) > 0 {
+		// -o is relative to the obj dir, put the executable next to it
+		args = append(args, "--exe", "-o", filepath.Join("..", m.Name))
+	}
+	if m.Threads > 0 {
+		args = append(args, "--threads", strconv.Itoa(m.Threads))
+	}
+	switch m.Trace.Format {
+	case "vcd":
+		args = append(args, "--trace")
+	case "fst":
+		args = append(args, "--trace-fst")
+	}
+	if m.Trace.Format != "" {
+		if m.Trace.Depth > 0 {
+			args = append(args, "--trace-depth", strconv.Itoa(m.Trace.Depth))
+		}
+		if m.Trace.Structs {
+			args = append(args, "--trace-structs")
+		}
+	}
+	for _, dir := range m.IncludeDirs {
+		args = append(args, "-I"+dir)
+	}
+	args = append(args, keyValues("-D", m.Defines)...)
+	args = append(args, keyValues("-G", m.Parameters)...)
+	for _, flag := range m.CFlags {
+		args = append(args, "-CFLAGS", flag)
+	}
+	for _, flag := range m.LDFlags {
+		args = append(args, "-LDFLAGS", flag)
+	}
+	for _, f := range m.Filelists {
+		args = append(args, "-f", f)
+	}
+	args = append(args, m.Args...)
+	args = append(args, sources...)
+	return append(args, testbench...), nil
+}
+
+// LintArgs returns the verilator args linting the manifest's sources, relative to the project
+// root like [Manifest.VerilatorArgs]. The testbench isn't linted and warnings aren't fatal, so
+// verilator only fails on errors.
+func (m *Manifest) LintArgs() ([]string, error) {
+	sources, err := m.SourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--lint-only", "-Wno-fatal", "--top-module", m.Top}
+	for _, dir := range m.IncludeDirs {
+		args = append(args, "-I"+dir)
+	}
+	args = append(args, keyValues("-D", m.Defines)...)
+	args = append(args, keyValues("-G", m.Parameters)...)
+	for _, f := range m.Filelists {
+		args = append(args, "-f", f)
+	}
+	args = append(args, m.Args...)
+	return append(args, sources...), nil
+}
+
+// keyValues returns prefix+"key=value" args sorted by key, just prefix+"key" for empty values.
+func keyValues(prefix string, kv map[string]string) []string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	args := make([]string, len(keys))
+	for i, k := range keys {
+		args[i] = prefix + k
+		if kv[k] != "" {
+			args[i] += "=" + kv[k]
+		}
+	}
+	return args
+}
+
+// Product returns the path of the built model: the executable with a testbench, otherwise
+// the model's static library.
+func (m *Manifest) Product() string {
+	if len(m.Testbench) > 0 {
+		return filepath.Join(m.OutPath(), m.Name)
+	}
+	return filepath.Join(m.OutPath(), objDirName, "V"+m.Top+"__ALL.a")
+}
+
+// Build verilates and compiles the manifest's model into its out dir and returns the path of
+// the result, see [Manifest.Product]. With a cache, an unchanged model is restored instead.
+// ccache is used for the C++ compiles when installed.
+func Build(ctx context.Context, m *Manifest, opts BuildOptions, out io.Writer) (string, error) {
+	tag := opts.Version
+	if tag == "" {
+		tag = m.Verilator
+	}
+	root, _, err := verilator.Resolve(ctx, tag)
+	if err != nil {
+		if tag != "" {
+			return "", fmt.Errorf("%w (pinned by %s)", err, filepath.Join(m.Dir, ManifestName))
+		}
+		return "", err
+	}
+	tag = filepath.Base(root)
+	jobs := opts.Jobs
+	if jobs == 0 {
+		jobs = m.Jobs
+	}
+	args, err := m.VerilatorArgs(verilator.Threads(jobs))
+	if err != nil {
+		return "", err
+	}
+	cmd, err := verilator.Command(ctx, tag, args...)
+	if err != nil {
+		return "", err
+	}
+	cmd.Dir = m.Dir
+	if _, err := exec.LookPath("ccache"); err == nil && os.Getenv("OBJCACHE") == "" {
+		// relative paths in ccache's hashes, so copies of a project share compiles too
+		cmd.Env = append(cmd.Env, "OBJCACHE=ccache", "CCACHE_BASEDIR="+m.Dir)
+	}
+
+	var key string
+	if opts.Cache != nil {
+		if key, err = m.cacheKey(ctx, tag); err != nil {
+			return "", fmt.Errorf("failed to compute the build cache key: %w", err)
+		}
+	}
+	if opts.DryRun {
+		if key != "" {
+			fmt.Fprintf(out, "# build cache key %s\n", key)
+		}
+		fmt.Fprintf(out, "cd %s && %s\n", shellQuote(m.Dir), shellJoin(cmd.Args))
+		return m.Product(), nil
+	}
+
+	if err := os.MkdirAll(m.OutPath(), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create output dir: %w", err)
+	}
+	// sandboxed build code can replace the out dir or its parents with symlinks, only cache
+	// the build if they still lead where they did
+	outDir, err := filepath.EvalSymlinks(m.OutPath())
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output dir: %w", err)
+	}
+	if key != "" {
+		hit, err := opts.Cache.Get(key, m.artifacts())
+		if err != nil {
+			fmt.Fprintf(out, "⚠ build cache: %s, building\n", err)
+		} else if hit {
+			fmt.Fprintf(out, "Restored %s from the build cache (%s).\n", m.Name, key[:12])
+			return m.Product(), nil
+		}
+	}
+	cmd.Stdout, cmd.Stderr = out, out
+	verilator.KillGroup(cmd)
+	if opts.Wrap != nil {
+		if err := opts.Wrap(cmd); err != nil {
+			return "", err
+		}
+	}
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("verilator build of %s failed: %w", m.Name, err)
+	}
+	if key != "" {
+		if dir, err := filepath.EvalSymlinks(m.OutPath()); err != nil || dir != outDir {
+			fmt.Fprintf(out, "⚠ not storing the build in the cache, %s was replaced\n", m.OutPath())
+		} else if err := m.store(opts.Cache, key, out); err != nil {
+			fmt.Fprintf(out, "⚠ failed to store the build in the cache: %s\n", err)
+		}
+	}
+	return m.Product(), nil
+}
+
+// store puts the build in the cache with the files it read, see [Manifest.deps].
+func (m *Manifest) store(cache *buildcache.Cache, key string, out io.Writer) error {
+	a := m.artifacts()
+	deps, err := m.deps()
+	if err != nil {
+		fmt.Fprintf(out, "⚠ build cache: %s, it won't notice changes to headers the key doesn't hash\n", err)
+	}
+	a.Deps = deps
+	return cache.Put(key, m.Name, a)
+}
+
+// artifacts returns the build outputs kept in the build cache.
+func (m *Manifest) artifacts() buildcache.Artifacts {
+	a := buildcache.Artifacts{ObjDir: filepath.Join(m.OutPath(), objDirName), Root: m.Dir}
+	if len(m.Testbench) > 0 {
+		a.Binary = m.Product()
+	}
+	return a
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=+:,@%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,310 @@
+package project
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"ssv/go/services/tasks/buildcache"
+	"strings"
+)
+
+// buildEnvVars are the environment variables verilated.mk reads that change the compiled model.
+var buildEnvVars = []string{"CXX", "CXXFLAGS", "CPPFLAGS", "LDFLAGS", "LDLIBS", "OPT", "OPT_FAST", "OPT_SLOW", "OPT_GLOBAL"}
+
+// headerExts are the files hashed from the dirs of the sources and testbench, since `include
+// and #include find them there without them being listed.
+var headerExts = []string{".v", ".sv", ".vh", ".svh", ".h", ".hh", ".hpp"}
+
+var fileListComment = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*|#[^\n]*`)
+
+// cacheKey returns the build cache key of the manifest's model built by verilator tag: a hash of
+// the verilator args, every input file, the compiler version and the relevant environment.
+// Paths are hashed relative to the project root, so copies of a project share their builds.
+func (m *Manifest) cacheKey(ctx context.Context, tag string) (string, error) {
+	key := buildcache.NewKey()
+	key.String("verilator", tag)
+	cxx := os.Getenv("CXX")
+	if cxx == "" {
+		cxx = "g++"
+	}
+	if fields := strings.Fields(cxx); len(fields) > 0 {
+		out, _ := exec.CommandContext(ctx, fields[len(fields)-1], "--version").Output()
+		key.String("compiler", string(out))
+	}
+	for _, name := range buildEnvVars {
+		key.String("env "+name, os.Getenv(name))
+	}
+
+	args, err := m.VerilatorArgs(0) // parallelism doesn't change the output
+	if err != nil {
+		return "", err
+	}
+	for _, arg := range args {
+		key.String("arg", arg)
+	}
+
+	files, err := m.inputFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if err := key.File(f, m.path(f)); err != nil {
+			return "", err
+		}
+	}
+	return key.Sum(), nil
+}
+
+// inputFiles returns the files a build may read, relative to the project root and sorted: the
+// sources, testbench, filelists and the files they and the args list, the files in the include
+// and library dirs, the manifest's, the args' and the C compiler's, and the headers next to the
+// sources and testbench.
+func (m *Manifest) inputFiles() ([]string, error) {
+	sources, err := m.SourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	testbench, err := m.TestbenchFiles()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	dirs := map[string][]string{} // dir -> extensions to hash, nil for all files
+	for _, f := range append(sources, testbench...) {
+		files[f] = true
+		addDir(dirs, filepath.Dir(f), headerExts)
+	}
+	for _, dir := range m.IncludeDirs {
+		addDir(dirs, filepath.Clean(dir), nil)
+	}
+	seen := map[string]bool{}
+	for _, f := range m.Filelists {
+		if err := m.readFilelist(filepath.Clean(f), false, files, dirs, seen); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.addArgs(m.Args, ".", files, dirs, seen); err != nil {
+		return nil, err
+	}
+	for _, flag := range m.CFlags {
+		m.addCFlags(flag, dirs)
+	}
+
+	for dir, exts := range dirs {
+		entries, err := os.ReadDir(m.path(dir))
+		if err != nil {
+			continue // a missing include dir is verilator's problem
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() && (exts == nil || slices.Contains(exts, filepath.Ext(e.Name()))) {
+				files[filepath.Join(dir, e.Name())] = true
+			}
+		}
+	}
+	list := make([]string, 0, len(files))
+	for f := range files {
+		list = append(list, f)
+	}
+	slices.Sort(list)
+	return list, nil
+}
+
+// readFilelist adds a .f file and what it lists to files and dirs, see [Manifest.addArgs]. The
+// paths in it are relative to its own dir if it's read with -F (relative), to the project root
+// with -f.
+func (m *Manifest) readFilelist(path string, relative bool, files map[string]bool, dirs map[string][]string, seen map[string]bool) error {
+	if seen[path] {
+		return nil
+	}
+	seen[path] = true
+	data, err := os.ReadFile(m.path(path))
+	if err != nil {
+		return err
+	}
+	files[path] = true
+	var tokens []string
+	sc := bufio.NewScanner(strings.NewReader(fileListComment.ReplaceAllString(string(data), " ")))
+	sc.Split(bufio.ScanWords)
+	for sc.Scan() {
+		tokens = append(tokens, sc.Text())
+	}
+	base := "."
+	if relative {
+		base = filepath.Dir(path)
+	}
+	return m.addArgs(tokens, base, files, dirs, seen)
+}
+
+// addArgs adds the files and dirs verilator args read to files and dirs. Relative paths are
+// relative to base, itself relative to the project root. Tokens that aren't existing files are
+// taken to be flags or flag values and skipped.
+func (m *Manifest) addArgs(tokens []string, base string, files map[string]bool, dirs map[string][]string, seen map[string]bool) error {
+	join := func(p string) string {
+		if filepath.IsAbs(p) {
+			return filepath.Clean(p)
+		}
+		return filepath.Join(base, p)
+	}
+	isFile := func(p string) bool {
+		fi, err := os.Stat(m.path(p))
+		return err == nil && fi.Mode().IsRegular()
+	}
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		switch {
+		case (tok == "-f" || tok == "-F") && next != "":
+			i++
+			if err := m.readFilelist(join(next), tok == "-F", files, dirs, seen); err != nil {
+				return err
+			}
+		case tok == "-y" && next != "":
+			i++
+			addDir(dirs, join(next), nil)
+		case tok == "-v" && next != "":
+			i++
+			if isFile(join(next)) {
+				files[join(next)] = true
+			}
+		case tok == "-CFLAGS" && next != "":
+			i++
+			m.addCFlags(next, dirs)
+		case strings.HasPrefix(tok, "+incdir+"):
+			for _, dir := range strings.Split(strings.TrimPrefix(tok, "+incdir+"), "+") {
+				if dir != "" {
+					addDir(dirs, join(dir), nil)
+				}
+			}
+		case strings.HasPrefix(tok, "-I") && len(tok) > 2:
+			addDir(dirs, join(tok[2:]), nil)
+		case !strings.HasPrefix(tok, "-") && !strings.HasPrefix(tok, "+") && isFile(join(tok)):
+			files[join(tok)] = true
+			addDir(dirs, filepath.Dir(join(tok)), headerExts)
+		}
+	}
+	return nil
+}
+
+// addCFlags adds the include dirs of a -CFLAGS value to dirs. The compiler runs in the obj dir,
+// so relative dirs are relative to it.
+func (m *Manifest) addCFlags(flag string, dirs map[string][]string) {
+	fields := strings.Fields(flag)
+	for i := 0; i < len(fields); i++ {
+		dir := ""
+		for _, opt := range []string{"-I", "-isystem", "-iquote", "-idirafter"} {
+			if fields[i] == opt && i+1 < len(fields) {
+				i++
+				dir = fields[i]
+				break
+			}
+			if strings.HasPrefix(fields[i], opt) && len(fields[i]) > len(opt) {
+				dir = fields[i][len(opt):]
+				break
+			}
+		}
+		if dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(m.OutDir, objDirName, dir)
+		}
+		addDir(dirs, filepath.Clean(dir), nil)
+	}
+}
+
+// addDir adds a dir whose files with one of exts (all if nil) are inputs, all files winning.
+func addDir(dirs map[string][]string, dir string, exts []string) {
+	if cur, ok := dirs[dir]; ok && cur == nil {
+		return
+	}
+	dirs[dir] = exts
+}
+
+// path returns p, relative to the project root unless absolute, as an absolute path.
+func (m *Manifest) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.Dir, p)
+}
+
+// deps returns the files the last build read, from the make dependency files verilator and the
+// compiler write to the obj dir. They're relative to the project root if inside it, absolute
+// otherwise. Generated files in the obj dir are left out, they're part of the build.
+func (m *Manifest) deps() ([]string, error) {
+	objDir := filepath.Join(m.OutPath(), objDirName)
+	depFiles, err := filepath.Glob(filepath.Join(objDir, "*.d"))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var deps []string
+	for _, path := range depFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		// verilator runs in the project root, the compiler in the obj dir
+		base := objDir
+		if strings.HasSuffix(path, "__ver.d") {
+			base = m.Dir
+		}
+		for _, dep := range parseDepFile(string(data)) {
+			if !filepath.IsAbs(dep) {
+				dep = filepath.Join(base, dep)
+			}
+			if dep == objDir || strings.HasPrefix(dep, objDir+string(filepath.Separator)) {
+				continue
+			}
+			if rel, err := filepath.Rel(m.Dir, dep); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				dep = rel
+			}
+			if !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+			}
+		}
+	}
+	slices.Sort(deps)
+	return deps, nil
+}
+
+// parseDepFile returns the prerequisites of the rules in a make dependency file, as written by
+// gcc -MMD and verilator: "target ...: prerequisite ...", with backslash continued lines and
+// escaped spaces.
+func parseDepFile(data string) []string {
+	var deps []string
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\\\n", " "), "\n") {
+		_, line, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue // blank lines and -MP's empty rules
+		}
+		var dep strings.Builder
+		for j := 0; j < len(line); j++ {
+			switch c := line[j]; {
+			case (c == '\\' && j+1 < len(line) && line[j+1] == ' ') || (c == '$' && j+1 < len(line) && line[j+1] == '$'):
+				dep.WriteByte(line[j+1])
+				j++
+			case c == ' ' || c == '\t' || c == '\r':
+				if dep.Len() > 0 {
+					deps = append(deps, dep.String())
+					dep.Reset()
+				}
+			default:
+				dep.WriteByte(c)
+			}
+		}
+		if dep.Len() > 0 {
+			deps = append(deps, dep.String())
+		}
+	}
+	return deps
+}
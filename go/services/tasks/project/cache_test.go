@@ -0,0 +1,145 @@
+package project
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInputFiles(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("testdata", "proj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifest{
+		Dir:         dir,
+		OutDir:      DefaultOutDir,
+		Sources:     []string{"rtl/top.sv"},
+		Testbench:   []string{"tb/main.cpp"},
+		Filelists:   []string{"rtl/files.f"},
+		IncludeDirs: []string{"include"},
+		Args:        []string{"-Wno-fatal", "+incdir+argsinc"},
+		CFlags:      []string{"-isystem /nonexistent/include"},
+	}
+	files, err := m.inputFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"argsinc/x.svh",      // +incdir+ in the args
+		"include/common.svh", // include_dirs
+		"rtl/cells/cells.v",  // -v
+		"rtl/defs.svh",       // header next to a source
+		"rtl/files.f",        // filelists
+		"rtl/inc/a.svh",      // +incdir+ with two dirs
+		"rtl/inc2/b.vh",
+		"rtl/ip/cells.v", // -F lists are relative to their dir
+		"rtl/ip/core.sv",
+		"rtl/ip/include/ip.svh",
+		"rtl/ip/ip.f",
+		"rtl/ip/lib/ipmod.v",
+		"rtl/ip/more/more.f", // nested -F
+		"rtl/ip/more/x.sv",
+		"rtl/lib/README", // -y, every file
+		"rtl/lib/mod.v",
+		"rtl/pkg/pkg.sv", // listed in a -f list, relative to the project root
+		"rtl/top.sv",     // sources
+		"tb/main.cpp",    // testbench
+		"tb/notes.md",    // -CFLAGS -I, relative to the obj dir
+		"tb/tb.h",
+	}
+	if !slices.Equal(files, want) {
+		t.Fatalf("got\n%q\nwant\n%q", files, want)
+	}
+
+	m.Filelists = []string{"rtl/missing.f"}
+	if _, err := m.inputFiles(); err == nil {
+		t.Fatal("missing filelist: no error")
+	}
+}
+
+func TestAddCFlags(t *testing.T) {
+	m := &Manifest{Dir: "/p", OutDir: "out"}
+	for _, tc := range []struct {
+		flag string
+		want []string
+	}{
+		{"-O2 -DX=1", nil},
+		{"-Iinc", []string{"out/obj_dir/inc"}},
+		{"-I ../../tb", []string{"tb"}},
+		{"-I/abs -isystem /sys -iquote q -idirafter/after", []string{"/abs", "/after", "/sys", "out/obj_dir/q"}},
+		{"-I", nil},
+	} {
+		dirs := map[string][]string{}
+		m.addCFlags(tc.flag, dirs)
+		if got := slices.Sorted(maps.Keys(dirs)); !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.flag, got, tc.want)
+		}
+	}
+}
+
+func TestParseDepFile(t *testing.T) {
+	for _, tc := range []struct {
+		name, data string
+		want       []string
+	}{
+		{"empty", "", nil},
+		{"one rule", "a.o: a.cpp a.h\n", []string{"a.cpp", "a.h"}},
+		{"continued", "a.o: a.cpp \\\n a.h \\\n  b.h\n", []string{"a.cpp", "a.h", "b.h"}},
+		{"several targets", "a.cpp a.h  : x.sv y.sv \n", []string{"x.sv", "y.sv"}},
+		{"phony rules", "a.o: a.h\n\na.h:\n", []string{"a.h"}},
+		{"escapes", `a.o: my\ file.h cost$$.h`, []string{"my file.h", "cost$.h"}},
+		{"crlf", "a.o: a.h\r\n", []string{"a.h"}},
+	} {
+		if got := parseDepFile(tc.data); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDeps(t *testing.T) {
+	m := &Manifest{Dir: t.TempDir(), OutDir: DefaultOutDir}
+	objDir := filepath.Join(m.OutPath(), objDirName)
+	if err := os.MkdirAll(objDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if deps, err := m.deps(); err != nil || deps != nil {
+		t.Fatalf("no dep files: got %q, %v", deps, err)
+	}
+
+	// verilator's, relative to the project root, and the compiler's, relative to the obj dir
+	fixtures, err := filepath.Glob(filepath.Join("testdata", objDirName, "*.d"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatal("no fixtures", err)
+	}
+	for _, path := range fixtures {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(objDir, filepath.Base(path)), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deps, err := m.deps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/opt/verilator/bin/verilator_bin",
+		"/opt/verilator/share/verilator/include/verilated.h",
+		"/opt/verilator/share/verilator/include/verilatedos.h",
+		"rtl/common/bus.svh",
+		"rtl/include/sub/defs.svh",
+		"rtl/top.sv",
+		"tb/common/util.h",
+		"tb/cost$.h",
+		"tb/main.cpp",
+		"tb/my file.h",
+	}
+	if !slices.Equal(deps, want) {
+		t.Fatalf("got\n%q\nwant\n%q", deps, want)
+	}
+}
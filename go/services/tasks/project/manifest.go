@@ -0,0 +1,273 @@
+// Package project reads ssv.toml project manifests and builds their Verilator models.
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"ssv/go/services/tasks/verilator"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	ManifestName  = "ssv.toml"
+	DefaultOutDir = "build"
+)
+
+// Manifest is an ssv.toml file. Paths are relative to the manifest's dir.
+//
+//	name = "counter"
+//	top = "counter"
+//	verilator = "v5.006"               # pinned version, default the selected one
+//	sources = ["rtl/**/*.sv"]          # globs, ** matches any number of dirs
+//	filelists = ["rtl/files.f"]        # passed with -f
+//	include_dirs = ["rtl/include"]
+//	testbench = ["tb/main.cpp"]        # C++ files, builds an executable
+//	threads = 2                        # verilated model threads
+//	jobs = 0                           # parallel compile jobs, 0 picks them from CPUs and RAM
+//	out_dir = "build"
+//	args = ["-Wno-fatal"]              # extra verilator args
+//	cflags = ["-O2"]
+//	ldflags = []
+//
+//	[defines]                          # -D
+//	WIDTH = "8"
+//
+//	[parameters]                       # -G, top module parameters
+//	DEPTH = "16"
+//
+//	[trace]
+//	format = "fst"                     # vcd or fst, empty disables tracing
+//	depth = 2                          # 0 means all levels
+//	structs = true
+//
+//	[results]                          # run jobs, failures besides %Error, %Fatal and assertions
+//	test = 'RUN (?P<name>\w+)'         # regexp of lines starting a test, default the run is one
+//	pass = ['TEST PASSED']             # regexps, if given a test without a match fails
+//	fail = ['MISMATCH']                # regexps of more failure lines
+//	seed = 'seed=(\d+)'                # regexp whose first group is the seed
+type Manifest struct {
+	Name        string            `toml:"name"`
+	Top         string            `toml:"top"`
+	Verilator   string            `toml:"verilator"`
+	Sources     []string          `toml:"sources"`
+	Filelists   []string          `toml:"filelists"`
+	IncludeDirs []string          `toml:"include_dirs"`
+	Testbench   []string          `toml:"testbench"`
+	Threads     int               `toml:"threads"`
+	Jobs        int               `toml:"jobs"`
+	OutDir      string            `toml:"out_dir"`
+	Args        []string          `toml:"args"`
+	CFlags      []string          `toml:"cflags"`
+	LDFlags     []string          `toml:"ldflags"`
+	Defines     map[string]string `toml:"defines"`
+	Parameters  map[string]string `toml:"parameters"`
+	Trace       Trace             `toml:"trace"`
+	Results     Results           `toml:"results"`
+
+	Dir string `toml:"-"` // dir holding the manifest, the project root
+}
+
+type Trace struct {
+	Format  string `toml:"format"`
+	Depth   int    `toml:"depth"`
+	Structs bool   `toml:"structs"`
+}
+
+// Results are the patterns run jobs detect test results with, see the results package.
+type Results struct {
+	Test string   `toml:"test"`
+	Pass []string `toml:"pass"`
+	Fail []string `toml:"fail"`
+	Seed string   `toml:"seed"`
+}
+
+// Find returns the path of the manifest in dir or its closest parent holding one.
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, ManifestName)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no %s found in this dir or its parents", ManifestName)
+		}
+		dir = parent
+	}
+}
+
+// Load reads and validates a manifest.
+func Load(path string) (*Manifest, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	md, err := toml.DecodeFile(path, &m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return nil, fmt.Errorf("unknown keys in %s: %s", path, strings.Join(keys, ", "))
+	}
+	m.Dir = filepath.Dir(path)
+	if m.OutDir == "" {
+		m.OutDir = DefaultOutDir
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) validate() error {
+	if m.Top == "" {
+		return errors.New("top is required")
+	}
+	if m.Name == "" {
+		m.Name = m.Top
+	}
+	if m.Name == "." || m.Name == ".." || strings.ContainsAny(m.Name, `/\`) {
+		return fmt.Errorf("invalid name %q, it names the built executable", m.Name)
+	}
+	if len(m.Sources) == 0 && len(m.Filelists) == 0 {
+		return errors.New("sources or filelists are required")
+	}
+	if m.Verilator != "" {
+		if err := verilator.ValidateTag(m.Verilator); err != nil {
+			return err
+		}
+	}
+	if m.Threads < 0 || m.Jobs < 0 {
+		return errors.New("threads and jobs can't be negative")
+	}
+	switch m.Trace.Format {
+	case "", "vcd", "fst":
+	default:
+		return fmt.Errorf("unknown trace format %q, expected vcd or fst", m.Trace.Format)
+	}
+	if m.Trace.Depth < 0 {
+		return errors.New("trace depth can't be negative")
+	}
+	for _, pattern := range append([]string{m.Results.Test, m.Results.Seed}, append(m.Results.Pass, m.Results.Fail...)...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid results pattern: %w", err)
+		}
+	}
+	if filepath.IsAbs(m.OutDir) || strings.HasPrefix(filepath.Clean(m.OutDir), "..") {
+		return fmt.Errorf("out_dir %q must be inside the project", m.OutDir)
+	}
+	return nil
+}
+
+// OutPath returns the absolute output dir.
+func (m *Manifest) OutPath() string {
+	return filepath.Join(m.Dir, m.OutDir)
+}
+
+// SourceFiles expands the source globs, relative to the project root, sorted and deduplicated.
+func (m *Manifest) SourceFiles() ([]string, error) {
+	return m.expand(m.Sources)
+}
+
+// TestbenchFiles expands the testbench globs like [Manifest.SourceFiles].
+func (m *Manifest) TestbenchFiles() ([]string, error) {
+	return m.expand(m.Testbench)
+}
+
+func (m *Manifest) expand(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := glob(m.Dir, pattern, m.OutPath())
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%q matches no files", pattern)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return slices.Compact(files), nil
+}
+
+// glob matches pattern against the files under root. Besides the filepath.Match syntax, a
+// "**/" element matches any number of dirs, except hidden ones and skip (the build output).
+func glob(root, pattern, skip string) ([]string, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	if !strings.Contains(pattern, "**") {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for i, match := range matches {
+			matches[i], _ = filepath.Rel(root, match)
+		}
+		return matches, nil
+	}
+	var matches []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == skip || (path != root && strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if ok, err := matchDoubleStar(pattern, filepath.ToSlash(rel)); err != nil || ok {
+			if ok {
+				matches = append(matches, rel)
+			}
+			return err
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// matchDoubleStar matches a slash separated name against a pattern where "**" is a whole
+// element matching zero or more elements.
+func matchDoubleStar(pattern, name string) (bool, error) {
+	pElems, nElems := strings.Split(pattern, "/"), strings.Split(name, "/")
+	var match func(p, n []string) (bool, error)
+	match = func(p, n []string) (bool, error) {
+		for len(p) > 0 {
+			if p[0] == "**" {
+				for i := 0; i <= len(n); i++ {
+					if ok, err := match(p[1:], n[i:]); ok || err != nil {
+						return ok, err
+					}
+				}
+				return false, nil
+			}
+			if len(n) == 0 {
+				return false, nil
+			}
+			if ok, err := filepath.Match(p[0], n[0]); !ok || err != nil {
+				return false, err
+			}
+			p, n = p[1:], n[1:]
+		}
+		return len(n) == 0, nil
+	}
+	return match(pElems, nElems)
+}
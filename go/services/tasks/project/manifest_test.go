@@ -0,0 +1,103 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMatchDoubleStar(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"rtl/top.sv", "rtl/top.sv", true},
+		{"rtl/*.sv", "rtl/top.sv", true},
+		{"rtl/*.sv", "rtl/core/alu.sv", false},
+		{"**/*.sv", "top.sv", true},
+		{"**/*.sv", "rtl/core/alu.sv", true},
+		{"**/*.sv", "rtl/core/alu.svh", false},
+		{"rtl/**/*.sv", "rtl/top.sv", true},
+		{"rtl/**/*.sv", "rtl/core/alu/alu.sv", true},
+		{"rtl/**/*.sv", "tb/rtl/top.sv", false},
+		{"rtl/**", "rtl/core/alu.sv", true},
+		{"rtl/**", "rtl", true},
+		{"**", "a/b/c", true},
+		{"rtl/**/core/*.sv", "rtl/core/alu.sv", true},
+		{"rtl/**/core/*.sv", "rtl/a/b/core/alu.sv", true},
+		{"rtl/**/core/*.sv", "rtl/a/b/alu.sv", false},
+		{"**/core/**/*.sv", "x/core/y/z/alu.sv", true},
+		{"**/core/**/*.sv", "x/y/z/alu.sv", false},
+		// only a whole element is special, elsewhere it's two stars matching within an element
+		{"rtl/**.sv", "rtl/top.sv", true},
+		{"rtl/**.sv", "rtl/core/alu.sv", false},
+		{"rtl/a**", "rtl/ab/c.sv", false},
+		{"rtl/[ab]*.sv", "rtl/alu.sv", true},
+		{"rtl/?.sv", "rtl/ab.sv", false},
+	} {
+		got, err := matchDoubleStar(tc.pattern, tc.name)
+		if err != nil || got != tc.want {
+			t.Errorf("%q against %q: got %v, %v, want %v", tc.pattern, tc.name, got, err, tc.want)
+		}
+	}
+
+	if _, err := matchDoubleStar("**/[a-", "rtl/top.sv"); err == nil {
+		t.Error("bad pattern: no error")
+	}
+}
+
+func TestGlob(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"top.sv",
+		"rtl/core/alu.sv",
+		"rtl/core/alu.svh",
+		"rtl/pkg.sv",
+		"rtl/.git/hooks/x.sv",
+		".hidden/y.sv",
+		"build/gen.sv",
+		"tb/main.cpp",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		// hidden dirs and the out dir are skipped
+		{"**/*.sv", []string{"rtl/core/alu.sv", "rtl/pkg.sv", "top.sv"}},
+		{"rtl/**/*.sv", []string{"rtl/core/alu.sv", "rtl/pkg.sv"}},
+		{"./rtl//*.sv", []string{"rtl/pkg.sv"}},
+		{"tb/*.cpp", []string{"tb/main.cpp"}},
+		{"*.v", nil},
+	} {
+		got, err := glob(root, tc.pattern, filepath.Join(root, "build"))
+		if err != nil {
+			t.Errorf("%q: %s", tc.pattern, err)
+			continue
+		}
+		for i := range got {
+			got[i] = filepath.ToSlash(got[i])
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+
+	m := &Manifest{Dir: root, OutDir: DefaultOutDir}
+	if files, err := m.expand([]string{"rtl/**/*.sv", "**/alu.sv"}); err != nil || len(files) != 2 {
+		t.Errorf("expand: got %v, %v, want 2 files without duplicates", files, err)
+	}
+	if _, err := m.expand([]string{"**/*.v"}); err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Errorf("expand: got %v, want an error for a pattern matching nothing", err)
+	}
+}
@@ -0,0 +1 @@
+// rtl/cells/cells.v
@@ -0,0 +1,12 @@
+// sources, relative to the project root
+/* rtl/notes.txt isn't
+   listed */
+rtl/pkg/pkg.sv
++incdir+rtl/inc+rtl/inc2
+-y rtl/lib
+-v rtl/cells/cells.v
+# paths in it are relative to its dir
+-F rtl/ip/ip.f
+-f rtl/files.f
+-CFLAGS -I../../tb
+missing.sv
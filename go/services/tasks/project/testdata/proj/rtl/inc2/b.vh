@@ -0,0 +1 @@
+// rtl/inc2/b.vh
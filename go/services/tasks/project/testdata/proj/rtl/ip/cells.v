@@ -0,0 +1 @@
+// rtl/ip/cells.v
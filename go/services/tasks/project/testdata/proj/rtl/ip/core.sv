@@ -0,0 +1 @@
+// rtl/ip/core.sv
@@ -0,0 +1,4 @@
+core.sv
++incdir+include
+-y lib -v cells.v
+-F more/more.f
@@ -0,0 +1 @@
+// rtl/ip/lib/ipmod.v
@@ -0,0 +1 @@
+// rtl/ip/more/x.sv
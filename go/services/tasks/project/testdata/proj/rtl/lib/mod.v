@@ -0,0 +1 @@
+// rtl/lib/mod.v
@@ -0,0 +1 @@
+// rtl/pkg/pkg.sv
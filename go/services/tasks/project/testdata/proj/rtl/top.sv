@@ -0,0 +1 @@
+// rtl/top.sv
@@ -0,0 +1 @@
+// tb/main.cpp
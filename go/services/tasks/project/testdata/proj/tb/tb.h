@@ -0,0 +1 @@
+// tb/tb.h
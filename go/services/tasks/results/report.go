@@ -0,0 +1,192 @@
+package results
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats of a report.
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatJUnit = "junit"
+)
+
+// Counts returns the number of tests and of failed ones.
+func (r *Report) Counts() (tests, failed int) {
+	for _, t := range r.Tests {
+		if t.Status == StatusFailed {
+			failed++
+		}
+	}
+	return len(r.Tests), failed
+}
+
+// Failed reports whether a test failed.
+func (r *Report) Failed() bool {
+	_, failed := r.Counts()
+	return failed > 0
+}
+
+// Summary is a one line count of the tests.
+func (r *Report) Summary() string {
+	tests, failed := r.Counts()
+	return fmt.Sprintf("%s, %d failed", plural(tests, "test"), failed)
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// Write writes the report in format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatText:
+		return r.writeText(w)
+	case FormatJSON:
+		return r.writeJSON(w)
+	case FormatJUnit:
+		return r.writeJUnit(w)
+	}
+	return fmt.Errorf("unknown format %q, expected text, json or junit", format)
+}
+
+// writeText lists the failed tests with their excerpts, then the summary.
+func (r *Report) writeText(w io.Writer) error {
+	for _, t := range r.Tests {
+		if t.Failure == nil {
+			continue
+		}
+		fmt.Fprintf(w, "FAILED %s (%s): %s\n", t.Name, t.Failure.Type, t.Failure.Message)
+		if t.Failure.Excerpt != "" {
+			for _, line := range strings.Split(t.Failure.Excerpt, "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+	}
+	seed := ""
+	if r.Seed != "" {
+		seed = ", seed " + r.Seed
+	}
+	_, err := fmt.Fprintf(w, "%s in %.3fs%s\n", r.Summary(), r.Duration, seed)
+	return err
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	tests, failed := r.Counts()
+	out := struct {
+		*Report
+		Total  int `json:"total"`
+		Passed int `json:"passed"`
+		Failed int `json:"failed"`
+	}{r, tests, tests - failed, failed}
+	if out.Tests == nil {
+		out.Tests = []Test{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// JUnit XML, the subset of the schema Jenkins, GitLab and GitHub test reporters read.
+// https://github.com/testmoapp/junitxml
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes one test suite, the run, with a test case per test. Seeds and the exit
+// code are properties.
+func (r *Report) writeJUnit(w io.Writer) error {
+	tests, failed := r.Counts()
+	suite := junitSuite{
+		Name:      r.Suite,
+		Tests:     tests,
+		Failures:  failed,
+		Time:      seconds(r.Duration),
+		Timestamp: r.StartedAt.UTC().Format("2006-01-02T15:04:05"),
+		Cases:     []junitCase{},
+	}
+	if r.Seed != "" {
+		suite.Properties = append(suite.Properties, junitProperty{"seed", r.Seed})
+	}
+	if len(r.Args) > 0 {
+		suite.Properties = append(suite.Properties, junitProperty{"args", strings.Join(r.Args, " ")})
+	}
+	suite.Properties = append(suite.Properties, junitProperty{"exitCode", strconv.Itoa(r.ExitCode)})
+	for _, t := range r.Tests {
+		c := junitCase{Name: t.Name, Classname: r.Suite, Time: seconds(t.Duration)}
+		if t.Seed != "" {
+			c.Properties = []junitProperty{{"seed", t.Seed}}
+		}
+		if t.Failure != nil {
+			c.Failure = &junitFailure{Message: t.Failure.Message, Type: t.Failure.Type, Text: t.Failure.Excerpt}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	out := junitSuites{
+		Name:     r.Suite,
+		Tests:    tests,
+		Failures: failed,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
@@ -0,0 +1,138 @@
+package results
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport() *Report {
+	return &Report{
+		Suite:     "alu",
+		Args:      []string{"+verilator+seed+7", "+trace"},
+		Seed:      "7",
+		ExitCode:  1,
+		StartedAt: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Duration:  1.5,
+		Tests: []Test{
+			{Name: "test_reset", Status: StatusPassed, Duration: 0.25, Seed: "7"},
+			{Name: "test_add", Status: StatusFailed, Duration: 1.25, Seed: "99", Failure: &Failure{
+				Type:    TypePattern,
+				Message: "MISMATCH <a> & b",
+				Line:    9,
+				Excerpt: "[110] add 2 + 3\nMISMATCH <a> & b",
+			}},
+		},
+	}
+}
+
+func TestSummary(t *testing.T) {
+	r := &Report{}
+	for _, tc := range []struct {
+		tests []Test
+		want  string
+	}{
+		{nil, "0 tests, 0 failed"},
+		{[]Test{{Status: StatusPassed}}, "1 test, 0 failed"},
+		{[]Test{{Status: StatusPassed}, {Status: StatusFailed}}, "2 tests, 1 failed"},
+	} {
+		r.Tests = tc.tests
+		if got := r.Summary(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+	if !r.Failed() {
+		t.Error("a failed test: not failed")
+	}
+}
+
+func TestReportText(t *testing.T) {
+	var b strings.Builder
+	if err := testReport().Write(&b, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	want := "FAILED test_add (pattern): MISMATCH <a> & b\n" +
+		"    [110] add 2 + 3\n" +
+		"    MISMATCH <a> & b\n" +
+		"2 tests, 1 failed in 1.500s, seed 7\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := testReport().Write(&b, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Report
+		Total, Passed, Failed int
+	}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Suite != "alu" || got.Total != 2 || got.Passed != 1 || got.Failed != 1 || len(got.Tests) != 2 {
+		t.Errorf("got %+v", got)
+	}
+	if f := got.Tests[1].Failure; f == nil || f.Line != 9 || f.Type != TypePattern {
+		t.Errorf("failure: got %+v", f)
+	}
+
+	// no tests is an empty list, not null
+	b.Reset()
+	if err := (&Report{Suite: "alu"}).Write(&b, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"tests": []`) {
+		t.Errorf("no tests: got %s", b.String())
+	}
+}
+
+func TestReportJUnit(t *testing.T) {
+	var b bytes.Buffer
+	if err := testReport().Write(&b, FormatJUnit); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), xml.Header) {
+		t.Errorf("no xml header: %s", b.String())
+	}
+	var got junitSuites
+	if err := xml.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "alu" || got.Tests != 2 || got.Failures != 1 || got.Time != "1.500" || len(got.Suites) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+	suite := got.Suites[0]
+	if suite.Timestamp != "2026-10-18T12:00:00" || len(suite.Cases) != 2 {
+		t.Errorf("suite: got %+v", suite)
+	}
+	props := map[string]string{}
+	for _, p := range suite.Properties {
+		props[p.Name] = p.Value
+	}
+	if props["seed"] != "7" || props["args"] != "+verilator+seed+7 +trace" || props["exitCode"] != "1" {
+		t.Errorf("properties: got %v", props)
+	}
+	if c := suite.Cases[0]; c.Name != "test_reset" || c.Classname != "alu" || c.Time != "0.250" || c.Failure != nil {
+		t.Errorf("passed case: got %+v", c)
+	}
+	c := suite.Cases[1]
+	if c.Failure == nil || c.Failure.Type != TypePattern || c.Failure.Message != "MISMATCH <a> & b" ||
+		c.Failure.Text != "[110] add 2 + 3\nMISMATCH <a> & b" {
+		t.Errorf("failed case: got %+v", c.Failure)
+	}
+	if len(c.Properties) != 1 || c.Properties[0] != (junitProperty{"seed", "99"}) {
+		t.Errorf("failed case properties: got %v", c.Properties)
+	}
+}
+
+func TestReportUnknownFormat(t *testing.T) {
+	if err := testReport().Write(&bytes.Buffer{}, "html"); err == nil {
+		t.Error("no error")
+	}
+}
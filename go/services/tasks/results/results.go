@@ -0,0 +1,322 @@
+// Package results detects the results of testbench runs in their output: the tests run, which
+// of them failed and why, their durations and seeds. Reports are written as JSON and as JUnit
+// XML for CI dashboards.
+//
+// A failure is a line of verilator's $error or $fatal (%Error, %Fatal), a failed assertion,
+// SystemVerilog's or C's, or one matching a pattern of the project's [results] section. The
+// simulation exiting with a non-zero status fails the last test if no test failed otherwise,
+// not finishing, e.g. timing out, fails the test that was running.
+package results
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Test statuses.
+const (
+	StatusPassed = "passed"
+	StatusFailed = "failed"
+)
+
+// Failure types, besides those of the built-in patterns.
+const (
+	TypePattern = "pattern" // a line matched a fail pattern of the project
+	TypeNoPass  = "no-pass" // no line of the test matched a pass pattern
+	TypeExit    = "exit"    // the simulation exited with a non-zero status or didn't finish
+)
+
+const (
+	contextBefore = 3        // lines of an excerpt before the failing line
+	contextAfter  = 10       // lines of an excerpt after it
+	tailLines     = 20       // of the output, the excerpt of exit failures
+	maxLine       = 64 << 10 // longer lines are split
+	defaultSuite  = "simulation"
+	seedPattern   = `(?i)\bseed\b\W{0,3}(\d+)`
+)
+
+// builtin are the failure patterns tried first, in order.
+var builtin = []struct {
+	typ string
+	re  *regexp.Regexp
+}{
+	// verilator's "Assertion failed in TOP.t", C's "Assertion `x' failed."
+	{"assertion", regexp.MustCompile("(?i)\\bassertion\\s+failed\\b|\\bassertion [`'\"].*['\"] failed\\.?\\s*$")},
+	{"fatal", regexp.MustCompile(`%Fatal\b`)},
+	{"error", regexp.MustCompile(`%Error\b`)},
+}
+
+// seedArg is verilator's runtime seed arg.
+var seedArg = regexp.MustCompile(`^\+verilator\+seed\+(\d+)$`)
+
+// Options configure a [Parser], the patterns are regexps.
+type Options struct {
+	Suite string   // name of the report, e.g. the project's
+	Args  []string // of the simulation, +verilator+seed+N gives the seed
+	Test  string   // lines starting a test, named by the group "name" or the first one, empty makes the run one test
+	Pass  []string // if given a test passes only if one of its lines matches
+	Fail  []string // failure lines besides the built-in ones
+	Seed  string   // lines giving the seed in their first group, empty for "seed=N" and the like, before the first test the run's
+}
+
+// Failure is why a test failed, the first failure of a test only.
+type Failure struct {
+	Type    string `json:"type"`           // assertion, fatal, error or one of the Type constants
+	Message string `json:"message"`        // the failing line
+	Line    int    `json:"line,omitempty"` // of the output, from 1
+	Excerpt string `json:"excerpt"`        // output around the failing line
+}
+
+// Test is the result of one test.
+type Test struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Duration float64  `json:"duration"` // seconds
+	Seed     string   `json:"seed,omitempty"`
+	Failure  *Failure `json:"failure,omitempty"`
+
+	start  time.Time
+	passed bool // a line matched a pass pattern
+}
+
+// Report is the result of a simulation run.
+type Report struct {
+	Suite     string    `json:"suite"`
+	Args      []string  `json:"args,omitempty"`
+	Seed      string    `json:"seed,omitempty"`
+	ExitCode  int       `json:"exitCode"` // -1 if the simulation didn't finish
+	StartedAt time.Time `json:"startedAt"`
+	Duration  float64   `json:"duration"` // seconds
+	Tests     []Test    `json:"tests"`
+}
+
+// Parser reads simulation output written to it and detects the results. It is not safe for
+// concurrent writes, give a command the same Parser as Stdout and Stderr so exec serializes them.
+type Parser struct {
+	test       *regexp.Regexp // nil without a test pattern
+	seed       *regexp.Regexp
+	pass, fail []*regexp.Regexp
+
+	report  Report
+	partial []byte
+	line    int
+	recent  []string // the last tailLines lines
+	excerpt *Failure // still collecting lines after its failing line
+	after   int      // lines it still takes
+}
+
+// NewParser returns a parser for a run starting now.
+func NewParser(opts Options) (*Parser, error) {
+	p := &Parser{report: Report{Suite: opts.Suite, Args: opts.Args, StartedAt: time.Now()}}
+	if p.report.Suite == "" {
+		p.report.Suite = defaultSuite
+	}
+	compile := func(pattern string) (*regexp.Regexp, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid results pattern: %w", err)
+		}
+		return re, nil
+	}
+	var err error
+	if opts.Test != "" {
+		if p.test, err = compile(opts.Test); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Seed == "" {
+		opts.Seed = seedPattern
+	}
+	if p.seed, err = compile(opts.Seed); err != nil {
+		return nil, err
+	}
+	for _, pattern := range opts.Pass {
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		p.pass = append(p.pass, re)
+	}
+	for _, pattern := range opts.Fail {
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		p.fail = append(p.fail, re)
+	}
+	for _, arg := range opts.Args {
+		if m := seedArg.FindStringSubmatch(arg); m != nil {
+			p.report.Seed = m[1]
+		}
+	}
+	return p, nil
+}
+
+// Write parses the complete lines of b, the rest waits for the next write or [Parser.Finish].
+func (p *Parser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.partial = append(p.partial, b...)
+			if len(p.partial) >= maxLine {
+				p.parseLine(string(p.partial))
+				p.partial = p.partial[:0]
+			}
+			break
+		}
+		if len(p.partial) > 0 {
+			p.parseLine(string(append(p.partial, b[:i]...)))
+			p.partial = p.partial[:0]
+		} else {
+			p.parseLine(string(b[:i]))
+		}
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+// current returns the running test, starting one named after the suite if there is none.
+func (p *Parser) current(now time.Time) *Test {
+	if len(p.report.Tests) == 0 {
+		start := now
+		if p.test == nil {
+			start = p.report.StartedAt
+		}
+		p.report.Tests = append(p.report.Tests, Test{Name: p.report.Suite, start: start})
+	}
+	return &p.report.Tests[len(p.report.Tests)-1]
+}
+
+func (p *Parser) parseLine(line string) {
+	now := time.Now()
+	line = strings.TrimSuffix(line, "\r")
+	p.line++
+	if p.excerpt != nil {
+		p.excerpt.Excerpt += "\n" + line
+		if p.after--; p.after == 0 {
+			p.excerpt = nil
+		}
+	}
+
+	if p.test != nil {
+		if m := p.test.FindStringSubmatch(line); m != nil {
+			p.endTest(now)
+			p.report.Tests = append(p.report.Tests, Test{Name: testName(p.test, m), start: now})
+		}
+	}
+	if m := p.seed.FindStringSubmatch(line); len(m) > 1 && m[1] != "" {
+		// seeds printed within a test are its own, others the run's
+		if p.test != nil && len(p.report.Tests) > 0 {
+			p.current(now).Seed = m[1]
+		} else if p.report.Seed == "" {
+			p.report.Seed = m[1]
+		}
+	}
+	if typ := p.failure(line); typ != "" {
+		if t := p.current(now); t.Failure == nil {
+			t.Failure = &Failure{
+				Type:    typ,
+				Message: strings.TrimSpace(line),
+				Line:    p.line,
+				Excerpt: strings.Join(slices.Concat(last(p.recent, contextBefore), []string{line}), "\n"),
+			}
+			p.excerpt, p.after = t.Failure, contextAfter
+		}
+	}
+	for _, re := range p.pass {
+		if re.MatchString(line) {
+			p.current(now).passed = true
+			break
+		}
+	}
+
+	if len(p.recent) == tailLines {
+		p.recent = append(p.recent[:0], p.recent[1:]...)
+	}
+	p.recent = append(p.recent, line)
+}
+
+// failure returns the type of failure line is, or "".
+func (p *Parser) failure(line string) string {
+	for _, b := range builtin {
+		if b.re.MatchString(line) {
+			return b.typ
+		}
+	}
+	for _, re := range p.fail {
+		if re.MatchString(line) {
+			return TypePattern
+		}
+	}
+	return ""
+}
+
+func testName(re *regexp.Regexp, m []string) string {
+	if i := re.SubexpIndex("name"); i > 0 && m[i] != "" {
+		return m[i]
+	}
+	if len(m) > 1 && m[1] != "" {
+		return m[1]
+	}
+	return strings.TrimSpace(m[0])
+}
+
+// endTest sets the duration of the running test, if any.
+func (p *Parser) endTest(now time.Time) {
+	if len(p.report.Tests) > 0 {
+		t := &p.report.Tests[len(p.report.Tests)-1]
+		t.Duration = now.Sub(t.start).Seconds()
+	}
+}
+
+// Finish parses the last partial line and returns the report of a simulation that exited with
+// exitCode, -1 if it didn't finish, e.g. it was killed or timed out.
+func (p *Parser) Finish(exitCode int) *Report {
+	if len(p.partial) > 0 {
+		p.parseLine(string(p.partial))
+		p.partial = nil
+	}
+	now := time.Now()
+	p.current(now)
+	p.endTest(now)
+	r := &p.report
+	r.ExitCode = exitCode
+	r.Duration = now.Sub(r.StartedAt).Seconds()
+
+	tail := strings.Join(p.recent, "\n")
+	if t := &r.Tests[len(r.Tests)-1]; exitCode < 0 && t.Failure == nil {
+		t.Failure = &Failure{Type: TypeExit, Message: "simulation did not finish", Excerpt: tail}
+	}
+	failed := false
+	for i := range r.Tests {
+		t := &r.Tests[i]
+		if t.Failure == nil && len(p.pass) > 0 && !t.passed {
+			t.Failure = &Failure{Type: TypeNoPass, Message: "no output line matched a pass pattern"}
+		}
+		failed = failed || t.Failure != nil
+	}
+	if exitCode > 0 && !failed {
+		msg := fmt.Sprintf("simulation exited with status %d", exitCode)
+		r.Tests[len(r.Tests)-1].Failure = &Failure{Type: TypeExit, Message: msg, Excerpt: tail}
+	}
+	for i := range r.Tests {
+		t := &r.Tests[i]
+		t.Status = StatusPassed
+		if t.Failure != nil {
+			t.Status = StatusFailed
+		}
+		if t.Seed == "" {
+			t.Seed = r.Seed
+		}
+	}
+	return r
+}
+
+func last(lines []string, n int) []string {
+	return lines[max(0, len(lines)-n):]
+}
@@ -0,0 +1,211 @@
+package results
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parse writes output to a new parser in chunks of size bytes, all at once if size is 0.
+func parse(t *testing.T, opts Options, output string, size, exitCode int) *Report {
+	t.Helper()
+	p, err := NewParser(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []byte(output)
+	for len(b) > 0 {
+		n := len(b)
+		if size > 0 {
+			n = min(n, size)
+		}
+		if _, err := p.Write(b[:n]); err != nil {
+			t.Fatal(err)
+		}
+		b = b[n:]
+	}
+	return p.Finish(exitCode)
+}
+
+func TestParserTests(t *testing.T) {
+	output, err := os.ReadFile(filepath.Join("testdata", "tests.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Suite: "alu", Test: `^RUN (?P<name>\w+)`, Pass: []string{"TEST PASSED"}, Fail: []string{"MISMATCH"}}
+	type want struct {
+		name, status, seed, typ, message string
+		line                             int
+	}
+	wants := []want{
+		{"test_reset", StatusPassed, "1234", "", "", 0},
+		// the test's fail pattern matched, passing later doesn't count
+		{"test_add", StatusFailed, "1234", TypePattern, "MISMATCH at addr 3: got 5 expected 6", 9},
+		// a seed printed in a test is the test's
+		{"test_sub", StatusFailed, "99", TypeNoPass, "no output line matched a pass pattern", 0},
+		// the built-in patterns come first, only the first failure is kept
+		{"test_mul", StatusFailed, "1234", "assertion", "%Error: tb.sv:40: Assertion failed in TOP.tb.u_mul: product wrong", 17},
+	}
+	// whole, line by line and byte by byte, with CRLF line endings
+	for _, tc := range []struct {
+		name   string
+		output string
+		size   int
+	}{
+		{"whole", string(output), 0},
+		{"chunks", string(output), 7},
+		{"bytes", string(output), 1},
+		{"crlf", strings.ReplaceAll(string(output), "\n", "\r\n"), 5},
+	} {
+		r := parse(t, opts, tc.output, tc.size, 0)
+		if r.Suite != "alu" || r.Seed != "1234" || r.ExitCode != 0 {
+			t.Errorf("%s: got suite %q, seed %q, exit code %d", tc.name, r.Suite, r.Seed, r.ExitCode)
+		}
+		if len(r.Tests) != len(wants) {
+			t.Errorf("%s: got %d tests, want %d", tc.name, len(r.Tests), len(wants))
+			continue
+		}
+		for i, w := range wants {
+			test := r.Tests[i]
+			got := want{name: test.Name, status: test.Status, seed: test.Seed}
+			if f := test.Failure; f != nil {
+				got.typ, got.message, got.line = f.Type, f.Message, f.Line
+			}
+			if got != w {
+				t.Errorf("%s: test %d: got %+v, want %+v", tc.name, i, got, w)
+			}
+		}
+	}
+
+	// 3 lines before the failing one and up to 10 after, past the end of the test but not past
+	// the next failure
+	r := parse(t, opts, string(output), 0, 0)
+	excerpt := strings.Split(r.Tests[1].Failure.Excerpt, "\n")
+	if len(excerpt) != 12 || excerpt[0] != "RUN test_add" || excerpt[3] != r.Tests[1].Failure.Message ||
+		excerpt[11] != "%Error: tb.sv:40: Assertion failed in TOP.tb.u_mul: product wrong" {
+		t.Errorf("test_add excerpt: got %q", excerpt)
+	}
+	// cut short by the end of the output
+	if excerpt := r.Tests[3].Failure.Excerpt; !strings.HasPrefix(excerpt, "[210]") || !strings.HasSuffix(excerpt, "Verilog $finish") {
+		t.Errorf("test_mul excerpt: got %q", excerpt)
+	}
+}
+
+func TestParserRun(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     Options
+		output   string
+		exitCode int
+		status   string
+		typ      string
+		message  string
+		seed     string
+	}{
+		{"passed", Options{}, "- tb.sv:5: Verilog $finish\n", 0, StatusPassed, "", "", ""},
+		{"no output", Options{}, "", 0, StatusPassed, "", "", ""},
+		{"verilator assertion", Options{}, "[50] %Error: tb.sv:9: Assertion failed in TOP.tb\n", 1, StatusFailed, "assertion", "[50] %Error: tb.sv:9: Assertion failed in TOP.tb", ""},
+		{"c assertion", Options{}, "Vtop: tb/main.cpp:12: int main(): Assertion `top->ok' failed.\n", 134, StatusFailed, "assertion", "Vtop: tb/main.cpp:12: int main(): Assertion `top->ok' failed.", ""},
+		{"fatal", Options{}, "%Fatal: tb.sv:3: timeout\n%Error: tb.sv:3: Verilog $stop\n", 1, StatusFailed, "fatal", "%Fatal: tb.sv:3: timeout", ""},
+		{"error", Options{}, "%Error: tb.sv:3: Verilog $stop\n", 0, StatusFailed, "error", "%Error: tb.sv:3: Verilog $stop", ""},
+		{"fail pattern", Options{Fail: []string{`^\*\*\* FAIL`}}, "*** FAIL: bad crc", 0, StatusFailed, TypePattern, "*** FAIL: bad crc", ""},
+		{"no pass", Options{Pass: []string{"ALL OK"}}, "done\n", 0, StatusFailed, TypeNoPass, "no output line matched a pass pattern", ""},
+		{"pass", Options{Pass: []string{"ALL OK"}}, "ALL OK\n", 0, StatusPassed, "", "", ""},
+		{"exit status", Options{}, "done\n", 3, StatusFailed, TypeExit, "simulation exited with status 3", ""},
+		{"killed", Options{}, "running\n", -1, StatusFailed, TypeExit, "simulation did not finish", ""},
+		// a run that didn't finish didn't pass either
+		{"killed after passing", Options{Pass: []string{"ALL OK"}}, "ALL OK\n", -1, StatusFailed, TypeExit, "simulation did not finish", ""},
+		// a reported failure explains the exit status
+		{"failure and exit status", Options{}, "%Error: x\n", 1, StatusFailed, "error", "%Error: x", ""},
+		{"seed output", Options{}, "Seed: 42\n", 0, StatusPassed, "", "", "42"},
+		{"seed arg", Options{Args: []string{"+trace", "+verilator+seed+7"}}, "", 0, StatusPassed, "", "", "7"},
+		{"seed pattern", Options{Seed: `random init (\d+)`}, "seed=1\nrandom init 5\n", 0, StatusPassed, "", "", "5"},
+		{"first seed", Options{}, "seed=1\nseed=2\n", 0, StatusPassed, "", "", "1"},
+	} {
+		r := parse(t, tc.opts, tc.output, 0, tc.exitCode)
+		if len(r.Tests) != 1 {
+			t.Errorf("%s: got %d tests, want the run as one", tc.name, len(r.Tests))
+			continue
+		}
+		test := r.Tests[0]
+		var typ, message string
+		if test.Failure != nil {
+			typ, message = test.Failure.Type, test.Failure.Message
+		}
+		if test.Name != defaultSuite || test.Status != tc.status || typ != tc.typ || message != tc.message {
+			t.Errorf("%s: got %q %s %q %q, want %s %q %q", tc.name, test.Name, test.Status, typ, message, tc.status, tc.typ, tc.message)
+		}
+		if r.Seed != tc.seed || test.Seed != tc.seed {
+			t.Errorf("%s: got seeds %q and %q, want %q", tc.name, r.Seed, test.Seed, tc.seed)
+		}
+	}
+}
+
+func TestParserExcerpts(t *testing.T) {
+	var b strings.Builder
+	for i := range 30 {
+		b.WriteString(strings.Repeat("x", i) + "\n")
+	}
+	output := b.String()
+
+	// exit failures get the tail of the output
+	r := parse(t, Options{}, output, 0, -1)
+	lines := strings.Split(r.Tests[0].Failure.Excerpt, "\n")
+	if len(lines) != tailLines || lines[0] != strings.Repeat("x", 10) || lines[tailLines-1] != strings.Repeat("x", 29) {
+		t.Errorf("exit: got %q, want the last %d lines", lines, tailLines)
+	}
+
+	// others the lines around the failing one
+	r = parse(t, Options{Fail: []string{"^x{5}$"}}, output, 0, 0)
+	lines = strings.Split(r.Tests[0].Failure.Excerpt, "\n")
+	if len(lines) != contextBefore+1+contextAfter || lines[0] != "xx" || lines[len(lines)-1] != strings.Repeat("x", 15) {
+		t.Errorf("pattern: got %q", lines)
+	}
+}
+
+func TestParserLongLines(t *testing.T) {
+	// a line without a newline is parsed in parts, a failure past the first part still counts
+	long := strings.Repeat("x", maxLine) + "%Error: late\n"
+	r := parse(t, Options{}, long, 4096, 0)
+	if f := r.Tests[0].Failure; f == nil || f.Type != "error" || f.Message != "%Error: late" || f.Line != 2 {
+		t.Errorf("got %+v", f)
+	}
+}
+
+func TestParserNames(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, output string
+		want            []string
+	}{
+		{`^RUN (?P<name>\w+)`, "RUN a\nRUN b\n", []string{"a", "b"}},
+		// without a name group, the first group
+		{`^\[(\w+)\] start`, "[t1] start\n[t2] start\n", []string{"t1", "t2"}},
+		// without groups, the whole match
+		{`^  test_\w+  `, "  test_one  \n", []string{"test_one"}},
+		// output before the first test isn't a test, no test at all makes the run one
+		{`^RUN (\w+)`, "hello\n", []string{"alu"}},
+	} {
+		r := parse(t, Options{Suite: "alu", Test: tc.pattern}, tc.output, 0, 0)
+		var got []string
+		for _, test := range r.Tests {
+			got = append(got, test.Name)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%q: got %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestNewParserRejects(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"test": {Test: "("},
+		"pass": {Pass: []string{"ok", "["}},
+		"fail": {Fail: []string{"*"}},
+		"seed": {Seed: "(?<"},
+	} {
+		if _, err := NewParser(opts); err == nil || !strings.Contains(err.Error(), "invalid results pattern") {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
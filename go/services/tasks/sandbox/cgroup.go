@@ -0,0 +1,120 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cgroupRoot   = "/sys/fs/cgroup"
+	cgroupParent = "ssv-jobs" // under the user's systemd instance, holds the sandboxes' leaves
+)
+
+// cgroup is the leaf cgroup of a sandbox.
+type cgroup struct {
+	path   string
+	dir    *os.File // for clone3's CLONE_INTO_CGROUP
+	memory bool     // memory.max is set
+}
+
+// CgroupParent returns the cgroup sandboxes get their leaves under: ssv-jobs in the user's
+// systemd instance, user@UID.service, whose subtree is delegated to the user. It fails when
+// the daemon doesn't run in that subtree, as processes can only be moved within it then.
+func CgroupParent() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	var own string
+	for _, line := range strings.Split(string(data), "\n") {
+		if p, ok := strings.CutPrefix(line, "0::"); ok {
+			own = p
+		}
+	}
+	if own == "" {
+		return "", errors.New("cgroup v2 is not mounted")
+	}
+	uid := os.Getuid()
+	user := fmt.Sprintf("/user.slice/user-%d.slice/user@%d.service", uid, uid)
+	if !strings.HasPrefix(own, user+"/") {
+		return "", fmt.Errorf("the daemon runs in cgroup %s, not in the user's systemd instance", own)
+	}
+	return filepath.Join(cgroupRoot, user, cgroupParent), nil
+}
+
+// newCgroup creates the leaf cgroup name with the memory and process limits of l. Limits whose
+// controller isn't delegated are left to the rlimits.
+func newCgroup(name string, l Limits) (*cgroup, error) {
+	if name == "" {
+		return nil, errors.New("unnamed sandbox")
+	}
+	parent, err := CgroupParent()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(parent, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	available, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+	var enable []string
+	for _, c := range strings.Fields(string(available)) {
+		if c == "memory" || c == "pids" {
+			enable = append(enable, "+"+c)
+		}
+	}
+	if len(enable) > 0 {
+		if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0); err != nil {
+			return nil, fmt.Errorf("failed to enable cgroup controllers: %w", err)
+		}
+	}
+
+	c := &cgroup{path: filepath.Join(parent, name)}
+	c.remove() // left by a daemon that crashed
+	if err := os.Mkdir(c.path, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	write := func(file string, value int64) error {
+		return os.WriteFile(filepath.Join(c.path, file), []byte(strconv.FormatInt(value, 10)), 0)
+	}
+	if l.Memory > 0 && write("memory.max", l.Memory) == nil {
+		c.memory = true
+		write("memory.oom.group", 1) // an OOM kills the whole job, not some compiler
+	}
+	if l.Procs > 0 {
+		write("pids.max", int64(l.Procs))
+	}
+	if c.dir, err = os.Open(c.path); err != nil {
+		c.remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+// remove kills the processes left in the cgroup and removes it.
+func (c *cgroup) remove() {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	if _, err := os.Stat(c.path); err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0) // linux 5.14
+	for range 20 {
+		// the kill is asynchronous
+		if err := os.Remove(c.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
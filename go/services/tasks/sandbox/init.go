@@ -0,0 +1,399 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	setupFailed = 125                // exit status when the sandbox couldn't be set up
+	execEnv     = "SSV_SANDBOX_EXEC" // the spec for the second stage, see run
+	initPath    = "/.sandbox-init"   // this executable in the new root
+)
+
+// systemDirs are bound read-only into every sandbox when they exist. Symlinks among them,
+// like /bin on merged /usr systems, are copied.
+var systemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/etc", "/opt", "/nix"}
+
+// devices are bound from the host's /dev.
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// Main turns the process into the init of a sandbox when it was started by [Sandbox.Wrap], it
+// exits with the command's status. Otherwise it returns right away. It must run first in main,
+// before the database is opened.
+func Main() {
+	if data, ok := os.LookupEnv(execEnv); ok {
+		os.Unsetenv(execEnv)
+		var sp spec
+		err := json.Unmarshal([]byte(data), &sp)
+		if err == nil {
+			err = execCommand(&sp) // returns only on failure
+		}
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		os.Exit(127)
+	}
+	data, ok := os.LookupEnv(specEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(specEnv)
+	if err := closeInherited(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		os.Exit(setupFailed)
+	}
+	var sp spec
+	if err := json.Unmarshal([]byte(data), &sp); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: invalid spec: %s\n", err)
+		os.Exit(setupFailed)
+	}
+	if err := setup(&sp); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		os.Exit(setupFailed)
+	}
+	if sp.Path == "" {
+		os.Exit(0)
+	}
+	os.Exit(run(&sp))
+}
+
+// closeInherited makes sure the command inherits no files but stdio: files of the daemon
+// opened without O_CLOEXEC, like the database's, would be a way out.
+func closeInherited() error {
+	if err := unix.CloseRange(3, math.MaxUint32, unix.CLOSE_RANGE_CLOEXEC); err == nil {
+		return nil
+	}
+	// before linux 5.11
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return fmt.Errorf("failed to list open files: %w", err)
+	}
+	for _, e := range entries {
+		if fd, err := strconv.Atoi(e.Name()); err == nil && fd > 2 {
+			unix.CloseOnExec(fd)
+		}
+	}
+	return nil
+}
+
+// setup builds the new root and applies the limits. It runs as root of the sandbox's user
+// namespace, pid 1 of its PID namespace.
+func setup(sp *spec) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make the mounts private: %w", err)
+	}
+	root := sp.Root
+	if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("failed to mount the new root: %w", err)
+	}
+	for _, dir := range systemDirs {
+		if err := bind(root, dir, true, true); err != nil {
+			return err
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(root, initPath), nil, 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount(exe, filepath.Join(root, initPath), "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed to bind %s: %w", exe, err)
+	}
+	if err := mountDev(root); err != nil {
+		return err
+	}
+	// a /proc of the PID namespace. The kernel refuses when the host's is partly hidden, as in
+	// containers; the host's isn't bound instead, its /proc/<pid>/root would lead out.
+	if err := mountFS(root, "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		os.Remove(filepath.Join(root, "proc"))
+	}
+	if err := mountFS(root, "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return err
+	}
+	if sp.Limits.Network {
+		// resolv.conf points here with systemd-resolved
+		if err := bind(root, "/run/systemd/resolve", true, true); err != nil {
+			return err
+		}
+	} else if err := loopbackUp(); err != nil {
+		return fmt.Errorf("failed to set up the loopback interface: %w", err)
+	}
+	for _, path := range sp.ReadOnly {
+		if err := bind(root, path, true, false); err != nil {
+			return err
+		}
+	}
+	for _, path := range sp.Writable {
+		if err := bind(root, path, false, false); err != nil {
+			return err
+		}
+	}
+
+	if err := unix.Chdir(root); err != nil {
+		return err
+	}
+	// the old root ends up under the new one, detach it from there
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("failed to switch to the new root: %w", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach the old root: %w", err)
+	}
+	if err := unix.Chdir("/"); err != nil {
+		return err
+	}
+	if err := unix.Mount("", "/", "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("failed to make the new root read-only: %w", err)
+	}
+
+	// no setuid binaries
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	return nil
+}
+
+// bind binds path into root at the same path. System paths may not exist and are copied if
+// they're symlinks, others are followed.
+func bind(root, path string, readOnly, system bool) error {
+	var info fs.FileInfo
+	var err error
+	if system {
+		info, err = os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+	} else {
+		info, err = os.Stat(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind %s: %w", path, err)
+	}
+	target := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.IsDir():
+		err = os.MkdirAll(target, 0o755)
+	default:
+		err = os.WriteFile(target, nil, 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	if err := unix.Mount(path, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind %s: %w", path, err)
+	}
+	if readOnly {
+		if err := makeReadOnly(target); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// makeReadOnly makes the mount at target and the ones below it read-only. Kernels before 5.12
+// lack mount_setattr, there only the top mount is remounted, keeping the flags it is locked
+// with.
+func makeReadOnly(target string) error {
+	attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	if err := unix.MountSetattr(unix.AT_FDCWD, target, unix.AT_RECURSIVE, attr); err == nil {
+		return nil
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(target, &st); err != nil {
+		return err
+	}
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+	for stFlag, msFlag := range map[int64]uintptr{
+		unix.ST_NOSUID:     unix.MS_NOSUID,
+		unix.ST_NODEV:      unix.MS_NODEV,
+		unix.ST_NOEXEC:     unix.MS_NOEXEC,
+		unix.ST_NOATIME:    unix.MS_NOATIME,
+		unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+		unix.ST_RELATIME:   unix.MS_RELATIME,
+	} {
+		if int64(st.Flags)&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+	return unix.Mount("", target, "", flags, "")
+}
+
+// mountFS mounts a new filesystem at path in root.
+func mountFS(root, path, fstype string, flags uintptr, data string) error {
+	target := filepath.Join(root, path)
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount(fstype, target, fstype, flags, data); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", path, err)
+	}
+	return nil
+}
+
+// mountDev creates a minimal /dev: the harmless devices, the usual symlinks and a /dev/shm.
+func mountDev(root string) error {
+	if err := mountFS(root, "/dev", "tmpfs", unix.MS_NOSUID|unix.MS_NOEXEC, "mode=0755"); err != nil {
+		return err
+	}
+	dev := filepath.Join(root, "dev")
+	for _, name := range devices {
+		target := filepath.Join(dev, name)
+		if err := os.WriteFile(target, nil, 0o666); err != nil {
+			return err
+		}
+		if err := unix.Mount(filepath.Join("/dev", name), target, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind /dev/%s: %w", name, err)
+		}
+	}
+	for name, link := range map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		if err := os.Symlink(link, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+	return mountFS(root, "/dev/shm", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+}
+
+// loopbackUp brings up lo in the sandbox's network namespace, for testbenches talking to
+// themselves.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// setRlimits applies the limits to this process, the command it execs inherits them. Hard
+// limits are lowered too, so the command can't raise them back.
+func setRlimits(sp *spec) error {
+	set := func(name string, resource int, n uint64) error {
+		var cur unix.Rlimit
+		if err := unix.Getrlimit(resource, &cur); err != nil {
+			return err
+		}
+		n = min(n, cur.Max)
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: n, Max: n}); err != nil {
+			return fmt.Errorf("failed to limit %s: %w", name, err)
+		}
+		return nil
+	}
+	l := sp.Limits
+	if l.CPUTime > 0 {
+		if err := set("cpu time", unix.RLIMIT_CPU, rlimit(l.CPUTime.Seconds())); err != nil {
+			return err
+		}
+	}
+	if l.FileSize > 0 {
+		if err := set("file size", unix.RLIMIT_FSIZE, uint64(l.FileSize)); err != nil {
+			return err
+		}
+	}
+	// counted per user namespace, so only the sandbox's processes
+	if l.Procs > 0 {
+		if err := set("processes", unix.RLIMIT_NPROC, uint64(l.Procs)); err != nil {
+			return err
+		}
+	}
+	if sp.MemoryRlimit {
+		if err := set("memory", unix.RLIMIT_AS, uint64(l.Memory)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rlimit returns n, rounded and capped for a rlimit.
+func rlimit(n float64) uint64 {
+	if n >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return uint64(math.Ceil(n))
+}
+
+// run runs the command in a nested user namespace that maps the daemon's user back, so it has
+// no capabilities and the mounts made by setup are locked. It returns the command's exit
+// status, 128+n when killed by signal n.
+//
+// The command is started through this executable again, see execCommand: the rlimits would
+// choke the Go runtime of this process, which still has to wait for it.
+func run(sp *spec) int {
+	data, err := json.Marshal(sp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		return setupFailed
+	}
+	cmd := &exec.Cmd{Path: initPath, Args: []string{"ssv-sandbox-exec"}, Dir: sp.Dir, Env: append(os.Environ(), execEnv+"="+string(data))}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: sp.UID, HostID: 0, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: sp.GID, HostID: 0, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	// as pid 1 only signals with a handler reach us, pass them on
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		return 127
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	cmd.Wait()
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	switch {
+	case !ok:
+		return cmd.ProcessState.ExitCode()
+	case ws.Signaled():
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// execCommand applies the rlimits and replaces this process with the command.
+func execCommand(sp *spec) error {
+	if err := setRlimits(sp); err != nil {
+		return err
+	}
+	return unix.Exec(sp.Path, sp.Args, os.Environ())
+}
@@ -0,0 +1,294 @@
+//go:build linux
+
+// Package sandbox confines the commands of jobs: they run in unprivileged user, mount, PID and
+// (without network) network namespaces, see the system dirs and the verilator installs
+// read-only, can write only their work dir and a private /tmp, and get rlimits and, when the
+// user's systemd instance allows it, a cgroup v2 leaf with memory and process limits.
+//
+// [Sandbox.Wrap] makes a command start this executable instead, which [Main] turns into the
+// sandbox's init: as root of its own user namespace it builds the new root, then runs the
+// command in a nested user namespace mapped back to the daemon's user, in which those mounts
+// are locked.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ssv/go/services/tasks/buildcache"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+const specEnv = "SSV_SANDBOX" // the spec for Main, unset before the command runs
+
+// Limits are the resource limits of a sandbox, zero means none.
+type Limits struct {
+	CPUTime  time.Duration `json:"cpuTime,omitempty"`  // per process
+	Memory   int64         `json:"memory,omitempty"`   // bytes, of the whole sandbox with a cgroup, else address space per process
+	FileSize int64         `json:"fileSize,omitempty"` // bytes, of any file written
+	Procs    int           `json:"procs,omitempty"`    // processes and threads
+	Network  bool          `json:"network,omitempty"`  // keep the host's network, otherwise there's only a loopback
+}
+
+// Parse sets the limits given in s, space separated key=value pairs, e.g.
+// "cpu=1h memory=8GiB fsize=2GiB procs=512 network=off". 0 removes a limit.
+func (l *Limits) Parse(s string) error {
+	for _, field := range strings.Fields(s) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("invalid limit %q, expected key=value", field)
+		}
+		var err error
+		switch key {
+		case "cpu":
+			l.CPUTime, err = time.ParseDuration(value)
+			if err == nil && l.CPUTime < 0 {
+				err = errors.New("negative duration")
+			}
+		case "memory":
+			l.Memory, err = buildcache.ParseSize(value)
+		case "fsize":
+			l.FileSize, err = buildcache.ParseSize(value)
+		case "procs":
+			l.Procs, err = strconv.Atoi(value)
+			if err == nil && l.Procs < 0 {
+				err = errors.New("negative count")
+			}
+		case "network":
+			switch value {
+			case "on":
+				l.Network = true
+			case "off":
+				l.Network = false
+			default:
+				err = errors.New("expected on or off")
+			}
+		default:
+			return fmt.Errorf("unknown limit %q, expected cpu, memory, fsize, procs or network", key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid limit %q: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// String describes the limits for job logs.
+func (l Limits) String() string {
+	var parts []string
+	if l.CPUTime > 0 {
+		parts = append(parts, fmt.Sprintf("cpu %s per process", l.CPUTime))
+	}
+	if l.Memory > 0 {
+		parts = append(parts, "memory "+buildcache.FormatSize(l.Memory))
+	}
+	if l.FileSize > 0 {
+		parts = append(parts, "files up to "+buildcache.FormatSize(l.FileSize))
+	}
+	if l.Procs > 0 {
+		parts = append(parts, fmt.Sprintf("%d processes", l.Procs))
+	}
+	if !l.Network {
+		parts = append(parts, "no network")
+	}
+	if len(parts) == 0 {
+		return "no limits"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// spec is what [Sandbox.Wrap] passes to [Main].
+type spec struct {
+	Path         string   `json:"path"` // of the command, empty to only check the sandbox can be set up
+	Args         []string `json:"args"`
+	Dir          string   `json:"dir"`
+	UID          int      `json:"uid"` // the daemon's, outside the namespaces
+	GID          int      `json:"gid"`
+	Root         string   `json:"root"` // empty dir the new root is mounted on
+	ReadOnly     []string `json:"readOnly"`
+	Writable     []string `json:"writable"`
+	Limits       Limits   `json:"limits"`
+	MemoryRlimit bool     `json:"memoryRlimit"` // no cgroup limits memory, use RLIMIT_AS
+}
+
+// Options configure a [Sandbox].
+type Options struct {
+	Name     string   // of its cgroup, unique among the running sandboxes
+	Workdir  string   // the only writable dir besides /tmp, also $HOME
+	Root     string   // where the new root is mounted, created if needed, not under Workdir
+	ReadOnly []string // absolute paths visible besides the system dirs, e.g. the verilator installs
+	Limits   Limits
+}
+
+// Sandbox confines commands, see the package doc. The commands of a sandbox share its cgroup.
+type Sandbox struct {
+	spec   spec
+	home   string
+	cgroup *cgroup // nil without one
+}
+
+// New prepares a sandbox, [Sandbox.Close] it once its commands are done.
+func New(ctx context.Context, opts Options) (*Sandbox, error) {
+	for _, path := range opts.ReadOnly {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("sandbox path %q is not absolute", path)
+		}
+	}
+	if opts.Root == "" || isWithin(opts.Root, opts.Workdir) {
+		return nil, fmt.Errorf("sandbox root %q must be outside of the work dir", opts.Root)
+	}
+	if err := os.MkdirAll(opts.Root, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox root: %w", err)
+	}
+	s := &Sandbox{
+		home: opts.Workdir,
+		spec: spec{
+			UID:          os.Getuid(),
+			GID:          os.Getgid(),
+			Root:         opts.Root,
+			ReadOnly:     opts.ReadOnly,
+			Writable:     []string{opts.Workdir},
+			Limits:       opts.Limits,
+			MemoryRlimit: opts.Limits.Memory > 0,
+		},
+	}
+	cg, err := newCgroup(opts.Name, opts.Limits)
+	if err != nil {
+		xlog.Debugf(ctx, "sandbox %s has no cgroup: %s", opts.Name, err)
+	} else {
+		s.cgroup = cg
+		s.spec.MemoryRlimit = opts.Limits.Memory > 0 && !cg.memory
+	}
+	return s, nil
+}
+
+// String describes the sandbox for job logs.
+func (s *Sandbox) String() string {
+	where := "no cgroup"
+	if s.cgroup != nil {
+		where = "cgroup " + s.cgroup.path
+	}
+	return fmt.Sprintf("sandbox: %s; %s", s.spec.Limits, where)
+}
+
+// Wrap makes cmd run in the sandbox. Call it last: cmd's process group and cancel settings are
+// kept, but its path, args and env are replaced by those starting the sandbox.
+func (s *Sandbox) Wrap(cmd *exec.Cmd) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the sandbox executable: %w", err)
+	}
+	sp := s.spec
+	sp.Path, sp.Args, sp.Dir = cmd.Path, cmd.Args, cmd.Dir
+	if sp.Dir == "" {
+		sp.Dir = s.home
+	}
+	data, err := json.Marshal(sp)
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(sandboxEnv(env, s.home), specEnv+"="+string(data))
+	cmd.Path, cmd.Args = exe, []string{"ssv-sandbox"}
+
+	attr := cmd.SysProcAttr
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+		cmd.SysProcAttr = attr
+	}
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if !sp.Limits.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	// root of its user namespace, only for setting up the mounts, see Main
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: sp.UID, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: sp.GID, Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+	if s.cgroup != nil {
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(s.cgroup.dir.Fd())
+	}
+	return nil
+}
+
+// Close kills what is left of the sandbox's processes and removes its cgroup. Without a cgroup
+// the PID namespace took them down with the command already.
+func (s *Sandbox) Close() {
+	if s.cgroup != nil {
+		s.cgroup.remove()
+	}
+}
+
+// sandboxEnv returns env for commands in a sandbox: $HOME is the work dir and $TMPDIR the
+// private /tmp, dirs of the host that aren't visible are dropped.
+func sandboxEnv(env []string, home string) []string {
+	out := make([]string, 0, len(env)+2)
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		switch {
+		case key == "HOME", key == "TMPDIR", key == "XDG_RUNTIME_DIR", strings.HasPrefix(key, "SSV_"):
+			continue
+		}
+		out = append(out, kv)
+	}
+	return append(out, "HOME="+home, "TMPDIR=/tmp")
+}
+
+// isWithin reports whether path is dir or under it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+var probe struct {
+	once sync.Once
+	err  error
+}
+
+// Available returns why sandboxes can't be used here, e.g. unprivileged user namespaces are
+// disabled, or nil if they can. It sets one up the first time, later calls return that result.
+func Available() error {
+	probe.once.Do(func() {
+		dir, err := os.MkdirTemp("", "ssv-sandbox-")
+		if err != nil {
+			probe.err = err
+			return
+		}
+		defer os.RemoveAll(dir)
+		work := filepath.Join(dir, "work")
+		if err := os.Mkdir(work, 0o700); err != nil {
+			probe.err = err
+			return
+		}
+		s, err := New(context.Background(), Options{Workdir: work, Root: filepath.Join(dir, "root")})
+		if err != nil {
+			probe.err = err
+			return
+		}
+		cmd := &exec.Cmd{}
+		if err := s.Wrap(cmd); err != nil {
+			probe.err = err
+			return
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				err = errors.New(strings.TrimPrefix(msg, "sandbox: "))
+			}
+			probe.err = err
+		}
+	})
+	return probe.err
+}
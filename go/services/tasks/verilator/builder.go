@@ -0,0 +1,146 @@
+package verilator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// Build states.
+const (
+	BuildRunning  = "running"
+	BuildDone     = "done"
+	BuildFailed   = "failed"
+	BuildCanceled = "canceled"
+)
+
+// finishedBuildTTL is how long a finished build's status stays queryable.
+const finishedBuildTTL = time.Hour
+
+// BuildStatus is the state of a build run by a [Builder].
+type BuildStatus struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"` // as requested, the installed tag once done
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt,omitzero"`
+	Events    []Event   `json:"events"`
+	Error     string    `json:"error,omitempty"`
+	Log       string    `json:"log,omitempty"` // build.log path, once known
+}
+
+// Builder runs [Install] in the background, for daemon build jobs.
+type Builder struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	builds map[string]*build
+}
+
+type build struct {
+	status BuildStatus
+	cancel context.CancelFunc
+}
+
+// NewBuilder returns a builder whose builds are cancelled when ctx is.
+func NewBuilder(ctx context.Context) *Builder {
+	return &Builder{ctx: ctx, builds: map[string]*build{}}
+}
+
+// Start starts a build and returns its initial status. Progress and Output in opts are ignored.
+func (b *Builder) Start(opts InstallOptions) BuildStatus {
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	ctx, cancel := context.WithCancel(b.ctx)
+	bd := &build{
+		status: BuildStatus{ID: hex.EncodeToString(idBytes), Version: opts.Version, State: BuildRunning, StartedAt: time.Now(), Events: []Event{}},
+		cancel: cancel,
+	}
+	opts.Output = nil
+	opts.Progress = func(ev Event) {
+		b.mu.Lock()
+		bd.status.Events = append(bd.status.Events, ev)
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	b.prune()
+	b.builds[bd.status.ID] = bd
+	st := bd.status
+	b.mu.Unlock()
+
+	xlog.Infof(b.ctx, "verilator build %s of %q started", st.ID, opts.Version)
+	go func() {
+		defer cancel()
+		tag, err := Install(ctx, opts, io.Discard)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		bd.status.EndedAt = time.Now()
+		if tag != "" {
+			bd.status.Version = tag
+		}
+		switch {
+		case err == nil:
+			bd.status.State = BuildDone
+		case ctx.Err() != nil:
+			bd.status.State, bd.status.Error = BuildCanceled, err.Error()
+		default:
+			bd.status.State, bd.status.Error = BuildFailed, err.Error()
+		}
+		if tag := bd.status.Version; ValidateTag(tag) == nil {
+			bd.status.Log, _ = BuildLog(ctx, tag)
+		}
+		xlog.Infof(b.ctx, "verilator build %s %s", bd.status.ID, bd.status.State)
+	}()
+	return st
+}
+
+// Get returns the status of a build.
+func (b *Builder) Get(id string) (BuildStatus, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bd, ok := b.builds[id]
+	if !ok {
+		return BuildStatus{}, false
+	}
+	st := bd.status
+	st.Events = append([]Event(nil), st.Events...)
+	return st, true
+}
+
+// Cancel stops a running build, it returns false if there is no such build.
+func (b *Builder) Cancel(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bd, ok := b.builds[id]
+	if ok {
+		bd.cancel()
+	}
+	return ok
+}
+
+// Active returns the number of running builds.
+func (b *Builder) Active() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, bd := range b.builds {
+		if bd.status.State == BuildRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// prune forgets builds finished more than finishedBuildTTL ago, b.mu must be held.
+func (b *Builder) prune() {
+	for id, bd := range b.builds {
+		if bd.status.State != BuildRunning && time.Since(bd.status.EndedAt) > finishedBuildTTL {
+			delete(b.builds, id)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package verilator
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic severities.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Diagnostic is one warning or error reported by verilator.
+type Diagnostic struct {
+	Severity string   `json:"severity"`
+	Code     string   `json:"code,omitempty"` // e.g. WIDTH, empty for plain errors like syntax errors
+	File     string   `json:"file,omitempty"` // as verilator printed it, relative to its working dir
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Message  string   `json:"message"`
+	Context  []string `json:"context,omitempty"` // the lines following it: notes, source excerpt, hints
+}
+
+// %Warning-UNUSEDSIGNAL: rtl/top.sv:12:5: Signal is not used: 'foo'
+// %Error: rtl/top.sv:3:1: syntax error, unexpected IDENTIFIER
+// %Warning-DEPRECATED: Option --foo is deprecated
+var diagRe = regexp.MustCompile(`^%(Error|Warning|Info)(?:-([A-Za-z0-9_]+))?: (?:([^\s:][^:]*):(\d+):(?:(\d+):)? )?(.*)$`)
+
+// exitingRe matches verilator's closing summary, which isn't a diagnostic of its own.
+var exitingRe = regexp.MustCompile(`^%Error: Exiting due to \d+ (error|warning)`)
+
+// ParseDiagnostics reads verilator's output (stderr, or both streams merged) and returns the
+// diagnostics in it, in order. Lines that don't belong to a diagnostic are skipped.
+func ParseDiagnostics(r io.Reader) []Diagnostic {
+	var diags []Diagnostic
+	var cur *Diagnostic
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if strings.HasPrefix(line, "%") {
+			cur = nil
+			if exitingRe.MatchString(line) {
+				continue
+			}
+			m := diagRe.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			d := Diagnostic{
+				Severity: strings.ToLower(m[1]),
+				Code:     m[2],
+				File:     m[3],
+				Message:  m[6],
+			}
+			d.Line, _ = strconv.Atoi(m[4])
+			d.Column, _ = strconv.Atoi(m[5])
+			diags = append(diags, d)
+			cur = &diags[len(diags)-1]
+			continue
+		}
+		// context lines are indented, anything else is unrelated output
+		if cur != nil && line != "" && (line[0] == ' ' || line[0] == '\t') {
+			cur.Context = append(cur.Context, line)
+		} else if line != "" {
+			cur = nil
+		}
+	}
+	return diags
+}
@@ -0,0 +1,69 @@
+package verilator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "lint.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := ParseDiagnostics(f)
+	want := []Diagnostic{
+		{Severity: SeverityWarning, Code: "UNUSEDSIGNAL", File: "rtl/top.sv", Line: 12, Column: 15, Message: "Signal is not used: 'dbg'", Context: []string{
+			"                                       : ... note: In instance 'top'",
+			"   12 |   logic [7:0] dbg;",
+			"      |               ^~~",
+			"                       ... For warning description see https://verilator.org/warn/UNUSEDSIGNAL?v=5.030",
+			`                       ... Use "/* verilator lint_off UNUSEDSIGNAL */" and lint_on around source to disable this message.`,
+		}},
+		{Severity: SeverityWarning, Code: "WIDTHTRUNC", File: "rtl/alu.sv", Line: 30, Column: 12, Message: "Operator ASSIGNW expects 4 bits on the Assign RHS, but Assign RHS's VARREF 'b' generates 8 bits.", Context: []string{
+			"                                     : ... note: In instance 'top.u_alu'",
+			"   30 |     assign y = b;", // trailing spaces trimmed
+			"      |            ^",
+		}},
+		{Severity: SeverityWarning, Code: "DEPRECATED", Message: "Option --no-threads is deprecated"},
+		{Severity: SeverityWarning, Code: "WIDTH", File: "/home/me/ip lib/x.sv", Line: 3, Message: "Line only, in a path with a space"},
+		{Severity: SeverityError, File: "rtl/fifo.sv", Line: 7, Column: 1, Message: "syntax error, unexpected endmodule", Context: []string{
+			"    7 | endmodule",
+			"      | ^~~~~~~~~",
+		}},
+		{Severity: SeverityError, Code: "NEEDTIMINGOPT", File: "tb/tb.sv", Line: 20, Column: 5, Message: "Use --timing or --no-timing to specify how delays should be handled"},
+		{Severity: SeverityError, Message: "Internal Error: ../V3Ast.cpp:123: broken link"},
+		{Severity: SeverityError, Message: "Cannot find file containing module: 'missing_mod'"},
+		{Severity: SeverityInfo, File: "rtl/top.sv", Line: 1, Column: 1, Message: "informational"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("diagnostic %d:\ngot  %+v\nwant %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseDiagnosticsLines(t *testing.T) {
+	for _, tc := range []struct {
+		name, out string
+		want      int
+	}{
+		{"empty", "", 0},
+		{"no diagnostics", "- V e r i l a t i o n   R e p o r t\nok\n", 0},
+		{"summary only", "%Error: Exiting due to 1 warning(s)\n", 0},
+		{"unknown severity", "%Fatal: x\n%Warning: y\n", 1},
+		{"crlf", "%Warning-WIDTH: a.sv:1:2: w\r\n   1 | x\r\n", 1},
+		// longer than bufio's default token size
+		{"long line", "%Warning-WIDTH: a.sv:1:2: " + strings.Repeat("x", 100<<10) + "\n%Error: b\n", 2},
+	} {
+		if got := ParseDiagnostics(strings.NewReader(tc.out)); len(got) != tc.want {
+			t.Errorf("%s: got %d diagnostics, want %d: %+v", tc.name, len(got), tc.want, got)
+		}
+	}
+}
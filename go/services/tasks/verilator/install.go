@@ -0,0 +1,321 @@
+package verilator
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	RepoURL        = "https://github.com/verilator/verilator"
+	InstallTimeout = time.Hour
+
+	memPerThread = 2 << 30 // ~2 GiB per parallel C++ compile
+	memReserve   = 1 << 30 // left for the rest of the system
+
+	killDelay = 10 * time.Second // between SIGTERM to a cancelled build step and SIGKILL
+)
+
+// Build stages, in order. Extract is skipped for git sources.
+const (
+	StageFetch     = "fetch"
+	StageExtract   = "extract"
+	StageAutoconf  = "autoconf"
+	StageConfigure = "configure"
+	StageMake      = "make"
+	StageVerify    = "verify"
+	StageInstall   = "install"
+)
+
+// Event reports build progress, once when a stage starts and once when it ends.
+type Event struct {
+	Stage    string        `json:"stage"`
+	Index    int           `json:"index"` // 1 based
+	Total    int           `json:"total"`
+	Done     bool          `json:"done"`
+	Duration time.Duration `json:"duration,omitempty"` // when done
+	Err      string        `json:"error,omitempty"`    // when done and failed
+	Time     time.Time     `json:"time"`
+}
+
+// InstallOptions configure [Install].
+type InstallOptions struct {
+	Version string `json:"version"` // release tag or "latest", default [DefaultVersion] (or the tarball's version)
+	Threads int    `json:"threads"` // parallel compile jobs, 0 picks them from the CPU count and RAM, see [Threads]
+	Use     bool   `json:"use"`     // select the version once installed, otherwise only if none is selected
+	Source  string `json:"source"`  // overrides the "verilatorSource" config value, see [parseSource]
+	SHA256  string `json:"sha256"`  // of the source tarball, overrides the one pinned in "verilatorChecksums"
+
+	Progress func(Event) `json:"-"` // optional
+	Output   io.Writer   `json:"-"` // optional, gets the build output on top of build.log
+}
+
+// BuildLog returns the path of the build log of version tag, kept after the build.
+func BuildLog(ctx context.Context, tag string) (string, error) {
+	root, err := VersionsDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, tag+".build.log"), nil
+}
+
+// Install builds a Verilator release from source into its own dir, next to the other installed
+// versions, and returns its tag. Build tools (autoconf, make, a C++ compiler, flex, bison, ...)
+// must already be installed. Cancelling ctx stops the build.
+func Install(ctx context.Context, opts InstallOptions, out io.Writer) (string, error) {
+	root, err := VersionsDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := migrateLegacy(root); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, InstallTimeout)
+	defer cancel()
+
+	src, err := sourceFor(ctx, opts.Source)
+	if err != nil {
+		return "", err
+	}
+	tag := opts.Version
+	_, single := src.(fileSource)
+	if tag == "latest" || (tag == "" && single) {
+		if tag, err = src.Latest(ctx); err != nil {
+			return "", err
+		}
+	} else if tag == "" {
+		tag = DefaultVersion
+	}
+	if err := ValidateTag(tag); err != nil {
+		return "", err
+	}
+
+	if _, err := versionDir(ctx, tag); err == nil {
+		fmt.Fprintf(out, "Verilator %s is already installed.\n", tag)
+		return tag, selectInstalled(ctx, tag, opts.Use)
+	} else if !errors.Is(err, ErrNotInstalled) {
+		return "", err
+	}
+
+	tools := []string{"autoconf", "make", "g++", "flex", "bison", "perl", "python3"}
+	if _, ok := src.(gitSource); ok {
+		tools = append(tools, "git")
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return "", fmt.Errorf("%s is required to build verilator, install it with your package manager", tool)
+		}
+	}
+
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create verilator dir: %w", err)
+	}
+	// one build per version at a time, e.g. the CLI and a daemon job
+	lock, err := os.OpenFile(filepath.Join(root, "."+tag+".lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open build lock: %w", err)
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		return "", fmt.Errorf("verilator %s is already being built", tag)
+	}
+
+	logPath, _ := BuildLog(ctx, tag)
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create build log: %w", err)
+	}
+	defer logFile.Close()
+	var log io.Writer = logFile
+	if opts.Output != nil {
+		log = io.MultiWriter(logFile, opts.Output)
+	}
+
+	// build in a temp dir so a failed or cancelled build never looks installed
+	dir := filepath.Join(root, tag)
+	tmp := filepath.Join(root, "."+tag+".partial")
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", fmt.Errorf("failed to remove previous partial build: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	dl, err := os.MkdirTemp(root, "."+tag+".download-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create download dir: %w", err)
+	}
+	defer os.RemoveAll(dl)
+
+	threads := Threads(opts.Threads)
+	fmt.Fprintf(out, "Building Verilator %s from %s with %d thread(s), log: %s\n", tag, src, threads, logPath)
+	env := buildEnv(tmp, threads)
+	run := func(name string, args ...string) func() error {
+		return func() error { return runStep(ctx, tmp, env, log, name, args...) }
+	}
+
+	var tarball, pin string
+	type stage struct {
+		name string
+		fn   func() error
+	}
+	stages := []stage{
+		{StageFetch, func() (err error) {
+			if tarball, err = src.Fetch(ctx, tag, dl, log); err != nil {
+				return err
+			}
+			pin, err = verifyTarball(ctx, src, tag, tarball, opts.SHA256, log)
+			return err
+		}},
+		{StageExtract, func() error { return extract(ctx, tarball, tmp) }},
+		{StageAutoconf, run("autoconf")},
+		{StageConfigure, run("./configure")},
+		{StageMake, run("make")},
+		{StageVerify, func() error {
+			version, err := BinaryVersion(ctx, tmp)
+			if err != nil {
+				return err
+			}
+			if version != tag {
+				return fmt.Errorf("version mismatch after build: expected %s, got %s", tag, version)
+			}
+			return CheckRoot(tmp)
+		}},
+		{StageInstall, func() error { return os.Rename(tmp, dir) }},
+	}
+	if _, ok := src.(gitSource); ok {
+		stages[0].fn = func() error { _, err := src.Fetch(ctx, tag, tmp, log); return err }
+		stages = append(stages[:1], stages[2:]...)
+	}
+
+	for i, s := range stages {
+		start := time.Now()
+		report(opts.Progress, Event{Stage: s.name, Index: i + 1, Total: len(stages), Time: start})
+		fmt.Fprintf(log, "\n===== %s =====\n%s\n", s.name, start.UTC().Format(time.RFC3339))
+		err := s.fn()
+		if err == nil {
+			err = ctx.Err()
+		}
+		ev := Event{Stage: s.name, Index: i + 1, Total: len(stages), Done: true, Duration: time.Since(start), Time: time.Now()}
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled) {
+				err = fmt.Errorf("cancelled")
+			} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("timed out after %s", InstallTimeout)
+			}
+			ev.Err = err.Error()
+			report(opts.Progress, ev)
+			fmt.Fprintf(log, "%s failed: %s\n", s.name, err)
+			return "", fmt.Errorf("verilator %s %s failed: %w (see %s)", tag, s.name, err, logPath)
+		}
+		report(opts.Progress, ev)
+	}
+	fmt.Fprintf(out, "Verilator %s installed.\n", tag)
+	if pin != "" {
+		if err := pinTarball(ctx, tag, pin); err != nil {
+			return "", err
+		}
+	}
+	return tag, selectInstalled(ctx, tag, opts.Use)
+}
+
+func report(progress func(Event), ev Event) {
+	if progress != nil {
+		progress(ev)
+	}
+}
+
+// buildEnv returns the environment of the build steps, with ccache and mold when available.
+func buildEnv(root string, threads int) []string {
+	cxxFlags := "-O2 -g1 -march=native -mtune=native -pipe -fno-omit-frame-pointer -fno-strict-aliasing"
+	ldFlags := ""
+	if _, err := exec.LookPath("mold"); err == nil {
+		cxxFlags += " -fuse-ld=mold"
+		ldFlags += " -fuse-ld=mold"
+	}
+	env := append(os.Environ(),
+		"VERILATOR_ROOT="+root,
+		fmt.Sprintf("MAKEFLAGS=-j%d", threads),
+		strings.TrimSpace("CXXFLAGS="+os.Getenv("CXXFLAGS")+" "+cxxFlags),
+		strings.TrimSpace("LDFLAGS="+os.Getenv("LDFLAGS")+ldFlags),
+	)
+	if _, err := exec.LookPath("ccache"); err == nil {
+		env = append(env, "CXX=ccache g++", "CCACHE_CPP2=1", "CCACHE_SLOPPINESS=time_macros")
+	} else {
+		env = append(env, "CXX=g++")
+	}
+	return env
+}
+
+// runStep runs a build command in dir. On cancellation its whole process group is stopped,
+// make's children included.
+func runStep(ctx context.Context, dir string, env []string, log io.Writer, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = log, log
+	KillGroup(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", strings.Join(append([]string{name}, args...), " "), err)
+	}
+	return nil
+}
+
+// KillGroup runs cmd in its own process group and makes cancelling its context SIGTERM the
+// whole group, then SIGKILL it after a delay, so make and compiler children don't outlive it.
+func KillGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM) }
+	cmd.WaitDelay = killDelay
+}
+
+// selectInstalled selects tag if use is set or if no version is selected yet.
+func selectInstalled(ctx context.Context, tag string, use bool) error {
+	current, err := Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current == tag || (!use && current != "") {
+		return nil
+	}
+	return Use(ctx, tag)
+}
+
+// Threads returns the number of parallel compile jobs to use: requested if set (at most the
+// CPU count), otherwise as many as CPUs and RAM allow without swapping.
+func Threads(requested int) int {
+	cpus := runtime.NumCPU()
+	if requested > 0 {
+		return min(requested, cpus)
+	}
+	threads := cpus
+	if mem := totalMemory(); mem > 0 {
+		threads = min(threads, max(1, int((mem-memReserve)/memPerThread)))
+	}
+	return max(threads, 1)
+}
+
+// totalMemory returns MemTotal from /proc/meminfo in bytes, 0 if unknown.
+func totalMemory() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var kb int64
+		if _, err := fmt.Sscanf(sc.Text(), "MemTotal: %d kB", &kb); err == nil {
+			return kb << 10
+		}
+	}
+	return 0
+}
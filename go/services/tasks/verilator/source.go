@@ -0,0 +1,368 @@
+package verilator
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ssv/go/database/config"
+	"strings"
+	"syscall"
+)
+
+// DefaultSource is where release tarballs come from when "verilatorSource" isn't set.
+const DefaultSource = RepoURL + "/archive/refs/tags"
+
+// source is where the source tree of a release comes from, see [parseSource].
+type source interface {
+	// Latest returns the newest release tag.
+	Latest(ctx context.Context) (string, error)
+	// Fetch puts the source tarball of tag in dir and returns its path, or for a git source
+	// clones the tree into dir and returns "".
+	Fetch(ctx context.Context, tag, dir string, log io.Writer) (string, error)
+	String() string
+}
+
+// sourceFor returns src, or the "verilatorSource" config value if empty.
+func sourceFor(ctx context.Context, src string) (source, error) {
+	if src == "" {
+		var err error
+		if src, err = config.Get[string](ctx, "verilatorSource"); err != nil {
+			return nil, fmt.Errorf("failed to get verilatorSource from config: %w", err)
+		}
+	}
+	return parseSource(src)
+}
+
+// parseSource parses a source spec:
+//
+//   - empty: the GitHub release tarballs
+//   - git+<url>, or an http(s) URL ending in .git: a git repo, tags are cloned
+//   - http(s) URL: a mirror serving <url>/<tag>.tar.gz
+//   - file:// URL or path of a .tar.gz file: that tarball, for offline installs
+//   - file:// URL or path of a dir: a local mirror holding <dir>/<tag>.tar.gz
+func parseSource(s string) (source, error) {
+	switch {
+	case s == "":
+		return httpSource(DefaultSource), nil
+	case strings.HasPrefix(s, "git+"):
+		return gitSource(strings.TrimPrefix(s, "git+")), nil
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		if strings.HasSuffix(s, ".git") {
+			return gitSource(s), nil
+		}
+		return httpSource(strings.TrimSuffix(s, "/")), nil
+	case strings.HasPrefix(s, "file://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verilator source %q: %w", s, err)
+		}
+		s = u.Path
+	case strings.Contains(s, "://"):
+		return nil, fmt.Errorf("invalid verilator source %q: unsupported scheme", s)
+	}
+	path, err := filepath.Abs(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verilator source %q: %w", s, err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verilator source: %w", err)
+	}
+	if fi.IsDir() {
+		return dirSource(path), nil
+	}
+	return fileSource(path), nil
+}
+
+// httpSource is a URL serving <url>/<tag>.tar.gz, GitHub's tag archives by default.
+type httpSource string
+
+func (s httpSource) Latest(ctx context.Context) (string, error) {
+	if s != DefaultSource {
+		return "", fmt.Errorf("can't list the releases of %s, give a version", string(s))
+	}
+	return gitSource(RepoURL).Latest(ctx)
+}
+
+func (s httpSource) Fetch(ctx context.Context, tag, dir string, log io.Writer) (string, error) {
+	u := string(s) + "/" + tag + ".tar.gz"
+	fmt.Fprintf(log, "GET %s\n", u)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", u, resp.Status)
+	}
+	path := filepath.Join(dir, tag+".tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", u, err)
+	}
+	fmt.Fprintf(log, "%d bytes\n", n)
+	return path, f.Close()
+}
+
+func (s httpSource) String() string { return string(s) }
+
+// gitSource is a git repo with a tag per release.
+type gitSource string
+
+func (s gitSource) Latest(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", "ls-remote", "--tags", "--refs", string(s)).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to list verilator releases in %s: %w", string(s), err)
+	}
+	var latest string
+	for _, line := range strings.Split(string(out), "\n") {
+		_, ref, ok := strings.Cut(line, "refs/tags/")
+		if ok && tagRe.MatchString(ref) && (latest == "" || compareTags(ref, latest) > 0) {
+			latest = ref
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no verilator releases found in %s", string(s))
+	}
+	return latest, nil
+}
+
+func (s gitSource) Fetch(ctx context.Context, tag, dir string, log io.Writer) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "-c", "advice.detachedHead=false", "clone", "--depth", "1", "--branch", tag, string(s), dir)
+	cmd.Stdout, cmd.Stderr = log, log
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git clone of %s %s failed: %w", string(s), tag, err)
+	}
+	return "", nil
+}
+
+func (s gitSource) String() string { return "git+" + string(s) }
+
+// dirSource is a local mirror of the release tarballs, <dir>/<tag>.tar.gz.
+type dirSource string
+
+func (s dirSource) Latest(ctx context.Context) (string, error) {
+	entries, err := os.ReadDir(string(s))
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	for _, e := range entries {
+		tag, ok := strings.CutSuffix(e.Name(), ".tar.gz")
+		if ok && tagRe.MatchString(tag) && (latest == "" || compareTags(tag, latest) > 0) {
+			latest = tag
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no verilator release tarballs found in %s", string(s))
+	}
+	return latest, nil
+}
+
+func (s dirSource) Fetch(ctx context.Context, tag, dir string, log io.Writer) (string, error) {
+	return filepath.Join(string(s), tag+".tar.gz"), nil
+}
+
+func (s dirSource) String() string { return string(s) }
+
+// fileSource is a single release tarball.
+type fileSource string
+
+// Latest returns the version of the tarball, from its top level dir, e.g. verilator-5.006/.
+func (s fileSource) Latest(ctx context.Context) (string, error) {
+	f, err := os.Open(string(s))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", string(s), err)
+	}
+	hdr, err := tar.NewReader(gz).Next()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", string(s), err)
+	}
+	top, _, _ := strings.Cut(strings.TrimPrefix(hdr.Name, "./"), "/")
+	if _, v, ok := strings.Cut(top, "-"); ok && tagRe.MatchString("v"+strings.TrimPrefix(v, "v")) {
+		return "v" + strings.TrimPrefix(v, "v"), nil
+	}
+	return "", fmt.Errorf("can't tell the version of %s, give it", string(s))
+}
+
+func (s fileSource) Fetch(ctx context.Context, tag, dir string, log io.Writer) (string, error) {
+	return string(s), nil
+}
+
+func (s fileSource) String() string { return string(s) }
+
+// verifyTarball checks the sha256 of the tarball of tag at path against want, or if empty the
+// one pinned for tag in the "verilatorChecksums" config value, before it's unpacked and built.
+// Without either only the default source, GitHub over HTTPS, is trusted. It returns the
+// checksum to pin once the build succeeds, empty if tag already has one.
+func verifyTarball(ctx context.Context, src source, tag, path, want string, log io.Writer) (string, error) {
+	pins, err := config.Get[map[string]string](ctx, "verilatorChecksums")
+	if err != nil {
+		return "", fmt.Errorf("failed to get verilatorChecksums from config: %w", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	fmt.Fprintf(log, "sha256 %s\n", sum)
+
+	if want == "" {
+		want = pins[tag]
+	}
+	switch want = strings.ToLower(want); {
+	case want == "" && src != httpSource(DefaultSource):
+		return "", fmt.Errorf("no checksum pinned for verilator %s from %s, its sha256 is %s: check it, then pass it with --sha256", tag, src, sum)
+	case want != "" && want != sum:
+		return "", fmt.Errorf("checksum mismatch for verilator %s from %s: expected %s, got %s", tag, src, want, sum)
+	}
+	if pins[tag] != "" {
+		return "", nil
+	}
+	return sum, nil
+}
+
+// pinTarball records the checksum of the tarball of tag for the next builds.
+func pinTarball(ctx context.Context, tag, sum string) error {
+	pins, err := config.Get[map[string]string](ctx, "verilatorChecksums")
+	if err != nil {
+		return fmt.Errorf("failed to get verilatorChecksums from config: %w", err)
+	}
+	if pins == nil {
+		pins = map[string]string{}
+	}
+	pins[tag] = sum
+	if err := config.Set(ctx, "verilatorChecksums", pins); err != nil {
+		return fmt.Errorf("failed to set verilatorChecksums in config: %w", err)
+	}
+	return nil
+}
+
+// extract unpacks a .tar.gz into dir, dropping the top level dir of every entry.
+func extract(ctx context.Context, tarball, dir string) error {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", tarball, err)
+	}
+	tr := tar.NewReader(gz)
+	root := filepath.Clean(dir) + string(os.PathSeparator) // entries must be under it
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", tarball, err)
+		}
+		_, name, _ := strings.Cut(strings.TrimPrefix(hdr.Name, "./"), "/")
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if !strings.HasPrefix(path, root) {
+			return fmt.Errorf("unsafe path %q in %s", hdr.Name, tarball)
+		}
+		// an earlier entry may have made a parent a symlink, e.g. "a -> ." then "a/b -> ..",
+		// anything written through it could land outside dir
+		if err := noSymlinks(root, filepath.Dir(path)); err != nil {
+			return fmt.Errorf("unsafe path %q in %s: %w", hdr.Name, tarball, err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0o755)
+		case tar.TypeReg:
+			err = writeFile(path, tr, hdr.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			// the target may be dir itself
+			target := filepath.Join(filepath.Dir(path), hdr.Linkname) + string(os.PathSeparator)
+			if filepath.IsAbs(hdr.Linkname) || !strings.HasPrefix(target, root) {
+				return fmt.Errorf("unsafe symlink %q -> %q in %s", hdr.Name, hdr.Linkname, tarball)
+			}
+			if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+				err = os.Symlink(hdr.Linkname, path)
+			}
+		default:
+			continue // pax headers, hard links (none in release tarballs), ...
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+	}
+}
+
+// noSymlinks checks that no existing dir of path below root is a symlink.
+func noSymlinks(root, path string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+	cur := filepath.Clean(root)
+	for _, elem := range strings.Split(rel, string(os.PathSeparator)) {
+		if elem == "." {
+			continue
+		}
+		cur = filepath.Join(cur, elem)
+		fi, err := os.Lstat(cur)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // nor are the dirs below it
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", strings.TrimPrefix(cur, root))
+		}
+	}
+	return nil
+}
+
+// writeFile writes a regular file, never through a symlink at path.
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|syscall.O_NOFOLLOW, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
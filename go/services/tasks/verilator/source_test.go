@@ -0,0 +1,192 @@
+package verilator
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"ssv/go/database"
+	"ssv/go/database/config"
+	"strings"
+	"testing"
+
+	"github.com/Data-Corruption/lmdb-go/wrap"
+)
+
+// entry is a tarball entry, a symlink if link is set, a dir if name ends with /.
+type entry struct {
+	name, link, data string
+}
+
+func writeTarball(t *testing.T, entries ...entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "verilator-5.030.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtract(t *testing.T) {
+	tarball := writeTarball(t,
+		entry{name: "verilator-5.030/"},
+		entry{name: "verilator-5.030/configure.ac", data: "AC_INIT"},
+		entry{name: "verilator-5.030/src/V3Ast.cpp", data: "// ast"},
+		entry{name: "verilator-5.030/include/vltstd", link: "../src"},
+		entry{name: "verilator-5.030/bin/root", link: ".."},
+	)
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := extract(context.Background(), tarball, dir); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"configure.ac":             "AC_INIT",
+		"src/V3Ast.cpp":            "// ast",
+		"include/vltstd/V3Ast.cpp": "// ast",
+	} {
+		if b, err := os.ReadFile(filepath.Join(dir, path)); err != nil || string(b) != want {
+			t.Errorf("%s: got %q, %v, want %q", path, b, err, want)
+		}
+	}
+}
+
+func TestExtractRejects(t *testing.T) {
+	for name, entries := range map[string][]entry{
+		"dotdot":           {{name: "top/../../evil", data: "x"}},
+		"absolute symlink": {{name: "top/l", link: "/etc/passwd"}},
+		"escaping symlink": {{name: "top/l", link: "../.."}},
+		// a prefix match without the separator lets dir-evil through
+		"sibling symlink": {{name: "top/l", link: "../out-evil/x"}},
+		// each link stays inside on its own, writing through them doesn't
+		"symlink chain": {
+			{name: "top/a", link: "."},
+			{name: "top/a/b", link: ".."},
+			{name: "top/b/evil", data: "x"},
+		},
+		"through symlink": {
+			{name: "top/sub/", data: ""},
+			{name: "top/l", link: "sub"},
+			{name: "top/l/x", data: "x"},
+		},
+		"onto symlink": {
+			{name: "top/x", data: "x"},
+			{name: "top/l", link: "x"},
+			{name: "top/l", data: "y"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "out")
+			if err := os.MkdirAll(filepath.Join(parent, "out-evil"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := extract(context.Background(), writeTarball(t, entries...), dir); err == nil {
+				t.Fatal("extracted")
+			}
+			if _, err := os.Stat(filepath.Join(parent, "evil")); err == nil {
+				t.Fatal("wrote outside the extraction dir")
+			}
+		})
+	}
+}
+
+// withConfig returns a context with the config in a temp database.
+func withConfig(t *testing.T) context.Context {
+	t.Helper()
+	db, _, err := wrap.New(t.TempDir(), []string{database.ConfigDBIName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	cfg, err := config.New(config.Version, config.SchemaRecord, config.Migrations, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return config.IntoContext(context.Background(), cfg)
+}
+
+func TestVerifyTarball(t *testing.T) {
+	ctx := withConfig(t)
+	tarball := writeTarball(t, entry{name: "verilator-5.030/configure.ac", data: "AC_INIT"})
+	b, err := os.ReadFile(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(b)
+	sum := hex.EncodeToString(h[:])
+	other := strings.Repeat("0", 64)
+	github, mirror := httpSource(DefaultSource), dirSource(filepath.Dir(tarball))
+
+	for _, tc := range []struct {
+		name   string
+		src    source
+		want   string
+		pin    string // expected to be returned
+		errMsg string
+	}{
+		{"github unpinned", github, "", sum, ""},
+		{"mirror unpinned", mirror, "", "", "no checksum pinned for verilator v5.030 from " + string(mirror) + ", its sha256 is " + sum},
+		{"mirror given", mirror, strings.ToUpper(sum), sum, ""},
+		{"mirror mismatch", mirror, other, "", "checksum mismatch"},
+		{"github mismatch", github, other, "", "checksum mismatch"},
+	} {
+		pin, err := verifyTarball(ctx, tc.src, "v5.030", tarball, tc.want, io.Discard)
+		if tc.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.errMsg)
+			}
+			continue
+		}
+		if err != nil || pin != tc.pin {
+			t.Errorf("%s: got %q, %v, want %q", tc.name, pin, err, tc.pin)
+		}
+	}
+
+	// pinned, every source is checked against it and nothing more is pinned
+	if err := pinTarball(ctx, "v5.030", sum); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []source{github, mirror} {
+		if pin, err := verifyTarball(ctx, src, "v5.030", tarball, "", io.Discard); err != nil || pin != "" {
+			t.Errorf("pinned, from %s: got %q, %v", src, pin, err)
+		}
+	}
+	if err := pinTarball(ctx, "v5.030", other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyTarball(ctx, github, "v5.030", tarball, "", io.Discard); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("other pin: got %v", err)
+	}
+}
@@ -1,37 +1,281 @@
+// Package verilator manages side by side Verilator installs in <data dir>/verilator/<tag>, with
+// a "current" symlink to the selected one, and runs them.
 package verilator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"ssv/go/app"
 	"ssv/go/database/datapath"
+	"strconv"
+	"strings"
 
 	"github.com/Data-Corruption/stdx/xlog"
-	"github.com/urfave/cli/v3"
 )
 
-// getPaths returns the verilator root directory and binary path.
-func getPaths(ctx context.Context) (string, string, error) {
+const (
+	DefaultVersion = "v5.006"
+	currentName    = "current" // symlink to the selected version's dir
+)
+
+// ErrNotInstalled is returned when the requested (or selected) version isn't installed.
+var ErrNotInstalled = errors.New("verilator version not installed")
+
+var tagRe = regexp.MustCompile(`^v(\d+)\.(\d{3})$`)
+
+// ValidateTag checks a release tag, e.g. "v5.006".
+func ValidateTag(tag string) error {
+	if !tagRe.MatchString(tag) {
+		return fmt.Errorf("invalid verilator version %q, expected a release tag like %s", tag, DefaultVersion)
+	}
+	return nil
+}
+
+// compareTags orders release tags, both must be valid.
+func compareTags(a, b string) int {
+	am, bm := tagRe.FindStringSubmatch(a), tagRe.FindStringSubmatch(b)
+	for i := 1; i <= 2; i++ {
+		x, _ := strconv.Atoi(am[i])
+		y, _ := strconv.Atoi(bm[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// VersionsDir returns the dir holding all installed versions.
+func VersionsDir(ctx context.Context) (string, error) {
 	dataPath := datapath.FromContext(ctx)
 	if dataPath == "" {
-		return "", "", fmt.Errorf("data path not set in context")
+		return "", fmt.Errorf("data path not set in context")
 	}
-	verRootDir := filepath.Join(dataPath, "verilator")
-	verBin := filepath.Join(verRootDir, "bin", "verilator")
-	// TODO: check if verilator binary exists and is executable
-	return verRootDir, verBin, nil
+	return filepath.Join(dataPath, "verilator"), nil
 }
 
-func Passthrough(ctx context.Context, cmd *cli.Command) error {
-	verRootDir, verBin, err := getPaths(ctx)
+// Installed returns the installed versions, oldest first.
+func Installed(ctx context.Context) ([]string, error) {
+	root, err := VersionsDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateLegacy(root); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list verilator versions: %w", err)
+	}
+	var tags []string
+	for _, e := range entries {
+		if e.IsDir() && tagRe.MatchString(e.Name()) {
+			tags = append(tags, e.Name())
+		}
+	}
+	slices.SortFunc(tags, compareTags)
+	return tags, nil
+}
+
+// Current returns the selected version, empty if none is.
+func Current(ctx context.Context) (string, error) {
+	root, err := VersionsDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := migrateLegacy(root); err != nil {
+		return "", err
+	}
+	target, err := os.Readlink(filepath.Join(root, currentName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read current verilator version: %w", err)
+	}
+	return filepath.Base(target), nil
+}
+
+// Use selects an installed version.
+func Use(ctx context.Context, tag string) error {
+	root, err := VersionsDir(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := versionDir(ctx, tag); err != nil {
+		return err
+	}
+	// replace the link atomically, readers never see it missing
+	tmp := filepath.Join(root, "."+currentName+".tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(tag, tmp); err != nil {
+		return fmt.Errorf("failed to select verilator %s: %w", tag, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(root, currentName)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to select verilator %s: %w", tag, err)
+	}
+	return nil
+}
+
+// Remove deletes an installed version, clearing the selection if it was the current one.
+func Remove(ctx context.Context, tag string) error {
+	dir, err := versionDir(ctx, tag)
+	if err != nil {
+		return err
+	}
+	current, err := Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current == tag {
+		if err := os.Remove(filepath.Join(filepath.Dir(dir), currentName)); err != nil {
+			return fmt.Errorf("failed to clear current verilator version: %w", err)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove verilator %s: %w", tag, err)
+	}
+	if err := os.Remove(dir + ".build.log"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove verilator %s build log: %w", tag, err)
+	}
+	return nil
+}
+
+// versionDir returns the dir of an installed version.
+func versionDir(ctx context.Context, tag string) (string, error) {
+	if err := ValidateTag(tag); err != nil {
+		return "", err
+	}
+	root, err := VersionsDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := migrateLegacy(root); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, tag)
+	fi, err := os.Stat(filepath.Join(dir, "bin", "verilator"))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNotInstalled, tag)
+	}
+	if fi.Mode()&0o111 == 0 {
+		return "", fmt.Errorf("verilator %s is broken, %s is not executable", tag, filepath.Join(dir, "bin", "verilator"))
+	}
+	return dir, nil
+}
+
+// Resolve returns VERILATOR_ROOT and the binary of version tag, or of the current version if
+// tag is empty.
+func Resolve(ctx context.Context, tag string) (string, string, error) {
+	if tag == "" {
+		current, err := Current(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		if current == "" {
+			appData, _ := app.FromContext(ctx)
+			return "", "", fmt.Errorf("%w: none selected, run '%s verilator install' or '%s verilator use <version>'", ErrNotInstalled, appData.Name, appData.Name)
+		}
+		tag = current
+	}
+	dir, err := versionDir(ctx, tag)
+	if err != nil {
+		return "", "", err
+	}
+	return dir, filepath.Join(dir, "bin", "verilator"), nil
+}
+
+// Command returns a command running verilator version tag (the current one if empty) with
+// VERILATOR_ROOT set.
+func Command(ctx context.Context, tag string, args ...string) (*exec.Cmd, error) {
+	root, bin, err := Resolve(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Env = append(os.Environ(), "VERILATOR_ROOT="+root)
+	return cmd, nil
+}
+
+// Passthrough runs verilator version tag (the current one if empty) with args on the
+// terminal.
+func Passthrough(ctx context.Context, tag string, args []string) error {
+	command, err := Command(ctx, tag, args...)
 	if err != nil {
 		return err
 	}
-	command := exec.Command(verBin, os.Args[2:]...)
-	command.Env = append(os.Environ(), fmt.Sprintf("VERILATOR_ROOT=%s", verRootDir))
 	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
 	xlog.Debugf(ctx, "Executing command: %s", command.String())
 	return command.Run()
 }
+
+// BinaryVersion returns the tag of the verilator binary in root, from `verilator --version`.
+func BinaryVersion(ctx context.Context, root string) (string, error) {
+	cmd := exec.CommandContext(ctx, filepath.Join(root, "bin", "verilator"), "--version")
+	cmd.Env = append(os.Environ(), "VERILATOR_ROOT="+root)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("verilator --version failed: %w", err)
+	}
+	// "Verilator 5.006 2023-01-22 rev v5.006"
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("unexpected verilator --version output %q", strings.TrimSpace(string(out)))
+	}
+	return "v" + fields[1], nil
+}
+
+// rootFiles are the parts of a VERILATOR_ROOT that verilated builds need besides bin/verilator.
+var rootFiles = []string{
+	"bin/verilator_bin",
+	"include/verilated.h",
+	"include/verilated.cpp",
+	"include/verilated.mk",
+}
+
+// CheckRoot returns an error naming the missing files if root isn't a complete VERILATOR_ROOT.
+func CheckRoot(root string) error {
+	var missing []string
+	for _, name := range rootFiles {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("incomplete VERILATOR_ROOT %s, missing %s", root, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// migrateLegacy moves a single in-place install from before versions were kept side by side
+// (root itself being the verilator checkout) to root/<tag> and selects it.
+func migrateLegacy(root string) error {
+	if _, err := os.Stat(filepath.Join(root, "bin", "verilator")); err != nil {
+		return nil
+	}
+	tag, err := BinaryVersion(context.Background(), root)
+	if err == nil {
+		err = ValidateTag(tag)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate the verilator install in %s: %w", root, err)
+	}
+	tmp := root + ".migrating"
+	if err := os.Rename(root, tmp); err != nil {
+		return fmt.Errorf("failed to migrate the verilator install in %s: %w", root, err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return fmt.Errorf("failed to migrate the verilator install in %s: %w", root, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(root, tag)); err != nil {
+		return fmt.Errorf("failed to migrate the verilator install in %s: %w", root, err)
+	}
+	return os.Symlink(tag, filepath.Join(root, currentName))
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"ssv/go/database"
 	"ssv/go/services/crypto"
 	"strings"
@@ -18,6 +19,9 @@ import (
 	"github.com/Data-Corruption/lmdb-go/wrap"
 )
 
+// PermAdmin grants administration of the instance, admins also get operational emails (e.g. updates).
+const PermAdmin = "admin"
+
 type User struct {
 	ID        []byte   `json:"-"` // no need to store key
 	Perms     []string `json:"perms"`
@@ -94,6 +98,26 @@ func GetAllUsers(ctx context.Context) ([]User, error) {
 	return out, err
 }
 
+// HasPerm reports whether the user has the given permission.
+func (u *User) HasPerm(perm string) bool {
+	return slices.Contains(u.Perms, perm)
+}
+
+// GetAdmins returns all users with [PermAdmin].
+func GetAdmins(ctx context.Context) ([]User, error) {
+	all, err := GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var admins []User
+	for _, u := range all {
+		if u.HasPerm(PermAdmin) {
+			admins = append(admins, u)
+		}
+	}
+	return admins, nil
+}
+
 // GetUserByKey retrieves a user by their key.
 // Use lmdb.IsNotFound(err) to check if the user was not found.
 func GetUserByKey(ctx context.Context, userKey []byte) (*User, error) {
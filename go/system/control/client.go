@@ -0,0 +1,257 @@
+//go:build linux
+
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"ssv/go/services/tasks/jobs"
+	"ssv/go/services/tasks/verilator"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNotRunning is returned by [Client] methods when no daemon is listening on the control socket.
+var ErrNotRunning = errors.New("daemon is not running")
+
+// StatusError is returned by [Client] methods when the daemon answers with an error status.
+type StatusError struct {
+	Code    int
+	Status  string // e.g. "404 Not Found"
+	Message string // the response body
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("daemon returned %s: %s", e.Status, e.Message)
+}
+
+func statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &StatusError{Code: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(body))}
+}
+
+// Client talks to a running daemon over its control socket.
+type Client struct {
+	http   *http.Client
+	stream *http.Client // no timeout, for log streams
+}
+
+// NewClient returns a client for the control socket of the data path in ctx.
+// It does not connect until a method is called.
+func NewClient(ctx context.Context) (*Client, error) {
+	path, err := SocketPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{
+		http:   &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		stream: &http.Client{Transport: transport},
+	}, nil
+}
+
+// Status returns the daemon's status.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var st Status
+	err := c.do(ctx, http.MethodGet, "/v1/status", nil, &st)
+	return st, err
+}
+
+// Version returns the version of the running daemon, which may differ from the CLI's after an update.
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var out struct {
+		Version string `json:"version"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v1/version", nil, &out)
+	return out.Version, err
+}
+
+// Reload asks the daemon to re-read its config.
+func (c *Client) Reload(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/reload", nil, nil)
+}
+
+// Update asks the daemon to start a detached self update.
+func (c *Client) Update(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/update", nil, nil)
+}
+
+// Shutdown asks the daemon to shut down gracefully. It returns once the request is accepted.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/shutdown", nil, nil)
+}
+
+// StartVerilatorBuild asks the daemon to build a verilator version in the background.
+func (c *Client) StartVerilatorBuild(ctx context.Context, opts verilator.InstallOptions) (verilator.BuildStatus, error) {
+	var st verilator.BuildStatus
+	err := c.do(ctx, http.MethodPost, "/v1/verilator/builds", opts, &st)
+	return st, err
+}
+
+// VerilatorBuild returns the status of a daemon build.
+func (c *Client) VerilatorBuild(ctx context.Context, id string) (verilator.BuildStatus, error) {
+	var st verilator.BuildStatus
+	err := c.do(ctx, http.MethodGet, "/v1/verilator/builds/"+url.PathEscape(id), nil, &st)
+	return st, err
+}
+
+// CancelVerilatorBuild stops a daemon build.
+func (c *Client) CancelVerilatorBuild(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/verilator/builds/"+url.PathEscape(id), nil, nil)
+}
+
+// SubmitJob queues a job in the daemon.
+func (c *Client) SubmitJob(ctx context.Context, spec jobs.Spec) (jobs.Job, error) {
+	var j jobs.Job
+	err := c.do(ctx, http.MethodPost, "/v1/jobs", spec, &j)
+	return j, err
+}
+
+// Jobs returns all jobs, newest first.
+func (c *Client) Jobs(ctx context.Context) ([]jobs.Job, error) {
+	var out struct {
+		Jobs []jobs.Job `json:"jobs"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v1/jobs", nil, &out)
+	return out.Jobs, err
+}
+
+// Job returns a job.
+func (c *Client) Job(ctx context.Context, id string) (jobs.Job, error) {
+	var j jobs.Job
+	err := c.do(ctx, http.MethodGet, "/v1/jobs/"+url.PathEscape(id), nil, &j)
+	return j, err
+}
+
+// CancelJob cancels a queued or running job.
+func (c *Client) CancelJob(ctx context.Context, id string) (jobs.Job, error) {
+	var j jobs.Job
+	err := c.do(ctx, http.MethodDelete, "/v1/jobs/"+url.PathEscape(id), nil, &j)
+	return j, err
+}
+
+// FollowJobLogs writes the log of a job to w from byte offset on and, with follow, what's
+// written until the job finishes. It returns the offset reached, to resume from after an
+// error, and the job once the stream is complete, finished when following.
+func (c *Client) FollowJobLogs(ctx context.Context, id string, offset int64, follow bool, w io.Writer) (jobs.Job, int64, error) {
+	var j jobs.Job
+	path := fmt.Sprintf("/v1/jobs/%s/logs?offset=%d&follow=%t", url.PathEscape(id), offset, follow)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://control"+path, nil)
+	if err != nil {
+		return j, offset, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return j, offset, ErrNotRunning
+		}
+		return j, offset, fmt.Errorf("control request GET %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return j, offset, statusError(resp)
+	}
+
+	// server-sent events: "log" events carry lines and the offset after them as id, the
+	// newline ending the last one is only counted in the id
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	var event, eventID string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "id":
+				eventID = value
+			case "data":
+				data = append(data, value)
+			}
+			continue
+		}
+		switch event {
+		case "log":
+			end, err := strconv.ParseInt(eventID, 10, 64)
+			if err != nil || end < offset {
+				return j, offset, fmt.Errorf("invalid log event id %q", eventID)
+			}
+			p := strings.Join(data, "\n") + "\n"
+			p = p[:min(int64(len(p)), end-offset)]
+			if _, err := io.WriteString(w, p); err != nil {
+				return j, offset, err
+			}
+			offset = end
+		case "end":
+			if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &j); err != nil {
+				return j, offset, fmt.Errorf("failed to decode job: %w", err)
+			}
+			return j, offset, nil
+		}
+		event, eventID, data = "", "", nil
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return j, offset, fmt.Errorf("log stream failed: %w", err)
+	}
+	if ctx.Err() != nil {
+		return j, offset, ctx.Err()
+	}
+	return j, offset, io.ErrUnexpectedEOF
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	// host is ignored by the unix dialer
+	req, err := http.NewRequestWithContext(ctx, method, "http://control"+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("control request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode control response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+//go:build linux
+
+// Package control implements the daemon's local control API. It is served over HTTP on a
+// unix socket in the data directory, which is only reachable by the daemon's own user:
+// the socket file is mode 0600 and every connection's peer credentials are checked.
+//
+// Endpoints (all JSON):
+//
+//	GET  /v1/status    -> [Status]
+//	GET  /v1/version   -> {"version": "..."}
+//	GET  /v1/jobs      -> {"active": n, "jobs": [[jobs.Job], ...]}, newest first
+//	POST /v1/reload    -> re-reads the config
+//	POST /v1/update    -> triggers a detached self update
+//	POST /v1/shutdown  -> graceful shutdown
+//
+//	POST   /v1/verilator/builds       [verilator.InstallOptions] -> [verilator.BuildStatus]
+//	GET    /v1/verilator/builds/{id}  -> [verilator.BuildStatus]
+//	DELETE /v1/verilator/builds/{id}  -> cancels the build
+//
+//	POST   /v1/jobs       [jobs.Spec] -> [jobs.Job]
+//	GET    /v1/jobs/{id}  -> [jobs.Job]
+//	DELETE /v1/jobs/{id}  -> cancels the job, [jobs.Job]
+//	GET    /v1/jobs/{id}/logs -> log stream, see [jobs.Engine.ServeLogs]
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"ssv/go/app"
+	"ssv/go/database/datapath"
+	"ssv/go/services/tasks/jobs"
+	"ssv/go/services/tasks/verilator"
+	"syscall"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xhttp"
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+const SocketName = "control.sock"
+
+// Status is the response of GET /v1/status.
+type Status struct {
+	Name        string        `json:"name"`
+	Instance    string        `json:"instance,omitempty"` // empty for the default instance
+	DataDir     string        `json:"dataDir"`
+	Version     string        `json:"version"`
+	PID         int           `json:"pid"`
+	StartedAt   time.Time     `json:"startedAt"`
+	Uptime      time.Duration `json:"uptime"`
+	ActiveJobs  int           `json:"activeJobs"`
+	Connections int           `json:"connections"`
+	Listeners   []string      `json:"listeners"`
+	Systemd     bool          `json:"systemd"` // whether the daemon is managed by systemd
+}
+
+// Hooks connect the control API to the daemon. Nil hooks are reported as not supported.
+type Hooks struct {
+	ActiveJobs  func() int
+	Connections func() int
+	Listeners   func() []string
+	Reload      func(ctx context.Context) error
+	Update      func(ctx context.Context) error
+	Shutdown    func() // called after the response has been written
+
+	VerilatorBuild  func(opts verilator.InstallOptions) verilator.BuildStatus
+	VerilatorStatus func(id string) (verilator.BuildStatus, bool)
+	VerilatorCancel func(id string) bool
+
+	JobSubmit func(spec jobs.Spec) (jobs.Job, error)
+	JobCancel func(id string) (jobs.Job, error)
+	JobLogs   http.HandlerFunc
+}
+
+// Server serves the control API.
+type Server struct {
+	ctx       context.Context
+	hooks     Hooks
+	startedAt time.Time
+	path      string
+	fileInfo  os.FileInfo // of the socket file, to only remove it if it's still ours
+	http      *http.Server
+	listener  net.Listener
+}
+
+// SocketPath returns the control socket path for the data path in ctx.
+func SocketPath(ctx context.Context) (string, error) {
+	dataPath := datapath.FromContext(ctx)
+	if dataPath == "" {
+		return "", fmt.Errorf("data path not set in context")
+	}
+	return filepath.Join(dataPath, SocketName), nil
+}
+
+// Listen creates the control socket and starts serving it in the background.
+// Call [Server.Close] when the daemon stops.
+func Listen(ctx context.Context, hooks Hooks) (*Server, error) {
+	path, err := SocketPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// a leftover socket means the previous daemon died without cleaning up, if it
+	// were still alive the instance lock / port bind would have stopped us earlier.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove old control socket: %w", err)
+	}
+
+	// create the socket with restrictive permissions from the start
+	oldMask := syscall.Umask(0o177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	// during a handoff the new process replaces the socket before we close ours,
+	// so don't let Close blindly unlink the path, see [Server.Close]
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	fi, err := os.Stat(path)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to stat control socket: %w", err)
+	}
+
+	s := &Server{
+		ctx:       ctx,
+		hooks:     hooks,
+		startedAt: time.Now(),
+		path:      path,
+		fileInfo:  fi,
+		listener:  &peerCheckListener{Listener: l, ctx: ctx},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", s.handleStatus)
+	mux.HandleFunc("GET /v1/version", s.handleVersion)
+	mux.HandleFunc("GET /v1/jobs", s.handleJobs)
+	mux.HandleFunc("POST /v1/reload", s.handleReload)
+	mux.HandleFunc("POST /v1/update", s.handleUpdate)
+	mux.HandleFunc("POST /v1/shutdown", s.handleShutdown)
+	mux.HandleFunc("POST /v1/verilator/builds", s.handleVerilatorBuild)
+	mux.HandleFunc("GET /v1/verilator/builds/{id}", s.handleVerilatorStatus)
+	mux.HandleFunc("DELETE /v1/verilator/builds/{id}", s.handleVerilatorCancel)
+	mux.HandleFunc("POST /v1/jobs", s.handleJobSubmit)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+	mux.HandleFunc("DELETE /v1/jobs/{id}", s.handleJobCancel)
+	mux.HandleFunc("GET /v1/jobs/{id}/logs", s.handleJobLogs)
+	s.http = &http.Server{
+		Handler:     mux,
+		ReadTimeout: 5 * time.Second,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		if err := s.http.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			xlog.Errorf(ctx, "control socket stopped: %s", err)
+		}
+	}()
+	xlog.Debugf(ctx, "control socket listening on %s", path)
+	return s, nil
+}
+
+// Close stops serving and removes the socket file, unless another process has replaced it.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.http.Shutdown(ctx)
+	if fi, statErr := os.Stat(s.path); statErr == nil && os.SameFile(fi, s.fileInfo) {
+		os.Remove(s.path)
+	}
+	return err
+}
+
+// Status returns the current daemon status.
+func (s *Server) Status() Status {
+	appData, _ := app.FromContext(s.ctx)
+	st := Status{
+		Name:      appData.Name,
+		Instance:  datapath.DirsFromContext(s.ctx).Options.Instance,
+		DataDir:   datapath.FromContext(s.ctx),
+		Version:   appData.Version,
+		PID:       os.Getpid(),
+		StartedAt: s.startedAt,
+		Uptime:    time.Since(s.startedAt).Round(time.Second),
+		Systemd:   os.Getenv("INVOCATION_ID") != "" || os.Getenv("NOTIFY_SOCKET") != "",
+	}
+	if s.hooks.ActiveJobs != nil {
+		st.ActiveJobs = s.hooks.ActiveJobs()
+	}
+	if s.hooks.Connections != nil {
+		st.Connections = s.hooks.Connections()
+	}
+	if s.hooks.Listeners != nil {
+		st.Listeners = s.hooks.Listeners()
+	}
+	return st
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Status())
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	appData, _ := app.FromContext(s.ctx)
+	writeJSON(w, map[string]string{"version": appData.Version})
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	list, err := jobs.List(s.ctx)
+	if err != nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 500, Msg: "failed to list jobs: " + err.Error(), Err: err})
+		return
+	}
+	writeJSON(w, struct {
+		Active int        `json:"active"`
+		Jobs   []jobs.Job `json:"jobs"`
+	}{s.Status().ActiveJobs, list})
+}
+
+func (s *Server) handleJobSubmit(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.JobSubmit == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "jobs not supported"})
+		return
+	}
+	var spec jobs.Spec
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&spec); err != nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 400, Msg: "invalid job: " + err.Error(), Err: err})
+		return
+	}
+	j, err := s.hooks.JobSubmit(spec)
+	if err != nil {
+		jobError(s.ctx, w, err)
+		return
+	}
+	writeJSON(w, j)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	j, err := jobs.Get(s.ctx, r.PathValue("id"))
+	if err != nil {
+		jobError(s.ctx, w, err)
+		return
+	}
+	writeJSON(w, j)
+}
+
+func (s *Server) handleJobCancel(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.JobCancel == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "jobs not supported"})
+		return
+	}
+	j, err := s.hooks.JobCancel(r.PathValue("id"))
+	if err != nil {
+		jobError(s.ctx, w, err)
+		return
+	}
+	writeJSON(w, j)
+}
+
+func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.JobLogs == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "jobs not supported"})
+		return
+	}
+	s.hooks.JobLogs(w, r)
+}
+
+// jobError maps jobs package errors to status codes.
+func jobError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		xhttp.Error(ctx, w, &xhttp.Err{Code: 404, Msg: err.Error()})
+	case errors.Is(err, jobs.ErrInvalid):
+		xhttp.Error(ctx, w, &xhttp.Err{Code: 400, Msg: err.Error(), Err: err})
+	default:
+		xhttp.Error(ctx, w, &xhttp.Err{Code: 500, Msg: err.Error(), Err: err})
+	}
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.Reload == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "reload not supported"})
+		return
+	}
+	if err := s.hooks.Reload(s.ctx); err != nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 500, Msg: "reload failed: " + err.Error(), Err: err})
+		return
+	}
+	xlog.Info(s.ctx, "config reloaded via control socket")
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.Update == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "update not supported"})
+		return
+	}
+	if err := s.hooks.Update(s.ctx); err != nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 500, Msg: "update failed: " + err.Error(), Err: err})
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.Shutdown == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "shutdown not supported"})
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	xlog.Info(s.ctx, "shutdown requested via control socket")
+	go s.hooks.Shutdown() // don't block the handler, shutdown waits for it
+}
+
+func (s *Server) handleVerilatorBuild(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.VerilatorBuild == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "verilator builds not supported"})
+		return
+	}
+	var opts verilator.InstallOptions
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&opts); err != nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 400, Msg: "invalid build options: " + err.Error(), Err: err})
+		return
+	}
+	writeJSON(w, s.hooks.VerilatorBuild(opts))
+}
+
+func (s *Server) handleVerilatorStatus(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.VerilatorStatus == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "verilator builds not supported"})
+		return
+	}
+	st, ok := s.hooks.VerilatorStatus(r.PathValue("id"))
+	if !ok {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 404, Msg: "no such build"})
+		return
+	}
+	writeJSON(w, st)
+}
+
+func (s *Server) handleVerilatorCancel(w http.ResponseWriter, r *http.Request) {
+	if s.hooks.VerilatorCancel == nil {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 501, Msg: "verilator builds not supported"})
+		return
+	}
+	if !s.hooks.VerilatorCancel(r.PathValue("id")) {
+		xhttp.Error(s.ctx, w, &xhttp.Err{Code: 404, Msg: "no such build"})
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// peerCheckListener drops connections from processes not owned by the daemon's user.
+// The socket's file mode already prevents this, this guards against a loosened mode or
+// a socket path inside a shared directory.
+type peerCheckListener struct {
+	net.Listener
+	ctx context.Context
+}
+
+func (l *peerCheckListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			xlog.Warnf(l.ctx, "control socket: failed to read peer credentials: %s", err)
+			conn.Close()
+			continue
+		}
+		if uid != os.Geteuid() {
+			xlog.Warnf(l.ctx, "control socket: rejected connection from uid %d", uid)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+func peerUID(conn net.Conn) (int, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, fmt.Errorf("not a unix connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+	return int(cred.Uid), nil
+}
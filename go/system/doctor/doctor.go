@@ -0,0 +1,384 @@
+//go:build linux
+
+// Package doctor checks the health of an installation: verilator, the build toolchain, the job
+// sandbox, the data dirs, database, config, email and the systemd unit.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ssv/go/database"
+	"ssv/go/database/config"
+	"ssv/go/database/datapath"
+	"ssv/go/database/helpers"
+	"ssv/go/services/email"
+	"ssv/go/services/tasks/sandbox"
+	"ssv/go/services/tasks/verilator"
+	"ssv/go/system/systemd"
+	"strings"
+	"syscall"
+
+	"github.com/Data-Corruption/lmdb-go/lmdb"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	diskWarn = 5 << 30 // free bytes below which builds and caches may run out of space
+	diskFail = 1 << 30
+)
+
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"` // how to fix it, for warn and fail
+}
+
+// Options configure [Run].
+type Options struct {
+	VerilatorVersion string       // check this version instead of the selected one
+	Unit             systemd.Unit // the expected service unit
+	Command          string       // how to invoke the app in hints, e.g. "ssv --instance x"
+}
+
+type startupErrKey struct{}
+
+// StartupErrorIntoContext records why the database or config failed to open, so the checks
+// can report it instead of the app exiting before they run.
+func StartupErrorIntoContext(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, startupErrKey{}, err)
+}
+
+func startupError(ctx context.Context) error {
+	err, _ := ctx.Value(startupErrKey{}).(error)
+	return err
+}
+
+// Run runs all checks.
+func Run(ctx context.Context, opts Options) []Result {
+	var results []Result
+	for _, check := range []func(context.Context, Options) []Result{
+		checkVerilator,
+		checkToolchain,
+		checkSandbox,
+		checkDisk,
+		checkDirs,
+		checkDatabase,
+		checkConfig,
+		checkEmail,
+		checkUnit,
+	} {
+		results = append(results, check(ctx, opts)...)
+	}
+	return results
+}
+
+// Worst returns the most severe status of results.
+func Worst(results []Result) Status {
+	worst := Pass
+	for _, r := range results {
+		if r.Status == Fail {
+			return Fail
+		}
+		if r.Status == Warn {
+			worst = Warn
+		}
+	}
+	return worst
+}
+
+func pass(name, format string, args ...any) Result {
+	return Result{Name: name, Status: Pass, Message: fmt.Sprintf(format, args...)}
+}
+
+func warn(name, msg, hint string) Result {
+	return Result{Name: name, Status: Warn, Message: msg, Hint: hint}
+}
+
+func fail(name, msg, hint string) Result {
+	return Result{Name: name, Status: Fail, Message: msg, Hint: hint}
+}
+
+// checkVerilator checks the binary, its version and the VERILATOR_ROOT around it. The last two
+// are skipped if there is no usable binary.
+func checkVerilator(ctx context.Context, opts Options) []Result {
+	root, bin, err := verilator.Resolve(ctx, opts.VerilatorVersion)
+	if err != nil {
+		hint := fmt.Sprintf("run '%s verilator install' or pick an installed version with '%s verilator use <version>'", opts.Command, opts.Command)
+		if !errors.Is(err, verilator.ErrNotInstalled) {
+			hint = fmt.Sprintf("reinstall it with '%s verilator remove <version>' and '%s verilator install <version>'", opts.Command, opts.Command)
+		}
+		return []Result{fail("verilator", err.Error(), hint)}
+	}
+	tag := filepath.Base(root)
+	results := []Result{pass("verilator", "%s", bin)}
+	reinstall := fmt.Sprintf("reinstall it with '%s verilator remove %s' and '%s verilator install %s'", opts.Command, tag, opts.Command, tag)
+
+	if version, err := verilator.BinaryVersion(ctx, root); err != nil {
+		results = append(results, fail("verilator version", err.Error(), reinstall))
+	} else if version != tag {
+		results = append(results, fail("verilator version", fmt.Sprintf("%s reports %s", tag, version), reinstall))
+	} else {
+		results = append(results, pass("verilator version", "%s", version))
+	}
+
+	if err := verilator.CheckRoot(root); err != nil {
+		results = append(results, fail("VERILATOR_ROOT", err.Error(), reinstall))
+	} else {
+		results = append(results, pass("VERILATOR_ROOT", "%s", root))
+	}
+	return results
+}
+
+// checkToolchain checks the tools verilated models are compiled with.
+func checkToolchain(ctx context.Context, opts Options) []Result {
+	tools := []struct {
+		name, versionArg string
+		required         bool
+		missing          string
+	}{
+		{"g++", "--version", true, "install g++ (e.g. build-essential) with your package manager"},
+		{"make", "--version", true, "install make with your package manager"},
+		{"ccache", "--version", false, "install ccache to speed up rebuilds of verilated models"},
+		{"mold", "--version", false, "install mold for faster linking"},
+	}
+	var results []Result
+	for _, t := range tools {
+		path, err := exec.LookPath(t.name)
+		if err != nil {
+			if t.required {
+				results = append(results, fail(t.name, "not found in PATH", t.missing))
+			} else {
+				results = append(results, warn(t.name, "not found in PATH", t.missing))
+			}
+			continue
+		}
+		out, err := exec.CommandContext(ctx, path, t.versionArg).Output()
+		if err != nil {
+			results = append(results, fail(t.name, fmt.Sprintf("%s %s failed: %s", path, t.versionArg, err), "reinstall "+t.name))
+			continue
+		}
+		first, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		results = append(results, pass(t.name, "%s", first))
+	}
+	return results
+}
+
+// checkDisk checks the free space of the filesystems of the data and cache dirs.
+func checkDisk(ctx context.Context, opts Options) []Result {
+	dirs := datapath.DirsFromContext(ctx)
+	var results []Result
+	seen := map[unix.Fsid]bool{}
+	for _, dir := range []string{dirs.Data, dirs.Cache} {
+		var st unix.Statfs_t
+		if err := unix.Statfs(dir, &st); err != nil {
+			results = append(results, fail("disk space", fmt.Sprintf("failed to stat %s: %s", dir, err), ""))
+			continue
+		}
+		if seen[st.Fsid] {
+			continue
+		}
+		seen[st.Fsid] = true
+		free := st.Bavail * uint64(st.Bsize)
+		msg := fmt.Sprintf("%s free on the filesystem of %s", formatBytes(free), dir)
+		hint := "free up space, verilator builds and model compiles need several GiB"
+		switch {
+		case free < diskFail:
+			results = append(results, fail("disk space", msg, hint))
+		case free < diskWarn:
+			results = append(results, warn("disk space", msg, hint))
+		default:
+			results = append(results, pass("disk space", "%s", msg))
+		}
+	}
+	return results
+}
+
+// checkDirs checks the data, state and cache dirs are ours and writable.
+func checkDirs(ctx context.Context, opts Options) []Result {
+	dirs := datapath.DirsFromContext(ctx)
+	var results []Result
+	for _, d := range []struct{ name, path string }{{"data dir", dirs.Data}, {"state dir", dirs.State}, {"cache dir", dirs.Cache}} {
+		if d.name != "data dir" && d.path == dirs.Data {
+			continue // single dir layout
+		}
+		fi, err := os.Stat(d.path)
+		if err != nil {
+			results = append(results, fail(d.name, err.Error(), ""))
+			continue
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if ok && int(st.Uid) != os.Geteuid() {
+			results = append(results, fail(d.name, fmt.Sprintf("%s is owned by uid %d", d.path, st.Uid),
+				fmt.Sprintf("chown -R %d %s", os.Geteuid(), d.path)))
+			continue
+		}
+		if err := unix.Access(d.path, unix.W_OK|unix.X_OK); err != nil {
+			results = append(results, fail(d.name, fmt.Sprintf("%s is not writable", d.path), "chmod u+rwx "+d.path))
+			continue
+		}
+		if fi.Mode().Perm()&0o002 != 0 {
+			results = append(results, warn(d.name, fmt.Sprintf("%s is world writable", d.path), "chmod o-w "+d.path))
+			continue
+		}
+		results = append(results, pass(d.name, "%s", d.path))
+	}
+	return results
+}
+
+// checkDatabase checks the database opened and can be read.
+func checkDatabase(ctx context.Context, opts Options) []Result {
+	const name = "database"
+	path := filepath.Join(datapath.FromContext(ctx), "db")
+	// update rollback needs an open database, so point at the snapshots it keeps instead
+	hint := fmt.Sprintf("move %s away to start over, or replace it with a snapshot from %s", path, filepath.Join(datapath.FromContext(ctx), "versions", "*", "db"))
+	db := database.FromContext(ctx)
+	if db == nil {
+		msg := "not open"
+		if err := startupError(ctx); err != nil {
+			msg = err.Error()
+		}
+		return []Result{fail(name, msg, hint)}
+	}
+	dbi, ok := db.GetDBis()[database.ConfigDBIName]
+	if !ok {
+		return []Result{fail(name, "config DBI missing", hint)}
+	}
+	var version string
+	if err := db.View(func(txn *lmdb.Txn) error {
+		return helpers.GetAndUnmarshal(txn, dbi, []byte("version"), &version)
+	}); err != nil {
+		return []Result{fail(name, "failed to read: "+err.Error(), hint)}
+	}
+	size := int64(0)
+	if fi, err := os.Stat(filepath.Join(path, "data.mdb")); err == nil {
+		size = fi.Size()
+	}
+	return []Result{pass(name, "%s (%s)", path, formatBytes(uint64(size)))}
+}
+
+// checkSandbox checks jobs can run in a sandbox, see jobSandbox in the config.
+func checkSandbox(ctx context.Context, opts Options) []Result {
+	const name = "job sandbox"
+	if config.FromContext(ctx) == nil {
+		return []Result{fail(name, "config not loaded", "see the database check")}
+	}
+	mode, err := config.Get[string](ctx, "jobSandbox")
+	if err != nil {
+		return []Result{fail(name, err.Error(), "")}
+	}
+	if mode == "off" {
+		return []Result{warn(name, "off, jobs can read and write the whole data dir including the database", "set jobSandbox to auto in the config")}
+	}
+	if err := sandbox.Available(); err != nil {
+		hint := "allow unprivileged user namespaces: sysctl kernel.unprivileged_userns_clone=1, or on Ubuntu an AppArmor profile granting userns"
+		if mode == "on" {
+			return []Result{fail(name, "unavailable, jobs fail: "+err.Error(), hint)}
+		}
+		return []Result{warn(name, "unavailable, jobs run unsandboxed: "+err.Error(), hint)}
+	}
+	parent, err := sandbox.CgroupParent()
+	if err != nil {
+		return []Result{pass(name, "namespaces and rlimits, no cgroup (%s)", err)}
+	}
+	return []Result{pass(name, "namespaces, rlimits and cgroups in %s", parent)}
+}
+
+// checkConfig checks the stored config matches this version's schema.
+func checkConfig(ctx context.Context, opts Options) []Result {
+	const name = "config schema"
+	cfg := config.FromContext(ctx)
+	if cfg == nil {
+		return []Result{fail(name, "config not loaded", "see the database check")}
+	}
+	var stored string
+	if err := cfg.DB.View(func(txn *lmdb.Txn) error {
+		return helpers.GetAndUnmarshal(txn, cfg.DBI, []byte("version"), &stored)
+	}); err != nil {
+		return []Result{fail(name, "failed to read the config version: "+err.Error(), "")}
+	}
+	if stored != cfg.Version {
+		return []Result{fail(name, fmt.Sprintf("stored config is %s, this build uses %s", stored, cfg.Version),
+			fmt.Sprintf("update with '%s update', the config was written by a newer version", opts.Command))}
+	}
+	return []Result{pass(name, "%s", stored)}
+}
+
+// checkEmail checks the email sender is configured. It does not log in.
+func checkEmail(ctx context.Context, opts Options) []Result {
+	const name = "email"
+	if config.FromContext(ctx) == nil {
+		return []Result{fail(name, "config not loaded", "see the database check")}
+	}
+	sender, _, err := email.GetConfig(ctx)
+	if errors.Is(err, email.ErrNotConfigured) {
+		return []Result{warn(name, "not configured, invites and update reports won't be sent", "set emailSender and emailPassword in the config")}
+	} else if err != nil {
+		return []Result{fail(name, err.Error(), "")}
+	}
+	if _, err := mail.ParseAddress(sender); err != nil {
+		return []Result{fail(name, fmt.Sprintf("invalid emailSender %q", sender), "set emailSender to a valid address")}
+	}
+	return []Result{pass(name, "sending as %s", sender)}
+}
+
+// checkUnit checks the systemd user unit is installed, current and running.
+func checkUnit(ctx context.Context, opts Options) []Result {
+	name := "service " + opts.Unit.Name
+	if err := systemd.CheckVersion(ctx); err != nil {
+		return []Result{warn(name, err.Error(), fmt.Sprintf("run the daemon yourself with '%s service run'", opts.Command))}
+	}
+	installed, drifted, err := systemd.Drift(opts.Unit)
+	if err != nil {
+		return []Result{fail(name, err.Error(), "")}
+	}
+	install := fmt.Sprintf("'%s service install'", opts.Command)
+	switch {
+	case !installed:
+		return []Result{warn(name, "not installed", "install it with "+install)}
+	case drifted:
+		return []Result{warn(name, "differs from the expected unit (manual edit or older version)", "repair it with "+install)}
+	}
+	props, err := systemd.Show(ctx, opts.Unit.Name, "ActiveState", "UnitFileState")
+	if err != nil {
+		return []Result{fail(name, err.Error(), "")}
+	}
+	state := props["ActiveState"]
+	msg := fmt.Sprintf("%s, %s", state, props["UnitFileState"])
+	switch state {
+	case "active", "reloading", "activating":
+		if props["UnitFileState"] != "enabled" {
+			return []Result{warn(name, msg, fmt.Sprintf("start it on login with '%s service enable'", opts.Command))}
+		}
+		return []Result{pass(name, "%s", msg)}
+	case "failed":
+		return []Result{fail(name, msg, fmt.Sprintf("see 'journalctl --user -u %s', then restart it with %s", opts.Unit.Name, install))}
+	default:
+		return []Result{warn(name, msg, fmt.Sprintf("start it with 'systemctl --user start %s'", opts.Unit.Name))}
+	}
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%d KiB", n>>10)
+	}
+}
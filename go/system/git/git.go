@@ -2,15 +2,40 @@ package git
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
 	"time"
 )
 
+// Release is the subset of a GitHub releases API entry we use.
+type Release struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Body        string    `json:"body"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
 // LatestGitHubReleaseTag returns the tag from /releases/latest redirect.
 func LatestGitHubReleaseTag(ctx context.Context, repoURL string) (string, error) {
-	latest := strings.TrimSuffix(repoURL, ".git") + "/releases/latest"
+	return LatestReleaseTag(ctx, ReleasesURL(repoURL))
+}
+
+// ReleasesURL returns the releases page of a GitHub repo URL, e.g.
+// "https://github.com/owner/repo.git" -> "https://github.com/owner/repo/releases".
+func ReleasesURL(repoURL string) string {
+	return strings.TrimSuffix(repoURL, ".git") + "/releases"
+}
+
+// LatestReleaseTag returns the tag from the <releasesURL>/latest redirect. Works with
+// GitHub and any mirror using the same layout.
+func LatestReleaseTag(ctx context.Context, releasesURL string) (string, error) {
+	latest := strings.TrimSuffix(releasesURL, "/") + "/latest"
 
 	client := &http.Client{
 		Timeout: 10 * time.Second,
@@ -36,3 +61,51 @@ func LatestGitHubReleaseTag(ctx context.Context, repoURL string) (string, error)
 	}
 	return "", fmt.Errorf("unexpected Location %q", loc)
 }
+
+// AssetURL returns the download URL of a release asset, same layout as GitHub:
+// <releasesURL>/download/<tag>/<asset>.
+func AssetURL(releasesURL, tag, asset string) string {
+	return strings.TrimSuffix(releasesURL, "/") + "/download/" + tag + "/" + asset
+}
+
+// APIReleasesURL returns the JSON releases listing for a releases page. GitHub pages map to
+// the api.github.com endpoint, mirrors are expected to serve the same JSON at
+// <releasesURL>/releases.json.
+func APIReleasesURL(releasesURL string) string {
+	releasesURL = strings.TrimSuffix(releasesURL, "/")
+	u, err := url.Parse(releasesURL)
+	if err == nil && u.Host == "github.com" {
+		// /owner/repo/releases
+		if parts := strings.Split(strings.Trim(u.Path, "/"), "/"); len(parts) == 3 && parts[2] == "releases" {
+			return "https://api.github.com/repos/" + parts[0] + "/" + parts[1] + "/releases?per_page=100"
+		}
+	}
+	return releasesURL + "/releases.json"
+}
+
+// ListReleases fetches the releases listing at apiURL, see [APIReleasesURL].
+// GITHUB_TOKEN is used if set, to get past the unauthenticated rate limit.
+func ListReleases(ctx context.Context, apiURL string) ([]Release, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && strings.HasPrefix(apiURL, "https://api.github.com/") {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", apiURL, resp.Status)
+	}
+	var releases []Release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("failed to decode releases: %w", err)
+	}
+	return releases, nil
+}
@@ -0,0 +1,202 @@
+//go:build linux
+
+// Package instance makes sure only one daemon runs per data directory. The daemon holds an
+// flock on daemon.lock (which contains its PID) for as long as it runs, and describes itself
+// in daemon.json so the CLI can tell whether it's up without the control socket.
+//
+// The lock is tied to the open file, not the process: during a handoff the new process
+// inherits it (see [LockFDEnv]) and keeps it after the old one exits.
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"ssv/go/database/datapath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	LockName   = "daemon.lock"
+	StatusName = "daemon.json"
+	LockFDEnv  = "SSV_LOCK_FD" // fd of an inherited lock, set by the process handing off to us
+)
+
+// Info is the content of the status file.
+type Info struct {
+	PID       int       `json:"pid"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"startedAt"`
+	Listeners []string  `json:"listeners,omitempty"`
+}
+
+// LockedError is returned by [Acquire] when another daemon holds the lock.
+type LockedError struct {
+	PID     int // 0 if unknown
+	DataDir string
+}
+
+func (e *LockedError) Error() string {
+	holder := "another daemon"
+	if e.PID != 0 {
+		holder += fmt.Sprintf(" (pid %d)", e.PID)
+	}
+	return fmt.Sprintf("%s is already running with data dir %s", holder, e.DataDir)
+}
+
+// Lock is the held instance lock, see [Acquire].
+type Lock struct {
+	f       *os.File
+	dataDir string
+	pid     int
+}
+
+// Acquire takes the instance lock of the data dir in ctx, or the one inherited through
+// [LockFDEnv]. Fails with a [*LockedError] if another daemon holds it.
+func Acquire(ctx context.Context) (*Lock, error) {
+	dataDir := datapath.FromContext(ctx)
+	if dataDir == "" {
+		return nil, fmt.Errorf("data path not set in context")
+	}
+	path := filepath.Join(dataDir, LockName)
+
+	f, err := inherited(path)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to open instance lock: %w", err)
+		}
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, unix.EWOULDBLOCK) {
+				return nil, &LockedError{PID: readPID(path), DataDir: dataDir}
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+	}
+
+	l := &Lock{f: f, dataDir: dataDir, pid: os.Getpid()}
+	if err := f.Truncate(0); err != nil {
+		l.Release()
+		return nil, fmt.Errorf("failed to write instance lock: %w", err)
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(l.pid)+"\n"), 0); err != nil {
+		l.Release()
+		return nil, fmt.Errorf("failed to write instance lock: %w", err)
+	}
+	return l, nil
+}
+
+// inherited returns the lock file passed by a handoff, nil if there is none.
+func inherited(path string) (*os.File, error) {
+	v := os.Getenv(LockFDEnv)
+	if v == "" {
+		return nil, nil
+	}
+	os.Unsetenv(LockFDEnv) // not for our own children
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", LockFDEnv, v)
+	}
+	f := os.NewFile(uintptr(fd), path)
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("inherited instance lock: %w", err)
+	}
+	if pfi, err := os.Stat(path); err != nil || !os.SameFile(fi, pfi) {
+		f.Close()
+		return nil, fmt.Errorf("inherited instance lock is not %s", path)
+	}
+	unix.CloseOnExec(fd)
+	return f, nil
+}
+
+// File returns the lock file, to be passed on by a handoff.
+func (l *Lock) File() *os.File {
+	return l.f
+}
+
+// WriteStatus replaces the status file.
+func (l *Lock) WriteStatus(info Info) error {
+	info.PID = l.pid
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.dataDir, StatusName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	return nil
+}
+
+// Release removes the status file, unless a process we handed off to replaced it, and closes
+// the lock. The lock is not explicitly unlocked as the handoff process may share it.
+func (l *Lock) Release() error {
+	path := filepath.Join(l.dataDir, StatusName)
+	if info, err := readStatus(path); err == nil && info.PID == l.pid {
+		os.Remove(path)
+	}
+	return l.f.Close()
+}
+
+// Running reports whether a daemon holds the instance lock of the data dir in ctx, and
+// describes it. The Info is empty except for the PID if the status file is missing.
+func Running(ctx context.Context) (Info, bool, error) {
+	dataDir := datapath.FromContext(ctx)
+	if dataDir == "" {
+		return Info{}, false, fmt.Errorf("data path not set in context")
+	}
+	path := filepath.Join(dataDir, LockName)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, false, nil
+	} else if err != nil {
+		return Info{}, false, fmt.Errorf("failed to open instance lock: %w", err)
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB); err == nil {
+		return Info{}, false, nil // closing unlocks
+	} else if !errors.Is(err, unix.EWOULDBLOCK) {
+		return Info{}, false, fmt.Errorf("failed to check instance lock: %w", err)
+	}
+	pid := readPID(path)
+	info, err := readStatus(filepath.Join(dataDir, StatusName))
+	if err != nil || info.PID != pid {
+		info = Info{PID: pid} // starting up, or mid handoff
+	}
+	return info, true, nil
+}
+
+func readPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+func readStatus(path string) (Info, error) {
+	var info Info
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
@@ -0,0 +1,158 @@
+// Package minisign verifies detached ed25519 signatures in the minisign format
+// (https://jedisct1.github.io/minisign/), as produced by `minisign -S`.
+package minisign
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	algEd        = "Ed" // legacy, signature over the message itself
+	algPrehashed = "ED" // signature over the BLAKE2b-512 hash of the message, the default since minisign 0.10
+)
+
+var (
+	ErrKeyMismatch  = errors.New("signature was made with a different key")
+	ErrBadSignature = errors.New("signature verification failed")
+)
+
+// KeyID identifies a key pair, it's stored in both the public key and signatures.
+type KeyID [8]byte
+
+// String formats the key ID the way minisign prints it.
+func (id KeyID) String() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+type PublicKey struct {
+	ID  KeyID
+	Key ed25519.PublicKey
+}
+
+type Signature struct {
+	Algorithm       string
+	KeyID           KeyID
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// ParsePublicKey parses a public key, either the base64 line alone or the contents of a
+// minisign.pub file (with its untrusted comment line).
+func ParsePublicKey(s string) (PublicKey, error) {
+	lines := nonEmptyLines(s)
+	if len(lines) == 0 {
+		return PublicKey{}, fmt.Errorf("empty public key")
+	}
+	line := lines[len(lines)-1]
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != algEd {
+		return PublicKey{}, fmt.Errorf("invalid public key")
+	}
+	var pk PublicKey
+	copy(pk.ID[:], raw[2:10])
+	pk.Key = ed25519.PublicKey(raw[10:])
+	return pk, nil
+}
+
+// ParseSignature parses the contents of a .minisig file.
+func ParseSignature(b []byte) (Signature, error) {
+	lines := nonEmptyLines(string(b))
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "untrusted comment:") || !strings.HasPrefix(lines[2], "trusted comment:") {
+		return Signature{}, fmt.Errorf("invalid signature file format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return Signature{}, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	if len(raw) != 2+8+ed25519.SignatureSize {
+		return Signature{}, fmt.Errorf("invalid signature length")
+	}
+	sig := Signature{
+		Algorithm:      string(raw[:2]),
+		Signature:      raw[10:],
+		TrustedComment: strings.TrimPrefix(strings.TrimPrefix(lines[2], "trusted comment:"), " "),
+	}
+	if sig.Algorithm != algEd && sig.Algorithm != algPrehashed {
+		return Signature{}, fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
+	copy(sig.KeyID[:], raw[2:10])
+	if sig.GlobalSignature, err = base64.StdEncoding.DecodeString(lines[3]); err != nil {
+		return Signature{}, fmt.Errorf("failed to decode global signature: %w", err)
+	}
+	if len(sig.GlobalSignature) != ed25519.SignatureSize {
+		return Signature{}, fmt.Errorf("invalid global signature length")
+	}
+	return sig, nil
+}
+
+// Verify checks sig over the message read from r, including the trusted comment.
+func (pk PublicKey) Verify(r io.Reader, sig Signature) error {
+	if sig.KeyID != pk.ID {
+		return fmt.Errorf("%w: signed by key %s, expected %s", ErrKeyMismatch, sig.KeyID, pk.ID)
+	}
+	var msg []byte
+	if sig.Algorithm == algPrehashed {
+		h, _ := blake2b.New512(nil)
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		msg = h.Sum(nil)
+	} else {
+		var err error
+		if msg, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	if !ed25519.Verify(pk.Key, msg, sig.Signature) {
+		return ErrBadSignature
+	}
+	// the global signature covers the trusted comment, so it can't be swapped either
+	global := append(bytes.Clone(sig.Signature), sig.TrustedComment...)
+	if !ed25519.Verify(pk.Key, global, sig.GlobalSignature) {
+		return fmt.Errorf("%w: trusted comment", ErrBadSignature)
+	}
+	return nil
+}
+
+// VerifyFile checks the signature file sigPath over the file at path.
+func (pk PublicKey) VerifyFile(path, sigPath string) (Signature, error) {
+	b, err := os.ReadFile(sigPath)
+	if err != nil {
+		return Signature{}, err
+	}
+	sig, err := ParseSignature(b)
+	if err != nil {
+		return Signature{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Signature{}, err
+	}
+	defer f.Close()
+	return sig, pk.Verify(bufio.NewReader(f), sig)
+}
+
+func nonEmptyLines(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,205 @@
+package minisign_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"ssv/go/system/minisign"
+	"strings"
+	"testing"
+)
+
+// Known answers, made with an implementation of RFC 8032's ed25519 checked against its test
+// vectors, not this package's dependencies: the key is derived from the seed
+// sha256("ssv minisign test key"), its ID is a1b2c3d4e5f60718.
+const (
+	testKeyID   = "1807F6E5D4C3B2A1"
+	testMessage = "ssv release v1.2.3\n"
+	testPubFile = "untrusted comment: minisign public key 1807F6E5D4C3B2A1\n" + testPub + "\n"
+	testPub     = "RWShssPU5fYHGMUR2yzIqN1I/aDBIdFai+v9B4ENlattTJW8jPT/TSoM"
+
+	// prehashed, minisign's default
+	testSigED = "untrusted comment: signature from minisign secret key\n" +
+		"RUShssPU5fYHGPH00FShjThqdiGYL8Z9qi8pGAm+tqiGCjSktJv1KgS6KKjs/7Kvw5oqvVMY6GK1YgpkWnDYbHGEh/juzsw4ZA8=\n" +
+		"trusted comment: " + testCommentED + "\n" +
+		"7mQcPJYjngI/2+FVFsWnUQ24BIk8mAq7J1reW3SKaXBif2FhwuJbJyAR4S/r8t0d1hVZjJ+brATEhkTUQhsMAA==\n"
+	testCommentED = "timestamp:1735689600\tfile:linux-amd64.gz\thashed"
+
+	// legacy, `minisign -S -l`
+	testSigEd = "untrusted comment: signature from minisign secret key\n" +
+		"RWShssPU5fYHGJw/0Zlymk/kLjVZo38gdmuO6T4X/TSIN2w+jVyrU8arxYdtz4alpr0hGmK0hjyr8yUQi1YjjHVDaHRilfh/Pgw=\n" +
+		"trusted comment: " + testCommentEd + "\n" +
+		"4rYv6ZCqhgZD7ymYZg3oDfnJWXhIGwIuqRk+b56+PmqKEGoMw/P6o3s5g6RCaRHPX7ri2YkIVqBEOlC3QfvyBw==\n"
+	testCommentEd = "timestamp:1735689600\tfile:linux-amd64.gz"
+
+	// RFC 8032 test 1's public key, with the test key's ID
+	rfcPub = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+)
+
+func parseKey(t *testing.T, s string) minisign.PublicKey {
+	t.Helper()
+	pk, err := minisign.ParsePublicKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pk
+}
+
+func parseSig(t *testing.T, s string) minisign.Signature {
+	t.Helper()
+	sig, err := minisign.ParseSignature([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestParsePublicKey(t *testing.T) {
+	for _, s := range []string{testPub, testPubFile, "\n" + testPub + "\n\n"} {
+		pk := parseKey(t, s)
+		if pk.ID.String() != testKeyID {
+			t.Fatalf("key ID %s, want %s", pk.ID, testKeyID)
+		}
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(testPub)
+	for name, s := range map[string]string{
+		"empty":         "",
+		"not base64":    "RWS!!!",
+		"short":         base64.StdEncoding.EncodeToString(raw[:len(raw)-1]),
+		"secret key":    base64.StdEncoding.EncodeToString(append([]byte("Ed"), make([]byte, 72)...)),
+		"other prefix":  base64.StdEncoding.EncodeToString(append([]byte("ED"), raw[2:]...)),
+		"signature key": strings.Split(testSigED, "\n")[1],
+	} {
+		if _, err := minisign.ParsePublicKey(s); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	sig := parseSig(t, testSigED)
+	if sig.Algorithm != "ED" || sig.KeyID.String() != testKeyID || sig.TrustedComment != testCommentED {
+		t.Fatalf("parsed %s %s %q", sig.Algorithm, sig.KeyID, sig.TrustedComment)
+	}
+
+	lines := strings.Split(testSigED, "\n")
+	raw, _ := base64.StdEncoding.DecodeString(lines[1])
+	with := func(i int, line string) string {
+		l := append([]string(nil), lines...)
+		l[i] = line
+		return strings.Join(l, "\n")
+	}
+	for name, s := range map[string]string{
+		"empty":              "",
+		"no trusted comment": strings.Join(lines[:2], "\n"),
+		"untrusted comment":  with(0, "comment: x"),
+		"trusted comment":    with(2, "comment: x"),
+		"short signature":    with(1, base64.StdEncoding.EncodeToString(raw[:len(raw)-1])),
+		"algorithm":          with(1, base64.StdEncoding.EncodeToString(append([]byte("Eb"), raw[2:]...))),
+		"global signature":   with(3, lines[3][:40]),
+		"not base64":         with(1, "RUS!!!"),
+	} {
+		if _, err := minisign.ParseSignature([]byte(s)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pk := parseKey(t, testPubFile)
+	for _, tc := range []struct {
+		alg, sig, comment string
+	}{
+		{"ED", testSigED, testCommentED},
+		{"Ed", testSigEd, testCommentEd},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			sig := parseSig(t, tc.sig)
+			if sig.Algorithm != tc.alg || sig.TrustedComment != tc.comment {
+				t.Fatalf("parsed %s %q", sig.Algorithm, sig.TrustedComment)
+			}
+			if err := pk.Verify(strings.NewReader(testMessage), sig); err != nil {
+				t.Fatalf("known answer rejected: %s", err)
+			}
+			if err := pk.Verify(strings.NewReader(testMessage+"x"), sig); !errors.Is(err, minisign.ErrBadSignature) {
+				t.Fatalf("tampered message: got %v", err)
+			}
+			if err := pk.Verify(strings.NewReader(""), sig); !errors.Is(err, minisign.ErrBadSignature) {
+				t.Fatalf("empty message: got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	pk := parseKey(t, testPub)
+	for name, tc := range map[string]struct {
+		sig  func(sig *minisign.Signature)
+		want error
+	}{
+		"trusted comment": {func(sig *minisign.Signature) {
+			sig.TrustedComment = strings.Replace(sig.TrustedComment, "linux-amd64", "linux-arm64", 1)
+		}, minisign.ErrBadSignature},
+		"global signature": {func(sig *minisign.Signature) {
+			sig.GlobalSignature = parseSig(t, testSigEd).GlobalSignature
+		}, minisign.ErrBadSignature},
+		"signature": {func(sig *minisign.Signature) {
+			sig.Signature = bytes.Clone(sig.Signature)
+			sig.Signature[0] ^= 1
+		}, minisign.ErrBadSignature},
+		// a prehashed signature isn't one over the message itself
+		"algorithm": {func(sig *minisign.Signature) {
+			sig.Algorithm = "Ed"
+		}, minisign.ErrBadSignature},
+		"key ID": {func(sig *minisign.Signature) {
+			sig.KeyID[0] ^= 1
+		}, minisign.ErrKeyMismatch},
+	} {
+		sig := parseSig(t, testSigED)
+		tc.sig(&sig)
+		if err := pk.Verify(strings.NewReader(testMessage), sig); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+
+	// same key ID, other key
+	raw, _ := base64.StdEncoding.DecodeString(testPub)
+	key, _ := hex.DecodeString(rfcPub)
+	other := parseKey(t, base64.StdEncoding.EncodeToString(slices.Concat(raw[:10], key)))
+	if err := other.Verify(strings.NewReader(testMessage), parseSig(t, testSigED)); !errors.Is(err, minisign.ErrBadSignature) {
+		t.Errorf("other key: got %v", err)
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "linux-amd64.gz")
+	if err := os.WriteFile(path, []byte(testMessage), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".minisig", []byte(testSigED), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pk := parseKey(t, testPub)
+	sig, err := pk.VerifyFile(path, path+".minisig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.TrustedComment != testCommentED {
+		t.Fatalf("trusted comment %q", sig.TrustedComment)
+	}
+	if _, err := pk.VerifyFile(path, filepath.Join(dir, "missing.minisig")); err == nil {
+		t.Fatal("verified without a signature file")
+	}
+	if err := os.WriteFile(path, []byte(strings.ToUpper(testMessage)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pk.VerifyFile(path, path+".minisig"); !errors.Is(err, minisign.ErrBadSignature) {
+		t.Fatalf("tampered file: got %v", err)
+	}
+}
@@ -3,13 +3,25 @@ package sdnotify
 import (
 	"net"
 	"os"
+	"strconv"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
+func notifySocket() string {
+	return os.Getenv("NOTIFY_SOCKET")
+}
+
 // notify sends k=v lines to systemd if NOTIFY_SOCKET is set.
 // It is a no-op if NOTIFY_SOCKET is unset.
 func notify(pairs map[string]string) error {
-	addr := os.Getenv("NOTIFY_SOCKET")
+	return send(notifySocket(), pairs, nil)
+}
+
+// send writes k=v lines to the notify socket at addr, passing fds along via SCM_RIGHTS.
+// It is a no-op if addr is empty.
+func send(addr string, pairs map[string]string, fds []int) error {
 	if addr == "" {
 		return nil // not under systemd or Type!=notify
 	}
@@ -40,6 +52,10 @@ func notify(pairs map[string]string) error {
 
 	// systemd expects this to be best-effort, fire-and-forget.
 	_ = conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if len(fds) > 0 {
+		_, _, err = conn.WriteMsgUnix(msg, unix.UnixRights(fds...), nil)
+		return err
+	}
 	_, err = conn.Write(msg)
 	return err
 }
@@ -59,10 +75,103 @@ func Stopping(status string) error {
 	return notify(map[string]string{"STOPPING": "1", "STATUS": status})
 }
 
+// ReadyTo sends READY=1 to the notify socket at addr instead of NOTIFY_SOCKET.
+// Used to tell a parent handing off its listeners that we took over.
+func ReadyTo(addr, status string) error {
+	pairs := map[string]string{"READY": "1"}
+	if status != "" {
+		pairs["STATUS"] = status
+	}
+	return send(addr, pairs, nil)
+}
+
+// FDStore hands files to systemd's file descriptor store under name (FDSTORE=1).
+// systemd passes them back on the next start through LISTEN_FDS / LISTEN_FDNAMES,
+// which keeps listening sockets open across restarts. Requires FileDescriptorStoreMax > 0
+// in the unit. It is a no-op if NOTIFY_SOCKET is unset.
+func FDStore(name string, files ...*os.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	return send(notifySocket(), map[string]string{"FDSTORE": "1", "FDNAME": name}, fds)
+}
+
+// FDStoreRemove drops every fd stored under name from systemd's file descriptor store.
+func FDStoreRemove(name string) error {
+	return notify(map[string]string{"FDSTOREREMOVE": "1", "FDNAME": name})
+}
+
+// Status updates the free-form status line shown by `systemctl status`.
+func Status(status string) error {
+	return notify(map[string]string{"STATUS": status})
+}
+
+// Reloading tells systemd the service is reloading its configuration.
+// Send [Ready] once the reload is done.
+func Reloading(status string) error {
+	if status == "" {
+		status = "Reloading"
+	}
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return err
+	}
+	usec := ts.Sec*1_000_000 + ts.Nsec/1_000
+	return notify(map[string]string{"RELOADING": "1", "MONOTONIC_USEC": strconv.FormatInt(usec, 10), "STATUS": status})
+}
+
+// ExtendTimeout asks systemd to allow the current start, stop or reload step to take
+// at least d longer, measured from now. Resend before d elapses for longer operations,
+// or use [KeepExtending].
+func ExtendTimeout(d time.Duration) error {
+	return notify(map[string]string{"EXTEND_TIMEOUT_USEC": strconv.FormatInt(d.Microseconds(), 10)})
+}
+
+// KeepExtending re-sends [ExtendTimeout] with d every d/2 until stop is called.
+// Use it around work of unknown length, e.g. a config migration during startup.
+// It is a no-op if NOTIFY_SOCKET is unset.
+func KeepExtending(d time.Duration) (stop func()) {
+	if !Enabled() {
+		return func() {}
+	}
+	done := make(chan struct{})
+	_ = ExtendTimeout(d)
+	go func() {
+		ticker := time.NewTicker(d / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = ExtendTimeout(d)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
 // Watchdog pokes the watchdog if WatchdogSec is configured in the unit.
-// Call periodically <= WatchdogSec/2.
+// Call periodically <= WatchdogSec/2, or use [Supervise].
 // Returns nil if NOTIFY_SOCKET unset (no-op).
-// Not used by Sprout at this time.
 func Watchdog() error {
 	return notify(map[string]string{"WATCHDOG": "1"})
 }
+
+// WatchdogInterval returns the watchdog timeout systemd expects pings within (WATCHDOG_USEC).
+// ok is false if the watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	// WATCHDOG_PID, if set, must be us, otherwise the env was inherited from a parent
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
@@ -0,0 +1,241 @@
+package sdnotify_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"ssv/go/system/sdnotify"
+	"ssv/go/system/sdnotify/sdnotifytest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	watchdog = 200 * time.Millisecond // pings every 100ms, checks time out after 50ms
+	wait     = 5 * time.Second
+)
+
+// listen starts a fake notify socket with the watchdog set to d, 0 disables it.
+func listen(t *testing.T, d time.Duration) *sdnotifytest.Socket {
+	t.Helper()
+	sock, err := sdnotifytest.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := sock.Setenv(d)
+	t.Cleanup(func() {
+		restore()
+		sock.Close()
+	})
+	return sock
+}
+
+// supervise runs sdnotify.Supervise until the test ends.
+func supervise(t *testing.T, hb sdnotify.Heartbeat) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sdnotify.Supervise(ctx, hb)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestSuperviseWatchdogPing(t *testing.T) {
+	sock := listen(t, watchdog)
+	supervise(t, sdnotify.Heartbeat{
+		Checks: []sdnotify.Check{{Name: "ok", Fn: func(context.Context) error { return nil }}},
+		Status: func() string { return "2 jobs running, 1 connection" },
+	})
+	if _, err := sock.WaitFor("WATCHDOG", "1", wait); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sock.WaitFor("STATUS", "2 jobs running, 1 connection", wait); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSuperviseWithholdsPing(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fn   func(ctx context.Context, healthy bool) error
+	}{
+		{"failing", func(_ context.Context, healthy bool) error {
+			if !healthy {
+				return errors.New("wedged")
+			}
+			return nil
+		}},
+		{"blocking", func(ctx context.Context, healthy bool) error {
+			if !healthy {
+				<-ctx.Done() // past the check timeout
+				time.Sleep(watchdog)
+			}
+			return nil
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sock := listen(t, watchdog)
+			var healthy atomic.Bool
+			supervise(t, sdnotify.Heartbeat{
+				Checks: []sdnotify.Check{{Name: tc.name, Fn: func(ctx context.Context) error {
+					return tc.fn(ctx, healthy.Load())
+				}}},
+				Status: func() string { return "up" },
+			})
+			// ticks keep happening, only the ping is withheld
+			if _, err := sock.WaitFor("STATUS", "up", wait); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(3 * watchdog)
+			if n := sock.Count("WATCHDOG", "1"); n != 0 {
+				t.Fatalf("got %d watchdog pings with a %s check", n, tc.name)
+			}
+			healthy.Store(true)
+			if _, err := sock.WaitFor("WATCHDOG", "1", wait); err != nil {
+				t.Fatalf("no ping after the check recovered: %s", err)
+			}
+		})
+	}
+}
+
+func TestSuperviseWithoutWatchdog(t *testing.T) {
+	sock := listen(t, 0)
+	var checked atomic.Bool
+	supervise(t, sdnotify.Heartbeat{
+		Checks: []sdnotify.Check{{Name: "never", Fn: func(context.Context) error {
+			checked.Store(true)
+			return nil
+		}}},
+	})
+	time.Sleep(3 * watchdog)
+	if n := len(sock.Messages()); n != 0 {
+		t.Fatalf("got %d messages before the first status interval", n)
+	}
+	if checked.Load() {
+		t.Fatal("checks ran without a watchdog")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	listen(t, watchdog)
+	if d, ok := sdnotify.WatchdogInterval(); !ok || d != watchdog {
+		t.Fatalf("got %s, %v, want %s", d, ok, watchdog)
+	}
+	// inherited from a parent
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getppid()))
+	if _, ok := sdnotify.WatchdogInterval(); ok {
+		t.Fatal("watchdog enabled for another pid")
+	}
+}
+
+func TestReloading(t *testing.T) {
+	sock := listen(t, 0)
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		t.Fatal(err)
+	}
+	before := ts.Sec*1_000_000 + ts.Nsec/1_000
+	if err := sdnotify.Reloading(""); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sock.WaitFor("RELOADING", "1", wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usec, err := strconv.ParseInt(msg["MONOTONIC_USEC"], 10, 64)
+	if err != nil {
+		t.Fatalf("MONOTONIC_USEC %q: %s", msg["MONOTONIC_USEC"], err)
+	}
+	// systemd matches it against CLOCK_MONOTONIC, not the wall clock
+	if usec < before || usec > before+int64(wait/time.Microsecond) {
+		t.Fatalf("MONOTONIC_USEC %d, monotonic clock was %d", usec, before)
+	}
+	if msg["STATUS"] != "Reloading" {
+		t.Fatalf("STATUS %q, want Reloading", msg["STATUS"])
+	}
+}
+
+func TestExtendTimeout(t *testing.T) {
+	sock := listen(t, 0)
+	if err := sdnotify.ExtendTimeout(90 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sock.WaitFor("EXTEND_TIMEOUT_USEC", "90000000", wait); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := sdnotify.KeepExtending(watchdog)
+	usec := strconv.FormatInt(watchdog.Microseconds(), 10)
+	deadline := time.Now().Add(wait)
+	for sock.Count("EXTEND_TIMEOUT_USEC", usec) < 3 {
+		if time.Now().After(deadline) {
+			stop()
+			t.Fatalf("got %d extensions, want them every %s", sock.Count("EXTEND_TIMEOUT_USEC", usec), watchdog/2)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	time.Sleep(watchdog / 4) // one may be in flight
+	n := sock.Count("EXTEND_TIMEOUT_USEC", usec)
+	time.Sleep(2 * watchdog)
+	if m := sock.Count("EXTEND_TIMEOUT_USEC", usec); m != n {
+		t.Fatalf("got %d extensions after stop", m-n)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	sock := listen(t, 0)
+	if err := sdnotify.Ready("listening on :28080"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sock.WaitFor("READY", "1", wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg["STATUS"] != "listening on :28080" {
+		t.Fatalf("READY with STATUS %q", msg["STATUS"])
+	}
+	if err := sdnotify.Status("3 jobs queued"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sock.WaitFor("STATUS", "3 jobs queued", wait); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdnotify.Stopping(""); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = sock.WaitFor("STOPPING", "1", wait); err != nil {
+		t.Fatal(err)
+	}
+	if msg["STATUS"] != "Stopping" {
+		t.Fatalf("STOPPING with STATUS %q", msg["STATUS"])
+	}
+}
+
+func TestNoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sdnotify.Enabled() {
+		t.Fatal("enabled without NOTIFY_SOCKET")
+	}
+	if err := sdnotify.Status("x"); err != nil {
+		t.Fatalf("status without a socket: %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		sdnotify.Supervise(context.Background(), sdnotify.Heartbeat{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(wait):
+		t.Fatal("Supervise didn't return without NOTIFY_SOCKET")
+	}
+}
@@ -0,0 +1,177 @@
+// Package sdnotifytest provides a fake NOTIFY_SOCKET for exercising sdnotify and the
+// daemon's systemd integration without systemd.
+//
+// Usage:
+//
+//	sock, err := sdnotifytest.Listen()
+//	if err != nil { ... }
+//	defer sock.Close()
+//	restore := sock.Setenv(30 * time.Second) // NOTIFY_SOCKET, WATCHDOG_USEC, WATCHDOG_PID
+//	defer restore()
+//
+//	go sdnotify.Supervise(ctx, hb)
+//	msg, err := sock.WaitFor("WATCHDOG", "1", 20*time.Second)
+package sdnotifytest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is one datagram sent to the socket, parsed into its KEY=VALUE pairs.
+type Message map[string]string
+
+// Socket is a unixgram socket standing in for systemd's notify socket.
+// Every datagram received is recorded and can be waited on.
+type Socket struct {
+	Path string
+
+	dir  string
+	conn *net.UnixConn
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	msgs    []Message
+	readErr error
+}
+
+// Listen creates the socket in a fresh temp dir and starts receiving.
+func Listen() (*Socket, error) {
+	dir, err := os.MkdirTemp("", "sdnotifytest-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s := &Socket{Path: path, dir: dir, conn: conn}
+	s.cond = sync.NewCond(&s.mu)
+	go s.read()
+	return s, nil
+}
+
+func (s *Socket) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := s.conn.Read(buf)
+		s.mu.Lock()
+		if err != nil {
+			s.readErr = err
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			return
+		}
+		s.msgs = append(s.msgs, parse(buf[:n]))
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+func parse(b []byte) Message {
+	m := Message{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// Setenv points NOTIFY_SOCKET at the socket. A positive watchdog also sets WATCHDOG_USEC
+// and WATCHDOG_PID for the current process. The returned func restores the previous env.
+func (s *Socket) Setenv(watchdog time.Duration) (restore func()) {
+	keys := []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID"}
+	prev := map[string]*string{}
+	for _, k := range keys {
+		if v, ok := os.LookupEnv(k); ok {
+			prev[k] = &v
+		} else {
+			prev[k] = nil
+		}
+	}
+	os.Setenv("NOTIFY_SOCKET", s.Path)
+	if watchdog > 0 {
+		os.Setenv("WATCHDOG_USEC", strconv.FormatInt(watchdog.Microseconds(), 10))
+		os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	} else {
+		os.Unsetenv("WATCHDOG_USEC")
+		os.Unsetenv("WATCHDOG_PID")
+	}
+	return func() {
+		for k, v := range prev {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+// Messages returns a copy of everything received so far.
+func (s *Socket) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Message, len(s.msgs))
+	copy(out, s.msgs)
+	return out
+}
+
+// Count returns how many received messages contain key=value.
+func (s *Socket) Count(key, value string) int {
+	n := 0
+	for _, m := range s.Messages() {
+		if v, ok := m[key]; ok && v == value {
+			n++
+		}
+	}
+	return n
+}
+
+// WaitFor blocks until a message containing key is received (with the given value, unless
+// value is empty) or timeout passes. Messages received before the call count too.
+func (s *Socket) WaitFor(key, value string, timeout time.Duration) (Message, error) {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; ; {
+		for ; i < len(s.msgs); i++ {
+			if v, ok := s.msgs[i][key]; ok && (value == "" || v == value) {
+				return s.msgs[i], nil
+			}
+		}
+		if s.readErr != nil {
+			return nil, s.readErr
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for %s=%s", timeout, key, value)
+		}
+		s.cond.Wait()
+	}
+}
+
+// Close stops receiving and removes the socket.
+func (s *Socket) Close() error {
+	err := s.conn.Close()
+	if rmErr := os.RemoveAll(s.dir); rmErr != nil {
+		err = errors.Join(err, rmErr)
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package sdnotify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// DefaultStatusInterval is how often [Supervise] refreshes STATUS= when the watchdog is disabled.
+const DefaultStatusInterval = 30 * time.Second
+
+// Check is a named liveness probe. It should return quickly, a check that blocks
+// past its deadline counts as failed, which is exactly what a wedged component looks like.
+type Check struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// Heartbeat configures [Supervise].
+type Heartbeat struct {
+	Checks []Check
+	// Status, if non-nil, is sent as STATUS= on every tick, e.g. live job and connection counts.
+	Status func() string
+}
+
+// Supervise runs the heartbeat until ctx is done. If the systemd watchdog is enabled
+// (WATCHDOG_USEC), every WATCHDOG_USEC/2 it runs all checks and only pings WATCHDOG=1 if they
+// all pass, so a wedged HTTP server or worker pool gets the service restarted by systemd
+// instead of hanging forever. Without a watchdog it only refreshes STATUS=.
+//
+// Returns immediately if NOTIFY_SOCKET is unset.
+func Supervise(ctx context.Context, hb Heartbeat) {
+	if !Enabled() {
+		return
+	}
+	watchdog, useWatchdog := WatchdogInterval()
+	interval := DefaultStatusInterval
+	if useWatchdog {
+		interval = watchdog / 2
+	}
+	checkTimeout := interval / 2
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if hb.Status != nil {
+			if err := Status(hb.Status()); err != nil {
+				xlog.Debugf(ctx, "sd_notify STATUS failed: %v", err)
+			}
+		}
+		if !useWatchdog {
+			continue
+		}
+		if err := runChecks(ctx, hb.Checks, checkTimeout); err != nil {
+			// skip the ping, systemd restarts us once WatchdogSec passes without one
+			xlog.Errorf(ctx, "watchdog: health check failed, withholding ping: %s", err)
+			continue
+		}
+		if err := Watchdog(); err != nil {
+			xlog.Debugf(ctx, "sd_notify WATCHDOG failed: %v", err)
+		}
+	}
+}
+
+// Enabled reports whether NOTIFY_SOCKET is set, i.e. notifications go anywhere.
+func Enabled() bool {
+	return notifySocket() != ""
+}
+
+// runChecks runs checks concurrently, each bounded by timeout, returning the first failure.
+func runChecks(ctx context.Context, checks []Check, timeout time.Duration) error {
+	cCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	errCh := make(chan error, len(checks))
+	for _, c := range checks {
+		go func(c Check) {
+			if err := c.Fn(cCtx); err != nil {
+				errCh <- fmt.Errorf("%s: %w", c.Name, err)
+				return
+			}
+			errCh <- nil
+		}(c)
+	}
+	for range checks {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				return err
+			}
+		case <-cCtx.Done():
+			return fmt.Errorf("checks did not finish within %s", timeout)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+//go:build linux
+
+package systemd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// MinVersion is the oldest systemd supported, same requirement as the install script.
+const MinVersion = 246
+
+// systemctl runs `systemctl --user <args>` and returns trimmed stdout.
+func systemctl(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "systemctl", append([]string{"--user"}, args...)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return strings.TrimSpace(stdout.String()), fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, msg)
+		}
+		return strings.TrimSpace(stdout.String()), fmt.Errorf("systemctl %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Version returns the major version of the user's systemd.
+func Version(ctx context.Context) (int, error) {
+	out, err := systemctl(ctx, "--version")
+	if err != nil {
+		return 0, fmt.Errorf("systemd --user not available: %w", err)
+	}
+	// e.g. "systemd 255 (255.4-1ubuntu8)"
+	var v int
+	if _, err := fmt.Sscanf(out, "systemd %d", &v); err != nil {
+		return 0, fmt.Errorf("failed to parse systemd version from %q", out)
+	}
+	return v, nil
+}
+
+// CheckVersion returns an error if systemd --user is missing or older than [MinVersion].
+func CheckVersion(ctx context.Context) error {
+	v, err := Version(ctx)
+	if err != nil {
+		return err
+	}
+	if v < MinVersion {
+		return fmt.Errorf("systemd ≥ %d required, found %d", MinVersion, v)
+	}
+	return nil
+}
+
+func DaemonReload(ctx context.Context) error {
+	_, err := systemctl(ctx, "daemon-reload")
+	return err
+}
+
+func Enable(ctx context.Context, name string) error {
+	_, err := systemctl(ctx, "enable", name)
+	return err
+}
+
+func Disable(ctx context.Context, name string) error {
+	_, err := systemctl(ctx, "disable", name)
+	return err
+}
+
+func Stop(ctx context.Context, name string) error {
+	_, err := systemctl(ctx, "stop", name)
+	return err
+}
+
+func ResetFailed(ctx context.Context, name string) error {
+	_, err := systemctl(ctx, "reset-failed", name)
+	return err
+}
+
+func IsActive(ctx context.Context, name string) bool {
+	_, err := systemctl(ctx, "is-active", "--quiet", name)
+	return err == nil
+}
+
+func IsEnabled(ctx context.Context, name string) bool {
+	_, err := systemctl(ctx, "is-enabled", "--quiet", name)
+	return err == nil
+}
+
+// Show returns the requested unit properties, e.g. Show(ctx, name, "ActiveState", "SubState").
+func Show(ctx context.Context, name string, props ...string) (map[string]string, error) {
+	out, err := systemctl(ctx, "show", name, "--property="+strings.Join(props, ","))
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(props))
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			m[k] = v
+		}
+	}
+	return m, nil
+}
+
+// StartAndWait starts (or restarts, if active) the unit and waits up to timeout for it to
+// report READY=1. For Type=notify units systemctl itself blocks until READY or failure,
+// the timeout bounds that in case systemd's own TimeoutStartSec is longer.
+func StartAndWait(ctx context.Context, name string, timeout time.Duration) error {
+	wCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	verb := "start"
+	if IsActive(wCtx, name) {
+		verb = "restart"
+	}
+	if _, err := systemctl(wCtx, verb, name); err != nil {
+		if wCtx.Err() != nil {
+			return fmt.Errorf("timed out after %s waiting for %s to become ready", timeout, name)
+		}
+		return err
+	}
+
+	// systemctl returned, confirm the unit is actually running
+	props, err := Show(ctx, name, "ActiveState", "SubState")
+	if err != nil {
+		return err
+	}
+	if props["ActiveState"] != "active" {
+		return fmt.Errorf("%s is %s (%s) after start, see 'journalctl --user -u %s'", name, props["ActiveState"], props["SubState"], name)
+	}
+	return nil
+}
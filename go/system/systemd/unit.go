@@ -0,0 +1,161 @@
+//go:build linux
+
+// Package systemd renders, installs and manages the application's systemd user unit.
+package systemd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultReadyTimeout = 90 * time.Second
+	DefaultWatchdog     = 60 * time.Second
+)
+
+// Unit describes the service unit to render. See [Render].
+type Unit struct {
+	Name             string        // unit name, e.g. "ssv.service"
+	Description      string        // unit description
+	Exec             string        // absolute path of the binary
+	Args             []string      // args passed to Exec, e.g. ["service", "run"]
+	WorkingDirectory string        // usually the data path
+	EnvironmentFile  string        // optional env file, missing file is not an error
+	ReadyTimeout     time.Duration // TimeoutStartSec, time to wait for READY=1. Default [DefaultReadyTimeout]
+	Watchdog         time.Duration // WatchdogSec, zero or negative disables. Default [DefaultWatchdog]
+}
+
+// UnitName returns the service unit name of an instance, "<app>.service" for the default one
+// and "<app>-<instance>.service" for a named one.
+func UnitName(appName, instance string) string {
+	if instance != "" {
+		return appName + "-" + instance + ".service"
+	}
+	return appName + ".service"
+}
+
+// UnitDir returns the systemd user unit directory, ~/.config/systemd/user (or $XDG_CONFIG_HOME/systemd/user).
+func UnitDir() (string, error) {
+	cfgDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine config dir: %w", err)
+	}
+	return filepath.Join(cfgDir, "systemd", "user"), nil
+}
+
+// UnitPath returns the path of the unit file with the given name.
+func UnitPath(name string) (string, error) {
+	dir, err := UnitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// Render returns the unit file contents for u.
+func Render(u Unit) string {
+	if u.ReadyTimeout == 0 {
+		u.ReadyTimeout = DefaultReadyTimeout
+	}
+	if u.Watchdog == 0 {
+		u.Watchdog = DefaultWatchdog
+	}
+
+	// escape % -> %% so systemd doesn't expand specifiers in paths/args
+	escape := func(s string) string { return strings.ReplaceAll(s, "%", "%%") }
+	execStart := escape(u.Exec)
+	for _, a := range u.Args {
+		execStart += " " + escape(a)
+	}
+
+	var b strings.Builder
+	line := func(format string, a ...any) { fmt.Fprintf(&b, format+"\n", a...) }
+	line("[Unit]")
+	line("Description=%s", u.Description)
+	line("StartLimitIntervalSec=600")
+	line("StartLimitBurst=5")
+	line("# FYI: network-online.target is kinda fucked in the user manager for some reason.")
+	line("# Using in case it works. App will still handle unready net starts gracefully with retries.")
+	line("Wants=network-online.target")
+	line("After=network-online.target")
+	line("")
+	line("[Service]")
+	line("Type=notify")
+	line("ExecStart=%s", execStart)
+	line("ExecReload=/bin/kill -HUP $MAINPID")
+	if u.WorkingDirectory != "" {
+		line("WorkingDirectory=%s", escape(u.WorkingDirectory))
+	}
+	line("Restart=always")
+	line("RestartSec=3")
+	line("LimitNOFILE=65535")
+	line("# keep listening sockets open across restarts (see FDSTORE in the server package)")
+	line("FileDescriptorStoreMax=16")
+	line("FileDescriptorStorePreserve=restart")
+	line("TimeoutStartSec=%ds", int(u.ReadyTimeout.Seconds()))
+	if u.Watchdog > 0 {
+		line("WatchdogSec=%ds", int(u.Watchdog.Seconds()))
+	}
+	line("RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK")
+	line("Environment=PATH=%%h/.local/bin:/usr/local/bin:/usr/bin:/bin")
+	if u.EnvironmentFile != "" {
+		line("EnvironmentFile=-%s", escape(u.EnvironmentFile))
+	}
+	line("")
+	line("[Install]")
+	line("WantedBy=default.target")
+	return b.String()
+}
+
+// Drift compares the installed unit file with the rendered one.
+// installed is false if there is no unit file, drifted is true if it exists and differs.
+func Drift(u Unit) (installed bool, drifted bool, err error) {
+	path, err := UnitPath(u.Name)
+	if err != nil {
+		return false, false, err
+	}
+	current, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, fmt.Errorf("failed to read unit file: %w", err)
+	}
+	return true, !bytes.Equal(current, []byte(Render(u))), nil
+}
+
+// WriteUnit atomically writes the rendered unit file. Call [DaemonReload] afterwards.
+func WriteUnit(u Unit) error {
+	path, err := UnitPath(u.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create unit dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(Render(u)), 0o644); err != nil {
+		return fmt.Errorf("failed to write unit file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write unit file: %w", err)
+	}
+	return nil
+}
+
+// RemoveUnit removes the unit file if present. Call [DaemonReload] afterwards.
+func RemoveUnit(name string) error {
+	path, err := UnitPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove unit file: %w", err)
+	}
+	return nil
+}
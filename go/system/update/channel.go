@@ -0,0 +1,121 @@
+//go:build linux
+
+package update
+
+import (
+	"context"
+	"fmt"
+	"ssv/go/database/config"
+	"strings"
+
+	"github.com/Data-Corruption/stdx/xlog"
+	"golang.org/x/mod/semver"
+)
+
+// Update channels, selected with the "updateChannel" config key.
+const (
+	ChannelStable     = "stable"     // releases without a semver prerelease suffix
+	ChannelPrerelease = "prerelease" // everything, e.g. v1.2.0-rc.1
+)
+
+// Channel returns the configured update channel.
+func Channel(ctx context.Context) (string, error) {
+	channel, err := config.Get[string](ctx, "updateChannel")
+	if err != nil {
+		return "", fmt.Errorf("failed to get updateChannel from config: %w", err)
+	}
+	if err := ValidateChannel(channel); err != nil {
+		return "", err
+	}
+	return channel, nil
+}
+
+func ValidateChannel(channel string) error {
+	switch channel {
+	case ChannelStable, ChannelPrerelease:
+		return nil
+	}
+	return fmt.Errorf("invalid update channel %q, expected %s or %s", channel, ChannelStable, ChannelPrerelease)
+}
+
+// PinnedVersion returns the "pinnedVersion" config value, empty if not pinned.
+func PinnedVersion(ctx context.Context) (string, error) {
+	pinned, err := config.Get[string](ctx, "pinnedVersion")
+	if err != nil {
+		return "", fmt.Errorf("failed to get pinnedVersion from config: %w", err)
+	}
+	return pinned, nil
+}
+
+// NormalizeVersion adds the "v" prefix if missing and checks the result is valid semver.
+func NormalizeVersion(version string) (string, error) {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !semver.IsValid(version) {
+		return "", fmt.Errorf("invalid version %q", version)
+	}
+	return version, nil
+}
+
+// latestRelease returns the newest release tag on channel. It uses the releases listing and falls
+// back to [source.Latest], which only knows about the latest stable release.
+func latestRelease(ctx context.Context, src source, channel string) (string, error) {
+	releases, err := src.Releases(ctx)
+	if err != nil {
+		xlog.Debugf(ctx, "releases listing unavailable, falling back to the latest redirect: %s", err)
+		return src.Latest(ctx)
+	}
+	latest := ""
+	for _, r := range releases {
+		if r.Draft || !semver.IsValid(r.TagName) {
+			continue
+		}
+		if channel == ChannelStable && (r.Prerelease || semver.Prerelease(r.TagName) != "") {
+			continue
+		}
+		if latest == "" || semver.Compare(r.TagName, latest) > 0 {
+			latest = r.TagName
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no %s releases found", channel)
+	}
+	return latest, nil
+}
+
+// findRelease checks version was released. If the releases listing is unavailable it's assumed
+// to exist, the download fails otherwise.
+func findRelease(ctx context.Context, src source, version string) error {
+	releases, err := src.Releases(ctx)
+	if err != nil {
+		xlog.Debugf(ctx, "releases listing unavailable, can't check %s exists: %s", version, err)
+		return nil
+	}
+	for _, r := range releases {
+		if !r.Draft && r.TagName == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("release %s not found", version)
+}
+
+// target returns the version to install: version if given, checked to exist, else the latest
+// release on the configured channel.
+func target(ctx context.Context, src source, version string) (string, error) {
+	if version == "" {
+		channel, err := Channel(ctx)
+		if err != nil {
+			return "", err
+		}
+		return latestRelease(ctx, src, channel)
+	}
+	version, err := NormalizeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	if err := findRelease(ctx, src, version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
@@ -0,0 +1,254 @@
+//go:build linux
+
+package update
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"ssv/go/system/minisign"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// Asset names, same as the install script and build.sh produce.
+const (
+	BinAssetName       = "linux-amd64.gz"
+	BinAssetNameSHA256 = "linux-amd64.gz.sha256"
+	BinAssetNameSig    = "linux-amd64.gz.minisig"
+)
+
+const (
+	downloadTimeout = 5 * time.Minute
+	downloadRetries = 3
+)
+
+// release is a downloaded and verified release binary.
+type release struct {
+	tag    string // version it should be, empty if unknown until verified
+	signed string // version its signature is for, empty with --insecure
+	binGz  string // path of the downloaded asset
+	bin    string // path of the decompressed, executable binary
+	sha256 string // hex digest of binGz
+}
+
+// fetchRelease fetches the release asset for tag, its checksum and signature from src into
+// dir, verifies them and decompresses the binary next to them. insecure skips the signature.
+func fetchRelease(ctx context.Context, src source, tag, dir string, insecure bool, logf func(string, ...any)) (*release, error) {
+	rel := newRelease(tag, dir)
+	logf("Fetching %s %s from %s ...", tag, BinAssetName, src)
+	if err := src.Fetch(ctx, tag, BinAssetName, rel.binGz); err != nil {
+		return nil, fmt.Errorf("download of binary failed: %w", err)
+	}
+	logf("Fetching checksum file ...")
+	if err := src.Fetch(ctx, tag, BinAssetNameSHA256, rel.binGz+".sha256"); err != nil {
+		return nil, fmt.Errorf("download of checksum file failed: %w", err)
+	}
+	if !insecure {
+		logf("Fetching signature ...")
+		if err := src.Fetch(ctx, tag, BinAssetNameSig, rel.binGz+".minisig"); err != nil {
+			return nil, fmt.Errorf("download of signature failed, refusing unsigned update: %w", err)
+		}
+	}
+	return rel, rel.verify(insecure, logf)
+}
+
+// localRelease copies a release asset at path, with its .sha256 and .minisig next to it, into
+// dir, verifies them and decompresses the binary, same as [fetchRelease].
+func localRelease(path, dir string, insecure bool, logf func(string, ...any)) (*release, error) {
+	rel := newRelease("", dir)
+	logf("Copying %s ...", path)
+	if err := copyFile(path, rel.binGz, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to copy binary: %w", err)
+	}
+	if err := copyFile(path+".sha256", rel.binGz+".sha256", 0o600); err != nil {
+		return nil, fmt.Errorf("failed to copy checksum file, expected it next to the binary: %w", err)
+	}
+	if !insecure {
+		if err := copyFile(path+".minisig", rel.binGz+".minisig", 0o600); err != nil {
+			return nil, fmt.Errorf("failed to copy signature, expected it next to the binary, refusing unsigned update: %w", err)
+		}
+	}
+	return rel, rel.verify(insecure, logf)
+}
+
+func newRelease(tag, dir string) *release {
+	return &release{
+		tag:   tag,
+		binGz: filepath.Join(dir, BinAssetName),
+		bin:   filepath.Join(dir, strings.TrimSuffix(BinAssetName, ".gz")),
+	}
+}
+
+// verify checks the checksum and signature (unless insecure) of the asset, then unzips it.
+func (rel *release) verify(insecure bool, logf func(string, ...any)) error {
+	logf("Verifying checksum ...")
+	expected, err := readChecksumFile(rel.binGz + ".sha256")
+	if err != nil {
+		return err
+	}
+	if rel.sha256, err = fileSHA256(rel.binGz); err != nil {
+		return fmt.Errorf("failed to compute checksum: %w", err)
+	}
+	if rel.sha256 != expected {
+		return fmt.Errorf("checksum mismatch! expected %s, got %s", expected, rel.sha256)
+	}
+
+	if insecure {
+		logf("WARNING: skipping signature verification (--insecure)")
+	} else if rel.signed, err = verifySignature(rel.binGz, rel.tag, logf); err != nil {
+		return err
+	}
+
+	logf("Unzipping ...")
+	if err := gunzipFile(rel.binGz, rel.bin, 0o755); err != nil {
+		return fmt.Errorf("failed to unzip: %w", err)
+	}
+	if rel.signed != "" {
+		if v := binaryVersion(rel.bin); v != "" && v != rel.signed {
+			return fmt.Errorf("refusing update, the binary is %s but its signature is for %s", v, rel.signed)
+		}
+		if rel.tag == "" {
+			rel.tag = rel.signed
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the minisign signature at path+".minisig" against [PublicKey] and
+// that its trusted comment, "<name> <version> <platform>" as build.sh signs, is for this asset
+// and for version tag, if given. It returns the signed version, so an older signed release
+// can't be passed off as another.
+func verifySignature(path, tag string, logf func(string, ...any)) (string, error) {
+	if PublicKey == "" {
+		return "", fmt.Errorf("this build has no update signing key, use --insecure to update without signature verification")
+	}
+	pk, err := minisign.ParsePublicKey(PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse update signing key: %w", err)
+	}
+	logf("Verifying signature ...")
+	sig, err := pk.VerifyFile(path, path+".minisig")
+	if err != nil {
+		return "", fmt.Errorf("refusing update, invalid signature: %w", err)
+	}
+	fields := strings.Fields(sig.TrustedComment)
+	platform := strings.TrimSuffix(BinAssetName, ".gz")
+	if len(fields) != 3 || !semver.IsValid(fields[1]) || fields[2] != platform {
+		return "", fmt.Errorf("refusing update, the signature's trusted comment %q isn't for a %s release", sig.TrustedComment, platform)
+	}
+	if tag != "" && fields[1] != tag {
+		return "", fmt.Errorf("refusing update, the signature is for %s, not %s", fields[1], tag)
+	}
+	logf("Signature verified, key ID %s (%s)", sig.KeyID, sig.TrustedComment)
+	return fields[1], nil
+}
+
+// download fetches url into path, retrying transient failures.
+func download(ctx context.Context, url, path string) error {
+	var lastErr error
+	for attempt := 0; attempt <= downloadRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		if lastErr = downloadOnce(ctx, url, path); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func downloadOnce(ctx context.Context, url, path string) error {
+	dCtx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(dCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readChecksumFile returns the hash from a `sha256sum` style file ("<hex>  <name>").
+func readChecksumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields[0]) != 64 {
+		return "", fmt.Errorf("invalid checksum format")
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", fmt.Errorf("invalid checksum format: %w", err)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func gunzipFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, zr); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,287 @@
+//go:build linux
+
+package update
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"ssv/go/system/git"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// signer makes minisign keys and prehashed signatures, the way `minisign -S` does.
+type signer struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+func newSigner(t *testing.T, id byte) *signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signer{id: [8]byte{id, 1, 2, 3, 4, 5, 6, 7}, key: key}
+}
+
+// publicKey is the base64 line of the minisign.pub file.
+func (s *signer) publicKey() string {
+	raw := append([]byte("Ed"), s.id[:]...)
+	return base64.StdEncoding.EncodeToString(append(raw, s.key.Public().(ed25519.PublicKey)...))
+}
+
+// sign returns the .minisig file of msg with trustedComment.
+func (s *signer) sign(msg []byte, trustedComment string) []byte {
+	h := blake2b.Sum512(msg)
+	sig := ed25519.Sign(s.key, h[:])
+	global := ed25519.Sign(s.key, append(bytes.Clone(sig), trustedComment...))
+	raw := append(append([]byte("ED"), s.id[:]...), sig...)
+	return fmt.Appendf(nil, "untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), trustedComment, base64.StdEncoding.EncodeToString(global))
+}
+
+// fakeBinary is a release binary that prints version, like `ssv -v`.
+func fakeBinary(version string) []byte {
+	return []byte("#!/bin/sh\necho 'ssv " + version + "'\n")
+}
+
+// releaseServer serves releases with GitHub's layout, see [Source].
+type releaseServer struct {
+	*httptest.Server
+	releases []git.Release
+	assets   map[string][]byte // "<tag>/<asset>"
+}
+
+func newReleaseServer(t *testing.T) *releaseServer {
+	rs := &releaseServer{assets: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /releases.json", func(w http.ResponseWriter, r *http.Request) {
+		if rs.releases == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(rs.releases)
+	})
+	mux.HandleFunc("GET /latest", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/tag/v1.1.0", http.StatusFound)
+	})
+	mux.HandleFunc("GET /download/{tag}/{asset}", func(w http.ResponseWriter, r *http.Request) {
+		b, ok := rs.assets[r.PathValue("tag")+"/"+r.PathValue("asset")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	})
+	rs.Server = httptest.NewServer(mux)
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+// publish adds the release assets of tag: bin gzipped, its checksum and, unless s is nil, its
+// signature with trustedComment.
+func (rs *releaseServer) publish(t *testing.T, tag string, bin []byte, s *signer, trustedComment string) {
+	t.Helper()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(bin)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(gz.Bytes())
+	rs.assets[tag+"/"+BinAssetName] = gz.Bytes()
+	rs.assets[tag+"/"+BinAssetNameSHA256] = []byte(hex.EncodeToString(sum[:]) + "  " + BinAssetName + "\n")
+	if s != nil {
+		rs.assets[tag+"/"+BinAssetNameSig] = s.sign(gz.Bytes(), trustedComment)
+	}
+}
+
+func setPublicKey(t *testing.T, key string) {
+	prev := PublicKey
+	PublicKey = key
+	t.Cleanup(func() { PublicKey = prev })
+}
+
+func TestUpdateFromServer(t *testing.T) {
+	ctx := context.Background()
+	key := newSigner(t, 1)
+	setPublicKey(t, key.publicKey())
+	rs := newReleaseServer(t)
+	rs.releases = []git.Release{{TagName: "v1.0.0"}, {TagName: "v1.1.0"}, {TagName: "v1.2.0-rc.1", Prerelease: true}}
+	for _, r := range rs.releases {
+		rs.publish(t, r.TagName, fakeBinary(r.TagName), key, "ssv "+r.TagName+" linux-amd64")
+	}
+	src, err := parseSource(rs.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resolve
+	for channel, want := range map[string]string{ChannelStable: "v1.1.0", ChannelPrerelease: "v1.2.0-rc.1"} {
+		if got, err := latestRelease(ctx, src, channel); err != nil || got != want {
+			t.Fatalf("latest %s release: got %q, %v, want %s", channel, got, err, want)
+		}
+	}
+	if err := findRelease(ctx, src, "v9.9.9"); err == nil {
+		t.Fatal("found a release that isn't listed")
+	}
+
+	// download and verify, next to the binary it replaces
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "ssv")
+	if err := os.WriteFile(exe, fakeBinary("v1.0.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := os.MkdirTemp(dir, ".ssv-update-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := fetchRelease(ctx, src, "v1.1.0", tmp, false, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.signed != "v1.1.0" || rel.tag != "v1.1.0" {
+		t.Fatalf("release signed for %q, tagged %q, want v1.1.0", rel.signed, rel.tag)
+	}
+
+	// swap, then roll back
+	if err := swap(exe, rel.bin); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := verifyBinary(ctx, exe); err != nil || v != "ssv v1.1.0" {
+		t.Fatalf("installed binary reports %q, %v", v, err)
+	}
+	if v, err := verifyBinary(ctx, previousPath(exe)); err != nil || v != "ssv v1.0.0" {
+		t.Fatalf("previous binary reports %q, %v", v, err)
+	}
+	if err := restorePrevious(exe); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := verifyBinary(ctx, exe); err != nil || v != "ssv v1.0.0" {
+		t.Fatalf("restored binary reports %q, %v", v, err)
+	}
+}
+
+func TestLatestWithoutListing(t *testing.T) {
+	src, err := parseSource(newReleaseServer(t).URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := latestRelease(context.Background(), src, ChannelStable); err != nil || got != "v1.1.0" {
+		t.Fatalf("got %q, %v, want v1.1.0 from the latest redirect", got, err)
+	}
+}
+
+func TestFetchReleaseRejects(t *testing.T) {
+	key := newSigner(t, 1)
+	setPublicKey(t, key.publicKey())
+	bin := fakeBinary("v1.1.0")
+	for _, tc := range []struct {
+		name    string
+		publish func(t *testing.T, rs *releaseServer)
+		want    string
+	}{
+		{"other key", func(t *testing.T, rs *releaseServer) {
+			rs.publish(t, "v1.1.0", bin, newSigner(t, 2), "ssv v1.1.0 linux-amd64")
+		}, "different key"},
+		{"replayed release", func(t *testing.T, rs *releaseServer) {
+			// an older signed release served as the requested one
+			rs.publish(t, "v1.1.0", fakeBinary("v1.0.0"), key, "ssv v1.0.0 linux-amd64")
+		}, "the signature is for v1.0.0, not v1.1.0"},
+		{"other platform", func(t *testing.T, rs *releaseServer) {
+			rs.publish(t, "v1.1.0", bin, key, "ssv v1.1.0 linux-arm64")
+		}, "isn't for a linux-amd64 release"},
+		{"no version", func(t *testing.T, rs *releaseServer) {
+			rs.publish(t, "v1.1.0", bin, key, "timestamp:1700000000 file:linux-amd64.gz")
+		}, "isn't for a linux-amd64 release"},
+		{"tampered binary", func(t *testing.T, rs *releaseServer) {
+			rs.publish(t, "v1.1.0", bin, key, "ssv v1.1.0 linux-amd64")
+			sig := rs.assets["v1.1.0/"+BinAssetNameSig]
+			rs.publish(t, "v1.1.0", fakeBinary("v6.6.6"), key, "ssv v1.1.0 linux-amd64")
+			rs.assets["v1.1.0/"+BinAssetNameSig] = sig
+		}, "invalid signature"},
+		{"tampered trusted comment", func(t *testing.T, rs *releaseServer) {
+			rs.publish(t, "v1.1.0", bin, key, "ssv v1.0.0 linux-amd64")
+			sig := rs.assets["v1.1.0/"+BinAssetNameSig]
+			rs.assets["v1.1.0/"+BinAssetNameSig] = bytes.Replace(sig, []byte("ssv v1.0.0"), []byte("ssv v1.1.0"), 1)
+		}, "trusted comment"},
+		{"checksum mismatch", func(t *testing.T, rs *releaseServer) {
+			rs.publish(t, "v1.1.0", bin, key, "ssv v1.1.0 linux-amd64")
+			rs.assets["v1.1.0/"+BinAssetNameSHA256] = []byte(strings.Repeat("0", 64) + "  " + BinAssetName + "\n")
+		}, "checksum mismatch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs := newReleaseServer(t)
+			tc.publish(t, rs)
+			_, err := fetchRelease(context.Background(), httpSource(rs.URL), "v1.1.0", t.TempDir(), false, t.Logf)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestDirSource fetches from a local copy of the releases, the one an unsigned release is
+// refused from: a 404 from a server is retried.
+func TestDirSource(t *testing.T) {
+	key := newSigner(t, 1)
+	setPublicKey(t, key.publicKey())
+	rs := newReleaseServer(t)
+	rs.publish(t, "v1.1.0", fakeBinary("v1.1.0"), key, "ssv v1.1.0 linux-amd64")
+	rs.publish(t, "v1.2.0", fakeBinary("v1.2.0"), nil, "")
+	root := t.TempDir()
+	for name, b := range rs.assets {
+		path := filepath.Join(root, "download", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := parseSource("file://" + root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := latestRelease(context.Background(), src, ChannelStable); err != nil || got != "v1.2.0" {
+		t.Fatalf("got %q, %v, want v1.2.0 from the tag dirs", got, err)
+	}
+	if _, err := fetchRelease(context.Background(), src, "v1.1.0", t.TempDir(), false, t.Logf); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fetchRelease(context.Background(), src, "v1.2.0", t.TempDir(), false, t.Logf)
+	if err == nil || !strings.Contains(err.Error(), "refusing unsigned update") {
+		t.Fatalf("got %v, want an unsigned release refused", err)
+	}
+}
+
+func TestFetchReleaseWithoutKey(t *testing.T) {
+	setPublicKey(t, "")
+	rs := newReleaseServer(t)
+	rs.publish(t, "v1.1.0", fakeBinary("v1.1.0"), newSigner(t, 1), "ssv v1.1.0 linux-amd64")
+	if _, err := fetchRelease(context.Background(), httpSource(rs.URL), "v1.1.0", t.TempDir(), false, t.Logf); err == nil {
+		t.Fatal("verified a release without a signing key")
+	}
+	rel, err := fetchRelease(context.Background(), httpSource(rs.URL), "v1.1.0", t.TempDir(), true, t.Logf)
+	if err != nil {
+		t.Fatalf("insecure update: %s", err)
+	}
+	if rel.signed != "" {
+		t.Fatalf("insecure release signed for %q", rel.signed)
+	}
+}
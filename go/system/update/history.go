@@ -0,0 +1,263 @@
+//go:build linux
+
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"ssv/go/app"
+	"ssv/go/database"
+	"ssv/go/database/config"
+	"ssv/go/database/datapath"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+const historyDirName = "versions" // under the data dir, one sub dir per kept version
+
+// HistoryEntry is a replaced binary kept for rollback, see [Rollback].
+type HistoryEntry struct {
+	Version       string    `json:"version"`
+	ConfigVersion string    `json:"configVersion"` // config schema version the binary reads
+	SavedAt       time.Time `json:"savedAt"`
+	Snapshot      bool      `json:"snapshot"` // db snapshot taken when leaving this version
+
+	dir string
+}
+
+func (e *HistoryEntry) binPath() string      { return filepath.Join(e.dir, "bin") }
+func (e *HistoryEntry) snapshotPath() string { return filepath.Join(e.dir, "db") }
+
+func historyDir(ctx context.Context) string {
+	return filepath.Join(datapath.FromContext(ctx), historyDirName)
+}
+
+// History returns the kept binaries, newest first.
+func History(ctx context.Context) ([]HistoryEntry, error) {
+	dirs, err := os.ReadDir(historyDir(ctx))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version history: %w", err)
+	}
+	var entries []HistoryEntry
+	for _, d := range dirs {
+		dir := filepath.Join(historyDir(ctx), d.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+		if err != nil {
+			xlog.Warnf(ctx, "skipping version history entry %s: %s", dir, err)
+			continue
+		}
+		e := HistoryEntry{dir: dir}
+		if err := json.Unmarshal(data, &e); err != nil {
+			xlog.Warnf(ctx, "skipping version history entry %s: %s", dir, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b HistoryEntry) int { return b.SavedAt.Compare(a.SavedAt) })
+	return entries, nil
+}
+
+// saveCurrent keeps the running binary exe, its config schema version and a snapshot of the
+// database in the version history, then prunes the history to "updateKeepVersions" entries.
+func saveCurrent(ctx context.Context, exe string) (*HistoryEntry, error) {
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("failed to get appData from context")
+	}
+	e := &HistoryEntry{
+		Version:       appData.Version,
+		ConfigVersion: config.Version,
+		SavedAt:       time.Now().UTC(),
+		dir:           filepath.Join(historyDir(ctx), appData.Version),
+	}
+	if err := os.RemoveAll(e.dir); err != nil {
+		return nil, fmt.Errorf("failed to clear %s: %w", e.dir, err)
+	}
+	if err := os.MkdirAll(e.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", e.dir, err)
+	}
+	if err := copyFile(exe, e.binPath(), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to copy binary: %w", err)
+	}
+	if db := database.FromContext(ctx); db != nil {
+		if err := database.Snapshot(db, e.snapshotPath()); err != nil {
+			return nil, fmt.Errorf("failed to snapshot database: %w", err)
+		}
+		e.Snapshot = true
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(e.dir, "meta.json"), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write version metadata: %w", err)
+	}
+	return e, pruneHistory(ctx)
+}
+
+func pruneHistory(ctx context.Context) error {
+	keep, err := config.Get[int](ctx, "updateKeepVersions")
+	if err != nil {
+		return fmt.Errorf("failed to get updateKeepVersions from config: %w", err)
+	}
+	entries, err := History(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries[min(max(keep, 1), len(entries)):] {
+		if err := os.RemoveAll(e.dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", e.dir, err)
+		}
+	}
+	return nil
+}
+
+// Rollback reinstalls a binary from the version history, version if given or else the most
+// recent one that isn't the running version, and restarts the service if it's active.
+//
+// If that binary reads a different config schema than the database is on, confirm is asked
+// whether to restore the database snapshot taken when leaving it. Without a snapshot, or
+// if confirm is nil, the rollback is refused.
+func Rollback(ctx context.Context, version string, confirm func(prompt string) (bool, error)) error {
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("failed to get appData from context")
+	}
+	entries, err := History(ctx)
+	if err != nil {
+		return err
+	}
+	if version != "" {
+		if version, err = NormalizeVersion(version); err != nil {
+			return err
+		}
+	}
+	var e *HistoryEntry
+	for i := range entries {
+		if (version == "" && entries[i].Version != appData.Version) || entries[i].Version == version {
+			e = &entries[i]
+			break
+		}
+	}
+	if e == nil {
+		var kept []string
+		for _, e := range entries {
+			kept = append(kept, e.Version)
+		}
+		if len(kept) == 0 {
+			return fmt.Errorf("no previous versions kept, nothing to roll back to")
+		}
+		return fmt.Errorf("version not kept, available: %s", strings.Join(kept, ", "))
+	}
+	if e.Version == appData.Version {
+		return fmt.Errorf("already on %s", e.Version)
+	}
+
+	restoreDB := false
+	if e.ConfigVersion != config.Version {
+		if !e.Snapshot || confirm == nil {
+			return fmt.Errorf("refusing to roll back, %s reads config schema %s but the database is on %s", e.Version, e.ConfigVersion, config.Version)
+		}
+		fmt.Printf("%s reads config schema %s but the database is on %s.\n", e.Version, e.ConfigVersion, config.Version)
+		ok, err := confirm(fmt.Sprintf("Restore the database snapshot taken when leaving %s on %s? Changes made since are lost.", e.Version, e.SavedAt.Local().Format(time.DateTime)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("aborted, rollback to %s declined", e.Version)
+		}
+		restoreDB = true
+	}
+
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	exe, err := executable()
+	if err != nil {
+		return err
+	}
+	// copied before saving the current version, whose pruning may remove e
+	tmpDir, err := os.MkdirTemp(filepath.Dir(exe), "."+filepath.Base(exe)+"-rollback-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	bin, snapshot := filepath.Join(tmpDir, "bin"), filepath.Join(tmpDir, "db")
+	if err := copyFile(e.binPath(), bin, 0o755); err != nil {
+		return fmt.Errorf("failed to copy %s binary: %w", e.Version, err)
+	}
+	if restoreDB {
+		if err := copySnapshot(e.snapshotPath(), snapshot); err != nil {
+			return fmt.Errorf("failed to copy %s database snapshot: %w", e.Version, err)
+		}
+	}
+	fmt.Printf("Keeping %s for roll forward ...\n", appData.Version)
+	current, err := saveCurrent(ctx, exe)
+	if err != nil {
+		return err
+	}
+
+	db := database.FromContext(ctx)
+	if restoreDB {
+		fmt.Println("Restoring database snapshot ...")
+		if err := database.Restore(db, snapshot); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Writing to %s ...\n", exe)
+	if err := swap(exe, bin); err != nil {
+		return err
+	}
+	fmt.Println("Verifying installation ...")
+	installed, err := verifyBinary(ctx, exe)
+	if err != nil {
+		fmt.Println("Restoring previous installation ...")
+		if rErr := restorePrevious(exe); rErr != nil {
+			return fmt.Errorf("%w, and restoring the binary failed: %w", err, rErr)
+		}
+		if restoreDB {
+			if rErr := database.Restore(db, current.snapshotPath()); rErr != nil {
+				return fmt.Errorf("%w, and restoring the database failed: %w", err, rErr)
+			}
+		}
+		return err
+	}
+
+	if err := restart(ctx, exe, false, os.Stdout); err != nil {
+		return err
+	}
+	fmt.Printf("Rolled back: (%s)\n", installed)
+	fmt.Printf("Run '%s update pin %s' to stay on it.\n", command(ctx), e.Version)
+	return nil
+}
+
+// copySnapshot copies the database snapshot at src, see [database.Snapshot], to dst. Its lock
+// file is left out, LMDB recreates it.
+func copySnapshot(src, dst string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	files, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Name() == "lock.mdb" || !f.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, f.Name()), filepath.Join(dst, f.Name()), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
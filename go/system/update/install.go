@@ -0,0 +1,153 @@
+//go:build linux
+
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ssv/go/app"
+	"ssv/go/database/datapath"
+	"ssv/go/server"
+	"ssv/go/system/instance"
+	"ssv/go/system/sdnotify"
+	"ssv/go/system/systemd"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// verifyTimeout bounds the first run of the new binary, which may be migrating the config.
+const verifyTimeout = 10 * time.Minute
+
+// executable returns the resolved path of the running binary.
+func executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", fmt.Errorf("failed to resolve executable path: %w", err)
+	}
+	return exe, nil
+}
+
+// previousPath is where the binary replaced by the last update is kept.
+func previousPath(exe string) string {
+	return exe + ".old"
+}
+
+// swap atomically replaces exe with newBin, keeping the current binary at [previousPath].
+// newBin must be on the same filesystem as exe.
+func swap(exe, newBin string) error {
+	prev := previousPath(exe)
+	if err := os.Remove(prev); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove old backup: %w", err)
+	}
+	// hard link keeps the running binary's inode intact, fall back to a copy
+	if err := os.Link(exe, prev); err != nil {
+		if err := copyFile(exe, prev, 0o755); err != nil {
+			return fmt.Errorf("failed to backup current binary: %w", err)
+		}
+	}
+	if err := os.Rename(newBin, exe); err != nil {
+		return fmt.Errorf("failed to replace binary: %w", err)
+	}
+	return nil
+}
+
+// restorePrevious puts the binary saved by [swap] back in place.
+func restorePrevious(exe string) error {
+	if err := os.Rename(previousPath(exe), exe); err != nil {
+		return fmt.Errorf("failed to restore previous binary: %w", err)
+	}
+	return nil
+}
+
+// verifyBinary runs `exe -v` and returns the first line of output, the version.
+// This is also the new binary's first run, so it performs any config migration.
+func verifyBinary(ctx context.Context, exe string) (string, error) {
+	vCtx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+	args := append(datapath.DirsFromContext(ctx).Args(), "-v")
+	out, err := exec.CommandContext(vCtx, exe, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s -v exited with an error: %w", exe, err)
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if version == "" {
+		return "", fmt.Errorf("failed to get effective version")
+	}
+	return version, nil
+}
+
+// restart gets the new binary running in place of the service, if there is one.
+//
+//   - inside the daemon under systemd: re-installs the unit from the new binary detached, which
+//     restarts us. The fd store keeps the listening sockets open meanwhile.
+//   - inside an unmanaged daemon: hands our listeners to the new binary, see [server.Server.Handoff].
+//   - from the CLI: re-installs the unit if the service is active, blocking until it's ready.
+//     If the daemon runs unmanaged it only says so.
+func restart(ctx context.Context, exe string, detach bool, out io.Writer) error {
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("failed to get appData from context")
+	}
+	dirs := datapath.DirsFromContext(ctx)
+	unitName := systemd.UnitName(appData.Name, dirs.Options.Instance)
+	args := append(dirs.Args(), "--yes", "service", "install")
+
+	if detach {
+		if sdnotify.Enabled() {
+			cmd := exec.Command(exe, args...)
+			cmd.Stdout, cmd.Stderr = out, out
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+			return cmd.Start()
+		}
+		if srv := server.FromContext(ctx); srv != nil {
+			return srv.Handoff(exe, os.Args[1:])
+		}
+		return nil
+	}
+
+	if !systemd.IsActive(ctx, unitName) {
+		if info, running, _ := instance.Running(ctx); running {
+			fmt.Fprintf(out, "The service (pid %d) isn't managed by systemd, restart it to run the new version.\n", info.PID)
+		}
+		return nil
+	}
+	fmt.Fprintln(out, "Updating service ...")
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to restart service: %w", err)
+	}
+	return nil
+}
+
+// command returns how to invoke this instance, for hints, e.g. "ssv --instance staging".
+func command(ctx context.Context) string {
+	appData, _ := app.FromContext(ctx)
+	return strings.Join(append([]string{appData.Name}, datapath.DirsFromContext(ctx).Args()...), " ")
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
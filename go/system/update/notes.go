@@ -0,0 +1,221 @@
+//go:build linux
+
+package update
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"ssv"
+	"ssv/go/app"
+	"ssv/go/database/config"
+	"ssv/go/database/datapath"
+	"strings"
+
+	"github.com/Data-Corruption/stdx/xlog"
+	"golang.org/x/mod/semver"
+	"golang.org/x/sys/unix"
+)
+
+const notesFileName = "release_notes.md" // in the cache dir, notes fetched by the last update check
+
+// Notes is the release notes of one version.
+type Notes struct {
+	Version string
+	Date    string
+	Body    string
+}
+
+// releaseNotes returns the notes of every release after current up to and including latest,
+// newest first. Prereleases are skipped unless latest is one.
+func releaseNotes(ctx context.Context, src source, current, latest string) ([]Notes, error) {
+	releases, err := src.Releases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release notes: %w", err)
+	}
+	var notes []Notes
+	for _, r := range releases {
+		if r.Draft || !semver.IsValid(r.TagName) {
+			continue
+		}
+		if semver.Compare(r.TagName, current) <= 0 || semver.Compare(r.TagName, latest) > 0 {
+			continue
+		}
+		if r.TagName != latest && (r.Prerelease || semver.Prerelease(r.TagName) != "") && semver.Prerelease(latest) == "" {
+			continue
+		}
+		n := Notes{Version: r.TagName, Body: strings.TrimSpace(r.Body)}
+		if !r.PublishedAt.IsZero() {
+			n.Date = r.PublishedAt.Format("2006-01-02")
+		}
+		notes = append(notes, n)
+	}
+	slices.SortFunc(notes, func(a, b Notes) int { return semver.Compare(b.Version, a.Version) })
+	return notes, nil
+}
+
+// Changelog writes the release notes between the running version and the version Update
+// would install (to if given) to w.
+func Changelog(ctx context.Context, to string, w io.Writer) error {
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("failed to get appData from context")
+	}
+	src, err := Source(ctx)
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		if to, err = PinnedVersion(ctx); err != nil {
+			return err
+		}
+	}
+	latest, err := target(ctx, src, to)
+	if err != nil {
+		return err
+	}
+	if semver.Compare(latest, appData.Version) <= 0 {
+		fmt.Fprintf(w, "No newer version than %s.\n", appData.Version)
+		return nil
+	}
+	notes, err := releaseNotes(ctx, src, appData.Version, latest)
+	if err != nil {
+		return err
+	}
+	RenderNotes(w, notes, isTerminal(w))
+	return nil
+}
+
+// saveNotes fetches the notes up to latest for the update notice, see [StartupCheck].
+func saveNotes(ctx context.Context, src source, current, latest string) error {
+	notes, err := releaseNotes(ctx, src, current, latest)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, n := range notes {
+		fmt.Fprintf(&b, "## [%s]", n.Version)
+		if n.Date != "" {
+			fmt.Fprintf(&b, " - %s", n.Date)
+		}
+		fmt.Fprintf(&b, "\n\n%s\n\n", n.Body)
+	}
+	return os.WriteFile(filepath.Join(datapath.DirsFromContext(ctx).Cache, notesFileName), []byte(b.String()), 0o644)
+}
+
+// loadNotes reads the notes saved by the last update check.
+func loadNotes(ctx context.Context) ([]Notes, error) {
+	data, err := os.ReadFile(filepath.Join(datapath.DirsFromContext(ctx).Cache, notesFileName))
+	if err != nil {
+		return nil, err
+	}
+	return ParseChangelog(string(data)), nil
+}
+
+var (
+	headingRe = regexp.MustCompile(`^## \[([^\]]+)\](?:\s+-\s*(.*))?$`)
+	codeRe    = regexp.MustCompile("`([^`]+)`")
+)
+
+// ParseChangelog splits a CHANGELOG.md into its "## [version] - date" sections.
+func ParseChangelog(md string) []Notes {
+	var notes []Notes
+	var body []string
+	flush := func() {
+		if len(notes) > 0 {
+			notes[len(notes)-1].Body = strings.TrimSpace(strings.Join(body, "\n"))
+		}
+		body = body[:0]
+	}
+	for _, line := range strings.Split(md, "\n") {
+		if m := headingRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			flush()
+			notes = append(notes, Notes{Version: m[1], Date: m[2]})
+			continue
+		}
+		body = append(body, line)
+	}
+	flush()
+	return notes
+}
+
+// WhatsNew prints, once after an update, the sections of the embedded CHANGELOG.md that are
+// newer than the version that ran before. Only on a terminal, the updater's own runs of the
+// new binary don't count.
+func WhatsNew(ctx context.Context) {
+	appData, ok := app.FromContext(ctx)
+	if !ok || appData.Version == "vX.X.X" || checkSuppressed() || !isTerminal(os.Stdout) {
+		return
+	}
+	last, err := config.Get[string](ctx, "lastRunVersion")
+	if err != nil {
+		xlog.Warnf(ctx, "failed to get lastRunVersion from config: %s", err)
+		return
+	}
+	if last == appData.Version {
+		return
+	}
+	if err := config.Set(ctx, "lastRunVersion", appData.Version); err != nil {
+		xlog.Warnf(ctx, "failed to set lastRunVersion in config: %s", err)
+		return
+	}
+	if last == "" || semver.Compare(appData.Version, last) <= 0 {
+		return // fresh install or rollback
+	}
+	var notes []Notes
+	for _, n := range ParseChangelog(ssv.Changelog) {
+		if semver.IsValid(n.Version) && semver.Compare(n.Version, last) > 0 && semver.Compare(n.Version, appData.Version) <= 0 {
+			notes = append(notes, n)
+		}
+	}
+	if len(notes) == 0 {
+		return
+	}
+	fmt.Printf("What's new since %s:\n\n", last)
+	RenderNotes(os.Stdout, notes, true)
+}
+
+// RenderNotes writes notes as plain text, with ANSI styling if color is set.
+func RenderNotes(w io.Writer, notes []Notes, color bool) {
+	style := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return "\x1b[" + code + "m" + s + "\x1b[0m"
+	}
+	for _, n := range notes {
+		heading := n.Version
+		if n.Date != "" {
+			heading += " (" + n.Date + ")"
+		}
+		fmt.Fprintln(w, style("1;4", heading))
+		for _, line := range strings.Split(n.Body, "\n") {
+			line = strings.TrimRight(line, " \t")
+			switch {
+			case line == "":
+				continue
+			case strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* "):
+				line = "  • " + line[2:]
+			case strings.HasPrefix(line, "#"):
+				line = style("1", strings.TrimSpace(strings.TrimLeft(line, "#")))
+			case !strings.Contains(line, " "):
+				line = style("1", line) // "Added", "Removed", ...
+			}
+			fmt.Fprintln(w, codeRe.ReplaceAllStringFunc(line, func(s string) string { return style("36", s[1:len(s)-1]) }))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
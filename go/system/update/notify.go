@@ -0,0 +1,99 @@
+//go:build linux
+
+package update
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"ssv/go/app"
+	"ssv/go/database/config"
+	"ssv/go/database/datapath"
+	"syscall"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+const (
+	NoUpdateCheckEnv = "SSV_NO_UPDATE_CHECK" // set to anything to disable the startup check and notice
+	CheckInterval    = 24 * time.Hour        // how often the startup check hits the network
+)
+
+// checkSuppressed reports whether the environment disables update checks, e.g. in CI.
+func checkSuppressed() bool {
+	return os.Getenv(NoUpdateCheckEnv) != "" || os.Getenv("CI") != ""
+}
+
+// StartupCheck prints the update notice from the result of the last check and, once every
+// [CheckInterval], starts a detached `update check` process to refresh it. It never blocks on
+// the network, failures are only logged.
+func StartupCheck(ctx context.Context) {
+	if checkSuppressed() {
+		return
+	}
+	updateNotify, err := config.Get[bool](ctx, "updateNotify")
+	if err != nil {
+		xlog.Warnf(ctx, "failed to get updateNotify from config: %s", err)
+		return
+	}
+	if !updateNotify {
+		return
+	}
+
+	if available, _ := config.Get[bool](ctx, "updateAvailable"); available {
+		notice(ctx)
+	}
+
+	tStr, err := config.Get[string](ctx, "lastUpdateCheck")
+	if err != nil {
+		xlog.Warnf(ctx, "failed to get lastUpdateCheck from config: %s", err)
+		return
+	}
+	if t, err := time.Parse(time.RFC3339, tStr); err == nil && time.Since(t) < CheckInterval {
+		return
+	}
+	// set before starting so concurrent invocations don't all spawn a check
+	if err := config.Set(ctx, "lastUpdateCheck", time.Now().Format(time.RFC3339)); err != nil {
+		xlog.Warnf(ctx, "failed to set lastUpdateCheck in config: %s", err)
+		return
+	}
+	if err := startBackgroundCheck(ctx); err != nil {
+		xlog.Warnf(ctx, "failed to start background update check: %s", err)
+	}
+}
+
+// startBackgroundCheck runs `<exe> update check --quiet` detached, it outlives this process.
+func startBackgroundCheck(ctx context.Context) error {
+	exe, err := executable()
+	if err != nil {
+		return err
+	}
+	args := append(datapath.DirsFromContext(ctx).Args(), "--log", "error", "update", "check", "--quiet")
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), NoUpdateCheckEnv+"=1") // no recursion / notice in the child
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait() // reap it if we're still around, e.g. in the daemon
+	return nil
+}
+
+// notice prints the update available line, preceded by the release notes the first time a
+// version is announced.
+func notice(ctx context.Context) {
+	appData, _ := app.FromContext(ctx)
+	latest, _ := config.Get[string](ctx, "latestVersion")
+	if shown, _ := config.Get[string](ctx, "notesShownFor"); shown != latest && isTerminal(os.Stderr) {
+		if notes, err := loadNotes(ctx); err == nil && len(notes) > 0 {
+			fmt.Fprintf(os.Stderr, "%s %s is available, release notes:\n\n", appData.Name, latest)
+			RenderNotes(os.Stderr, notes, true)
+		}
+		if err := config.Set(ctx, "notesShownFor", latest); err != nil {
+			xlog.Warnf(ctx, "failed to set notesShownFor in config: %s", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Update available (%s)! Run '%s update' to update, '%s update --changelog' for the release notes.\n", latest, appData.Name, appData.Name)
+}
@@ -0,0 +1,236 @@
+//go:build linux
+
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"ssv/go/app"
+	"ssv/go/database/config"
+	"ssv/go/database/datapath"
+	"ssv/go/services/email"
+	"ssv/go/services/users"
+	"ssv/go/x/cron"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	scheduleTick  = time.Minute      // how often the scheduler looks at the window config
+	drainPollTick = 10 * time.Second // how often running jobs are counted while draining
+)
+
+// Window is the auto-update config, see the "autoUpdate*" config keys.
+type Window struct {
+	Enabled      bool
+	Schedule     *cron.Schedule
+	Length       time.Duration
+	DrainTimeout time.Duration
+}
+
+// LoadWindow reads the auto-update config.
+func LoadWindow(ctx context.Context) (Window, error) {
+	var w Window
+	var err error
+	if w.Enabled, err = config.Get[bool](ctx, "autoUpdate"); err != nil {
+		return w, fmt.Errorf("failed to get autoUpdate from config: %w", err)
+	}
+	expr, err := config.Get[string](ctx, "autoUpdateWindow")
+	if err != nil {
+		return w, fmt.Errorf("failed to get autoUpdateWindow from config: %w", err)
+	}
+	if w.Schedule, err = cron.Parse(expr); err != nil {
+		return w, err
+	}
+	if w.Length, err = durationKey(ctx, "autoUpdateWindowLength"); err != nil {
+		return w, err
+	}
+	if w.DrainTimeout, err = durationKey(ctx, "autoUpdateDrainTimeout"); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+func durationKey(ctx context.Context, key string) (time.Duration, error) {
+	s, err := config.Get[string](ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s from config: %w", key, err)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, s, err)
+	}
+	return d, nil
+}
+
+// Current returns the start of the window t falls in, false if it's outside of any window.
+func (w Window) Current(t time.Time) (time.Time, bool) {
+	start := w.Schedule.Prev(t, w.Length)
+	return start, !start.IsZero() && t.Sub(start) < w.Length
+}
+
+// Jobs connect the auto-update to the daemon's jobs. Nil hooks are skipped.
+type Jobs struct {
+	Active func() int // running jobs, waited for before updating
+	Pause  func()     // stops starting queued jobs while draining
+	Resume func()
+}
+
+// AutoUpdate runs until ctx is done, applying updates inside the configured maintenance window
+// when "autoUpdate" is enabled. Before updating it pauses jobs and waits for the running ones to
+// finish, or for the drain timeout. Outcomes go to update.log in the state dir and are emailed to
+// admins. Config changes are picked up without a restart.
+func AutoUpdate(ctx context.Context, jobs Jobs) {
+	var lastWindow time.Time
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w, err := LoadWindow(ctx)
+			if err != nil {
+				xlog.Errorf(ctx, "auto-update: %s", err)
+				continue
+			}
+			if !w.Enabled {
+				continue
+			}
+			start, ok := w.Current(now)
+			if !ok || start.Equal(lastWindow) {
+				continue
+			}
+			lastWindow = start // one attempt per window
+			if err := autoUpdate(ctx, w, jobs); err != nil {
+				xlog.Errorf(ctx, "auto-update: %s", err)
+			}
+		}
+	}
+}
+
+func autoUpdate(ctx context.Context, w Window, jobs Jobs) error {
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("failed to get appData from context")
+	}
+	if appData.Version == "vX.X.X" {
+		return nil
+	}
+	src, err := Source(ctx)
+	if err != nil {
+		return err
+	}
+	pinned, err := PinnedVersion(ctx)
+	if err != nil {
+		return err
+	}
+	lCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	latest, err := target(lCtx, src, pinned)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if semver.Compare(latest, appData.Version) <= 0 {
+		xlog.Debugf(ctx, "auto-update: %s is up to date", appData.Version)
+		return nil // never downgrades, even to the pinned version
+	}
+
+	uLogPath := filepath.Join(datapath.DirsFromContext(ctx).State, "update.log")
+	uLogF, err := os.OpenFile(uLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer uLogF.Close()
+	fmt.Fprintf(uLogF, "\n===== %s auto-update %s -> %s =====\n", time.Now().UTC().Format(time.RFC3339), appData.Version, latest)
+
+	// jobs started after this would only be cut short by the restart, they stay queued and the
+	// next daemon runs them. On success this one is about to exit, resuming doesn't matter.
+	if jobs.Pause != nil && jobs.Resume != nil {
+		jobs.Pause()
+		defer jobs.Resume()
+	}
+	if err := drain(ctx, w.DrainTimeout, jobs.Active, uLogF); err != nil {
+		return err
+	}
+
+	// not held while draining, so a manual update isn't kept waiting
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	if err := config.Set(ctx, "updateAvailable", false); err != nil {
+		return fmt.Errorf("failed to set updateAvailable in config: %w", err)
+	}
+	fetch := func(dir string, logf func(string, ...any)) (*release, error) {
+		return fetchRelease(ctx, src, latest, dir, false, logf)
+	}
+	err = apply(ctx, Options{
+		Detach: true,
+		OnInstalled: func(version string) {
+			notifyAdmins(ctx, fmt.Sprintf("%s updated to %s", appData.Name, latest),
+				fmt.Sprintf("%s was automatically updated from %s to %s (%s) and is restarting.\n\nRun '%s update rollback' on the host to go back.", appData.Name, appData.Version, latest, version, command(ctx)))
+		},
+	}, uLogF, fetch)
+	if err != nil {
+		fmt.Fprintf(uLogF, "Update failed: %s\n", err)
+		notifyAdmins(ctx, fmt.Sprintf("%s update to %s failed", appData.Name, latest),
+			fmt.Sprintf("The automatic update of %s from %s to %s failed, it's still running %s:\n\n%s\n\nSee %s on the host for details.", appData.Name, appData.Version, latest, appData.Version, err, uLogPath))
+		return fmt.Errorf("update to %s failed, see %s: %w", latest, uLogPath, err)
+	}
+	return nil
+}
+
+// drain waits for activeJobs to reach zero, giving up after timeout.
+func drain(ctx context.Context, timeout time.Duration, activeJobs func() int, out io.Writer) error {
+	if activeJobs == nil {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(drainPollTick)
+	defer ticker.Stop()
+	for {
+		n := activeJobs()
+		if n == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			fmt.Fprintf(out, "Drain timeout (%s) passed with %d job(s) still running, updating anyway\n", timeout, n)
+			return nil
+		}
+		fmt.Fprintf(out, "Waiting for %d running job(s) to finish ...\n", n)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// notifyAdmins emails every admin, logging failures. Does nothing if email isn't configured.
+func notifyAdmins(ctx context.Context, subject, body string) {
+	if _, _, err := email.GetConfig(ctx); err != nil {
+		if !errors.Is(err, email.ErrNotConfigured) {
+			xlog.Warnf(ctx, "auto-update: can't email admins: %s", err)
+		}
+		return
+	}
+	admins, err := users.GetAdmins(ctx)
+	if err != nil {
+		xlog.Warnf(ctx, "auto-update: failed to get admins: %s", err)
+		return
+	}
+	for _, u := range admins {
+		if u.Email == "" {
+			continue
+		}
+		if err := email.SendEmail(ctx, u.Email, subject, body); err != nil {
+			xlog.Warnf(ctx, "auto-update: failed to email %s: %s", u.Email, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+//go:build linux
+
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"ssv/go/database/config"
+	"ssv/go/system/git"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// source is where releases are listed and downloaded from, see [Source].
+type source interface {
+	// Releases lists the available releases.
+	Releases(ctx context.Context) ([]git.Release, error)
+	// Latest returns the latest stable tag without the listing, used when Releases fails.
+	Latest(ctx context.Context) (string, error)
+	// Fetch copies the asset of release tag to dst.
+	Fetch(ctx context.Context, tag, asset, dst string) error
+	String() string
+}
+
+// Source returns the configured release source, the "updateSource" config value:
+//
+//   - empty: the GitHub releases of [RepoURL]
+//   - http(s) URL: a mirror with GitHub's layout, <url>/latest redirecting to <url>/tag/<tag>,
+//     assets at <url>/download/<tag>/<asset> and the releases API JSON at <url>/releases.json
+//   - file:// URL or directory path: a local copy, assets at <dir>/download/<tag>/<asset>,
+//     with an optional <dir>/releases.json (otherwise the tag directories are the releases)
+//
+// Whatever the source, downloads go through the same checksum and signature verification.
+func Source(ctx context.Context) (source, error) {
+	s, err := config.Get[string](ctx, "updateSource")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get updateSource from config: %w", err)
+	}
+	return parseSource(s)
+}
+
+func parseSource(s string) (source, error) {
+	switch {
+	case s == "":
+		return httpSource(git.ReleasesURL(RepoURL)), nil
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		return httpSource(strings.TrimSuffix(s, "/")), nil
+	case strings.HasPrefix(s, "file://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid updateSource %q: %w", s, err)
+		}
+		return dirSource(u.Path), nil
+	case strings.Contains(s, "://"):
+		return nil, fmt.Errorf("invalid updateSource %q: unsupported scheme", s)
+	}
+	dir, err := filepath.Abs(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid updateSource %q: %w", s, err)
+	}
+	return dirSource(dir), nil
+}
+
+// httpSource is GitHub or a mirror with the same layout, the releases page URL.
+type httpSource string
+
+func (s httpSource) Releases(ctx context.Context) ([]git.Release, error) {
+	return git.ListReleases(ctx, git.APIReleasesURL(string(s)))
+}
+
+func (s httpSource) Latest(ctx context.Context) (string, error) {
+	return git.LatestReleaseTag(ctx, string(s))
+}
+
+func (s httpSource) Fetch(ctx context.Context, tag, asset, dst string) error {
+	return download(ctx, git.AssetURL(string(s), tag, asset), dst)
+}
+
+func (s httpSource) String() string { return string(s) }
+
+// dirSource is a local directory, e.g. a mounted share or a copy of the release assets.
+type dirSource string
+
+func (s dirSource) Releases(ctx context.Context) ([]git.Release, error) {
+	if data, err := os.ReadFile(filepath.Join(string(s), "releases.json")); err == nil {
+		var releases []git.Release
+		if err := json.Unmarshal(data, &releases); err != nil {
+			return nil, fmt.Errorf("failed to decode releases.json: %w", err)
+		}
+		return releases, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(filepath.Join(string(s), "download"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	var releases []git.Release
+	for _, d := range dirs {
+		if d.IsDir() && semver.IsValid(d.Name()) {
+			releases = append(releases, git.Release{TagName: d.Name(), Prerelease: semver.Prerelease(d.Name()) != ""})
+		}
+	}
+	return releases, nil
+}
+
+func (s dirSource) Latest(ctx context.Context) (string, error) {
+	return "", fmt.Errorf("no releases found in %s", string(s))
+}
+
+func (s dirSource) Fetch(ctx context.Context, tag, asset, dst string) error {
+	return copyFile(filepath.Join(string(s), "download", tag, asset), dst, 0o600)
+}
+
+func (s dirSource) String() string { return string(s) }
@@ -4,16 +4,18 @@ package update
 
 import (
 	"context"
+	"debug/buildinfo"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"ssv/go/app"
 	"ssv/go/database/config"
 	"ssv/go/database/datapath"
-	"ssv/go/system/git"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/Data-Corruption/stdx/xlog"
@@ -23,22 +25,26 @@ import (
 // Template variables ---------------------------------------------------------
 
 const (
-	RepoURL          = "https://github.com/Data-Corruption/ssv.git"
-	InstallScriptURL = "https://raw.githubusercontent.com/Data-Corruption/ssv/main/scripts/install.sh"
+	RepoURL = "https://github.com/Data-Corruption/ssv.git"
 )
 
+// PublicKey is the minisign public key releases are signed with, set by the build script.
+// Dev builds have none and can only update with [Options.Insecure].
+var PublicKey string
+
 // ----------------------------------------------------------------------------
 
 const DetachUpdateDelay = 30 * time.Second // delay between daemon initiated update attempts
 
 var (
 	updateMu   sync.Mutex
-	lastDetach time.Time = time.Now().Add(-DetachUpdateDelay)
+	applyMu    sync.Mutex // held while a release is being downloaded / installed
+	lastDetach time.Time  = time.Now().Add(-DetachUpdateDelay)
 )
 
-// Check checks if there is a newer version of the application available and updates the config accordingly.
-// It returns true if an update is available, false otherwise.
-// When running a dev build (e.g. with `vX.X.X`), it returns false without checking.
+// Check checks if there is a newer version of the application available on the configured
+// channel and updates the config accordingly. It returns true if an update is available, false otherwise.
+// When running a dev build (e.g. with `vX.X.X`) or pinned to a version, it returns false without checking.
 func Check(ctx context.Context) (bool, error) {
 	appData, ok := app.FromContext(ctx)
 	if !ok {
@@ -48,10 +54,27 @@ func Check(ctx context.Context) (bool, error) {
 		return false, nil // No version set, no update check needed
 	}
 
-	lCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	pinned, err := PinnedVersion(ctx)
+	if err != nil {
+		return false, err
+	}
+	if pinned != "" {
+		xlog.Debugf(ctx, "Pinned to %s, skipping update check", pinned)
+		return false, config.Set(ctx, "updateAvailable", false)
+	}
+	channel, err := Channel(ctx)
+	if err != nil {
+		return false, err
+	}
+	src, err := Source(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	lCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	latest, err := git.LatestGitHubReleaseTag(lCtx, RepoURL)
+	latest, err := latestRelease(lCtx, src, channel)
 	if err != nil {
 		return false, err
 	}
@@ -63,17 +86,42 @@ func Check(ctx context.Context) (bool, error) {
 	if err := config.Set(ctx, "updateAvailable", updateAvailable); err != nil {
 		return false, err
 	}
+	if err := config.Set(ctx, "latestVersion", latest); err != nil {
+		return false, err
+	}
+	if updateAvailable {
+		if err := saveNotes(lCtx, src, appData.Version, latest); err != nil {
+			xlog.Debugf(ctx, "failed to save release notes: %s", err)
+		}
+	}
 
 	return updateAvailable, nil
 }
 
-// Update checks for available updates and applies them if necessary.
-// detach is for when this is called within the app daemon (install script will shut down the daemon)
-func Update(ctx context.Context, detach bool) error {
+// Options configures [Update].
+type Options struct {
+	// Detach is for when this is called within the app daemon, the update then runs in the
+	// background, logging to update.log in the state dir, and ends with the daemon restarted.
+	Detach bool
+	// Insecure skips the release signature check, the checksum is still verified.
+	Insecure bool
+	// To is the version to install instead of the pinned version or latest on the channel.
+	To string
+	// Confirm is asked before downgrading, downgrades are refused when nil.
+	Confirm func(prompt string) (bool, error)
+	// From is a local release asset to install instead of fetching one, its .sha256 and
+	// .minisig are expected next to it.
+	From string
+	// OnInstalled is called once the new binary is verified, before the restart.
+	OnInstalled func(version string)
+}
+
+// Update checks for available updates and applies them if necessary, see [Options].
+func Update(ctx context.Context, opts Options) error {
 	updateMu.Lock()
 	defer updateMu.Unlock()
 
-	if detach && time.Since(lastDetach) < DetachUpdateDelay {
+	if opts.Detach && time.Since(lastDetach) < DetachUpdateDelay {
 		return fmt.Errorf("update already initiated recently, please wait a bit before trying again")
 	}
 
@@ -85,57 +133,211 @@ func Update(ctx context.Context, detach bool) error {
 		return nil // No version set, no update check needed
 	}
 
-	lCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if opts.From != "" {
+		return updateFrom(ctx, opts)
+	}
+
+	src, err := Source(ctx)
+	if err != nil {
+		return err
+	}
+
+	pinned, err := PinnedVersion(ctx)
+	if err != nil {
+		return err
+	}
+	want := opts.To
+	if want == "" {
+		want = pinned
+	}
+
+	lCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	latest, err := git.LatestGitHubReleaseTag(lCtx, RepoURL)
+	latest, err := target(lCtx, src, want)
 	if err != nil {
 		return err
 	}
 
-	updateAvailable := semver.Compare(latest, appData.Version) > 0
-	if !updateAvailable {
-		fmt.Println("No updates available.")
-		return nil
+	if ok, err := checkVersion(appData.Version, latest, want != "", opts.Confirm); !ok || err != nil {
+		return err
 	}
-	fmt.Println("New version available:", latest)
 
 	// update config
 	if err := config.Set(ctx, "updateAvailable", false); err != nil {
 		return fmt.Errorf("failed to set updateAvailable in config: %w", err)
 	}
 
-	// run the install command
-	pipeline := fmt.Sprintf("curl -sSfL %s | sh", InstallScriptURL)
-	xlog.Debugf(ctx, "Running update command: %s", pipeline)
-	if detach {
-		lastDetach = time.Now()
+	fetch := func(dir string, logf func(string, ...any)) (*release, error) {
+		return fetchRelease(ctx, src, latest, dir, opts.Insecure, logf)
+	}
+	if !opts.Detach {
+		return apply(ctx, opts, os.Stdout, fetch)
+	}
 
-		// get update log path
-		uLogPath := filepath.Join(datapath.FromContext(ctx), "update.log")
+	lastDetach = time.Now()
+	uLogPath := filepath.Join(datapath.DirsFromContext(ctx).State, "update.log")
+	uLogF, err := os.OpenFile(uLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	go func() {
+		defer uLogF.Close()
+		fmt.Fprintf(uLogF, "\n===== %s update %s -> %s =====\n", time.Now().UTC().Format(time.RFC3339), appData.Version, latest)
+		if err := apply(ctx, opts, uLogF, fetch); err != nil {
+			fmt.Fprintf(uLogF, "Update failed: %s\n", err)
+			xlog.Errorf(ctx, "update to %s failed, see %s: %s", latest, uLogPath, err)
+		}
+	}()
+	return nil
+}
 
-		uLogF, err := os.OpenFile(uLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-		if err != nil {
-			return fmt.Errorf("open log: %w", err)
+// fetchFunc puts a verified release into dir.
+type fetchFunc func(dir string, logf func(string, ...any)) (*release, error)
+
+// apply gets a release with fetch and installs it over the running binary, then restarts the
+// service. If the new binary fails its first run, the previous one is restored.
+func apply(ctx context.Context, opts Options, out io.Writer, fetch fetchFunc) error {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("failed to get appData from context")
+	}
+	logf := func(format string, a ...any) {
+		fmt.Fprintf(out, format+"\n", a...)
+		xlog.Infof(ctx, "update: "+format, a...)
+	}
+
+	exe, err := executable()
+	if err != nil {
+		return err
+	}
+	// same dir as the binary so the final rename is atomic
+	tmpDir, err := os.MkdirTemp(filepath.Dir(exe), "."+filepath.Base(exe)+"-update-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rel, err := fetch(tmpDir, logf)
+	if errors.Is(err, errNothingToDo) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	logf("Keeping %s for rollback ...", appData.Version)
+	if _, err := saveCurrent(ctx, exe); err != nil {
+		logf("WARNING: failed to keep the current binary for rollback: %s", err)
+	}
+
+	logf("Writing to %s ...", exe)
+	if err := swap(exe, rel.bin); err != nil {
+		return err
+	}
+
+	logf("Verifying installation (this may take a few moments if migrating) ...")
+	version, err := verifyBinary(ctx, exe)
+	if fields := strings.Fields(version); err == nil && rel.tag != "" && fields[len(fields)-1] != rel.tag {
+		err = fmt.Errorf("installed binary reports %q, expected %s", version, rel.tag)
+	}
+	if err != nil {
+		logf("Restoring previous installation ...")
+		if rErr := restorePrevious(exe); rErr != nil {
+			return fmt.Errorf("%w, and rollback failed: %w", err, rErr)
 		}
-		defer uLogF.Close()
+		return err
+	}
 
-		cmd := exec.Command("sh", "-c", pipeline)
-		cmd.Stdout, cmd.Stderr = uLogF, uLogF
-		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	logf("Installed: (%s)", version)
+	if opts.OnInstalled != nil {
+		opts.OnInstalled(version)
+	}
+	return restart(ctx, exe, opts.Detach, out)
+}
 
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("failed to start update: %w", err)
+// updateFrom installs the local release asset opts.From.
+func updateFrom(ctx context.Context, opts Options) error {
+	appData, ok := app.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("failed to get appData from context")
+	}
+	path, err := filepath.Abs(opts.From)
+	if err != nil {
+		return err
+	}
+	return apply(ctx, opts, os.Stdout, func(dir string, logf func(string, ...any)) (*release, error) {
+		rel, err := localRelease(path, dir, opts.Insecure, logf)
+		if err != nil {
+			return nil, err
+		}
+		if rel.tag == "" { // unsigned, --insecure
+			rel.tag = binaryVersion(rel.bin)
 		}
-	} else {
-		iCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
-		defer cancel()
-
-		cmd := exec.CommandContext(iCtx, "sh", "-c", pipeline)
-		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("update failed: %w", err)
+		if ok, err := checkVersion(appData.Version, rel.tag, true, opts.Confirm); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errNothingToDo
 		}
+		return rel, nil
+	})
+}
+
+// errNothingToDo is returned by a [fetchFunc] to stop [apply] without an error.
+var errNothingToDo = errors.New("nothing to do")
+
+// checkVersion reports whether next should be installed over current, asking confirm before a
+// downgrade (refused when confirm is nil). explicit is whether next was asked for (--to, a pin
+// or --from) rather than the latest release, only those may downgrade. next may be empty when
+// unknown, then confirm is asked too.
+func checkVersion(current, next string, explicit bool, confirm func(string) (bool, error)) (bool, error) {
+	var prompt string
+	switch cmp := semver.Compare(next, current); {
+	case next == "":
+		prompt = fmt.Sprintf("Can't tell the version of the new binary (running %s). Install it anyway?", current)
+	case cmp == 0:
+		fmt.Printf("Already on %s.\n", next)
+		return false, nil
+	case cmp < 0 && !explicit:
+		// e.g. running a prerelease while on the stable channel
+		fmt.Println("No updates available.")
+		return false, nil
+	case cmp < 0:
+		prompt = fmt.Sprintf("Downgrade from %s to %s? Config written by a newer version may not be readable.", current, next)
+	default:
+		fmt.Println("New version available:", next)
+		return true, nil
 	}
-	return nil
+	if confirm == nil {
+		return false, fmt.Errorf("refusing to install %q over %s without confirmation", next, current)
+	}
+	ok, err := confirm(prompt)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, fmt.Errorf("aborted, install of %q declined", next)
+	}
+	return true, nil
+}
+
+var ldflagsVersionRe = regexp.MustCompile(`main\.Version=([^'"\s]+)`)
+
+// binaryVersion returns the version a binary was built with, from the -ldflags recorded in its
+// build info, without running it. Empty if unknown.
+func binaryVersion(path string) string {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, s := range info.Settings {
+		if s.Key == "-ldflags" {
+			if m := ldflagsVersionRe.FindStringSubmatch(s.Value); m != nil && semver.IsValid(m[1]) {
+				return m[1]
+			}
+		}
+	}
+	return ""
 }
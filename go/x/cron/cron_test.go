@@ -0,0 +1,120 @@
+package cron_test
+
+import (
+	"ssv/go/x/cron"
+	"testing"
+	"time"
+)
+
+// sunday noon
+var start = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func date(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := cron.Parse(expr); err == nil {
+			t.Errorf("%q: parsed", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	for _, tc := range []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "", "2026-10-18 12:01"},
+		{"*/15 * * * *", "", "2026-10-18 12:15"},
+		{"5/20 * * * *", "", "2026-10-18 12:05"},
+		{"0-30/10 * * * *", "2026-10-18 12:31", "2026-10-18 13:00"},
+		{"0 3 * * *", "", "2026-10-19 03:00"},
+		{"30 2 1 * *", "", "2026-11-01 02:30"},
+		{"0 0 1 1 *", "", "2027-01-01 00:00"},
+		{"0 9-17/4 * * 1-5", "", "2026-10-19 09:00"},
+		{"0 13,17 * * 1-5", "2026-10-19 14:00", "2026-10-19 17:00"},
+		// 0 and 7 are both sunday
+		{"0 0 * * 0", "", "2026-10-25 00:00"},
+		{"0 0 * * 7", "", "2026-10-25 00:00"},
+		{"0 0 29 2 *", "", "2028-02-29 00:00"},
+		// both day fields restricted: either matches
+		{"0 3 1 * 1", "2026-10-20 00:00", "2026-10-26 03:00"},
+		{"0 3 1 * 0", "2026-10-26 00:00", "2026-11-01 03:00"},
+		// a field starting with * is unrestricted, the other one has to match too
+		{"0 3 */2 * 1", "2026-10-20 00:00", "2026-11-09 03:00"},
+		{"0 3 1 * */2", "", "2026-11-01 03:00"},
+		{"0 3 * * */2", "", "2026-10-20 03:00"},
+		// never
+		{"0 0 30 2 *", "", ""},
+		{"0 0 31 4 *", "", ""},
+	} {
+		from := start
+		if tc.from != "" {
+			from = date(tc.from)
+		}
+		s, err := cron.Parse(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %s", tc.expr, err)
+			continue
+		}
+		got := s.Next(from)
+		var want time.Time
+		if tc.want != "" {
+			want = date(tc.want)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%q from %s: got %s, want %s", tc.expr, from.Format(time.DateTime), got.Format(time.DateTime), want.Format(time.DateTime))
+		}
+		if tc.want != "" && !s.Matches(got) {
+			t.Errorf("%q: %s doesn't match", tc.expr, got.Format(time.DateTime))
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	s, err := cron.Parse("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		at     string
+		within time.Duration
+		want   string
+	}{
+		{"2026-10-19 03:00", 0, "2026-10-19 03:00"},
+		{"2026-10-19 04:30", 2 * time.Hour, "2026-10-19 03:00"},
+		{"2026-10-19 04:30", time.Hour, ""},
+		{"2026-10-19 02:59", 24 * time.Hour, "2026-10-18 03:00"},
+	} {
+		got := s.Prev(date(tc.at), tc.within)
+		var want time.Time
+		if tc.want != "" {
+			want = date(tc.want)
+		}
+		if !got.Equal(want) {
+			t.Errorf("at %s within %s: got %s, want %s", tc.at, tc.within, got.Format(time.DateTime), want.Format(time.DateTime))
+		}
+	}
+}
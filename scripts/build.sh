@@ -54,7 +54,8 @@ fi
 # - etc.
 
 # build
-LDFLAGS="-X 'main.Version=$version'"
+# MINISIGN_PUBLIC_KEY is the base64 line of minisign.pub, compiled in to verify updates
+LDFLAGS="-X 'main.Version=$version' -X 'ssv/go/system/update.PublicKey=${MINISIGN_PUBLIC_KEY:-}'"
 build_out="$BIN_DIR/linux-amd64"
 GO_MAIN_PATH="./go/main"
 GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go build -trimpath -buildvcs=false -ldflags="$LDFLAGS" -o "$build_out" "$GO_MAIN_PATH"
@@ -72,4 +73,15 @@ if [[ "${GITHUB_ACTIONS:-}" == "true" ]]; then
     sha256sum "$(basename "$gzip_out")" > "$(basename "$sha_out")"
   )
   echo "🟢 Generated checksum $sha_out"
+
+  # sign, MINISIGN_SECRET_KEY is the contents of the minisign secret key file
+  if [[ -z "${MINISIGN_SECRET_KEY:-}" || -z "${MINISIGN_PUBLIC_KEY:-}" ]]; then
+    echo "error: MINISIGN_SECRET_KEY and MINISIGN_PUBLIC_KEY are required to sign releases" >&2
+    exit 1
+  fi
+  key_file=$(mktemp)
+  trap 'rm -f "$key_file"' EXIT
+  printf '%s\n' "$MINISIGN_SECRET_KEY" > "$key_file"
+  printf '%s\n' "${MINISIGN_PASSWORD:-}" | minisign -S -s "$key_file" -m "$gzip_out" -t "ssv $version linux-amd64"
+  echo "🟢 Signed $gzip_out"
 fi
@@ -15,17 +15,13 @@ REPO_NAME="ssv"
 APP_NAME="ssv"
 
 SERVICE="true"
-SERVICE_DESC="web server daemon for CLI application ssv"
-SERVICE_ARGS="service run"
 
 # Constants -------------------------------------------------------------------
 APP_BIN="$HOME/.local/bin/$APP_NAME"
 APP_DATA_DIR="$HOME/.$APP_NAME"
-APP_ENV_FILE="$APP_DATA_DIR/$APP_NAME.env"
 
 SERVICE_NAME="$APP_NAME.service"
 SERVICE_FILE="$HOME/.config/systemd/user/$SERVICE_NAME"
-SERVICE_READY_TIMEOUT_SECONDS=90
 
 VERSION="${1:-latest}"
 BIN_ASSET_NAME="linux-amd64.gz"
@@ -112,9 +108,9 @@ if [ "$SERVICE" = "true" ]; then
     fi
 fi
 
-# Install Verilator if missing ------------------------------------------------
-printf "📦 Installing verilator ...\n"
-curl -fsSL https://raw.githubusercontent.com/Data-Corruption/ssv/main/scripts/install_verilator.sh | sh || printfErr "Failed to install verilator dependency"
+# Verilator build dependencies -----------------------------------------------
+printf "📦 Installing verilator build dependencies ...\n"
+curl -fsSL https://raw.githubusercontent.com/Data-Corruption/ssv/main/scripts/install_verilator.sh | sh || printfErr "Failed to install verilator build dependencies"
 
 # Create directories ---------------------------------------------------------
 printf "📦 Installing $APP_NAME $VERSION ...\n"
@@ -182,52 +178,20 @@ out=$("$APP_BIN" -v 2>/dev/null) || fatal "$APP_BIN -v exited with an error"
 effective_version=$(printf '%s\n' "$out" | awk 'NR==1{print; exit}')
 [ -n "$effective_version" ] || fatal "Failed to get effective version"
 
+# Verilator -------------------------------------------------------------------
+# only if no version is selected yet, see '$APP_NAME verilator list'
+if ! "$APP_BIN" verilator list 2>/dev/null | grep -q '(current)'; then
+    printf "📦 Installing verilator ...\n"
+    "$APP_BIN" verilator install || printfErr "Failed to install verilator, retry with '$APP_NAME verilator install'"
+fi
+
 # Service --------------------------------------------------------------------
 if [ "$SERVICE" = "true" ]; then
     [ "$service_exists" -eq 1 ] && printf "Updating service ...\n" || printf "Setting up service ...\n"
 
-    # Escape % -> %% in args (no ${var//%/%%} in POSIX)
-    safe_args=$(printf '%s' "$SERVICE_ARGS" | sed 's/%/%%/g') || fatal "Failed to escape service args"
-
-    # Write unit file
-    {
-        printf '%s\n' "[Unit]"
-        printf 'Description=%s\n' "$SERVICE_DESC"
-        printf '%s\n' "StartLimitIntervalSec=600"
-        printf '%s\n' "StartLimitBurst=5"
-        printf '%s\n' "# FYI: network-online.target is kinda fucked in the user manager for some reason."
-        printf '%s\n' "# Using in case it works. App will still handle unready net starts gracefully with retries."
-        printf '%s\n' "Wants=network-online.target"
-        printf '%s\n' "After=network-online.target"
-        printf '%s\n' ""
-        printf '%s\n' "[Service]"
-        printf '%s\n' "Type=notify"
-        printf 'ExecStart=%s %s\n' "$APP_BIN" "$safe_args"
-        printf 'WorkingDirectory=%s\n' "$APP_DATA_DIR"
-        printf '%s\n' "Restart=always"
-        printf '%s\n' "RestartSec=3"
-        printf '%s\n' "LimitNOFILE=65535"
-        printf 'TimeoutStartSec=%ss\n' "$SERVICE_READY_TIMEOUT_SECONDS"
-        printf '%s\n' "RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK"
-        printf '%s\n' "Environment=PATH=%h/.local/bin:/usr/local/bin:/usr/bin:/bin"
-        printf 'EnvironmentFile=-%s\n' "$APP_ENV_FILE"
-        printf '%s\n' ""
-        printf '%s\n' "[Install]"
-        printf '%s\n' "WantedBy=default.target"
-    } > "$SERVICE_FILE" || fatal "Failed to write service unit file"
-
-    systemctl --user daemon-reload || fatal "Failed to reload systemd daemon"
-    systemctl --user enable "$SERVICE_NAME" || fatal "Failed to enable service"
-    systemctl --user reset-failed "$SERVICE_NAME" || :
-
-    # Start/restart service (will block until service reports ready or timeout)
-    if systemctl --user is-active --quiet "$SERVICE_NAME"; then
-        printf "Restarting service ...\n"
-        systemctl --user restart "$SERVICE_NAME" || fatal "Failed to restart service"
-    else
-        printf "Starting service ...\n"
-        systemctl --user start "$SERVICE_NAME" || fatal "Failed to start service"
-    fi
+    # Unit rendering, enable and start/restart (blocks until the service reports ready or times out)
+    # live in the binary so they can't drift from what '$APP_NAME service install' expects.
+    "$APP_BIN" --yes service install || fatal "Failed to install service"
 fi
 
 # Success! --------------------------------------------------------------------